@@ -4,6 +4,9 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"os"
+	"strings"
 
 	"filippo.io/age"
 )
@@ -28,9 +31,105 @@ func (a *app) encrypt(s string) ([]byte, error) {
 	return out.Bytes(), nil
 }
 
-// decrypt decrypts the given slice of bytes.
+// parseKeyRing parses the identities of the key ring, they are separated by
+// commas or newlines and lines starting with # are ignored. One of the
+// identities must belong to the current public key, the others are older
+// identities that are only used for decryption.
+func parseKeyRing(pubKey, privKeys string) ([]age.Identity, error) {
+	identities, err := age.ParseIdentities(strings.NewReader(strings.Replace(privKeys, ",", "\n", -1)))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, identity := range identities {
+		if i, ok := identity.(*age.X25519Identity); ok && i.Recipient().String() == pubKey {
+			return identities, nil
+		}
+	}
+
+	return nil, fmt.Errorf("none of the private keys belongs to the public key")
+}
+
+// currentIdentity returns the identity of the key ring that belongs to the
+// current public key.
+func (a *app) currentIdentity() age.Identity {
+	for _, identity := range a.identities {
+		if i, ok := identity.(*age.X25519Identity); ok && i.Recipient().String() == a.recipient.String() {
+			return i
+		}
+	}
+
+	return nil
+}
+
+// publicKeyComment is the prefix of the comment that age-keygen writes the
+// public key to.
+const publicKeyComment = "# public key:"
+
+// readPrivKeyFile reads the private keys of an age-keygen file, the file may
+// contain several keys. The keys are returned separated by commas together
+// with the public key of the last "# public key:" comment of the file. The
+// file is refused if it's readable by group or others.
+func readPrivKeyFile(path string) (string, string, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return "", "", err
+	}
+	if fi.Mode().Perm()&0044 != 0 {
+		return "", "", fmt.Errorf("%s is readable by group or others, it needs to have a mode of 0600 or 0400", path)
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", "", err
+	}
+
+	var privKeys []string
+	var pubKey string
+	for _, l := range strings.Split(string(b), "\n") {
+		l = strings.TrimSpace(l)
+		switch {
+		case strings.HasPrefix(l, publicKeyComment):
+			pubKey = strings.TrimSpace(strings.TrimPrefix(l, publicKeyComment))
+		case l == "" || strings.HasPrefix(l, "#"):
+			continue
+		default:
+			privKeys = append(privKeys, l)
+		}
+	}
+	if len(privKeys) == 0 {
+		return "", "", fmt.Errorf("%s doesn't contain any private keys", path)
+	}
+
+	return strings.Join(privKeys, ","), pubKey, nil
+}
+
+// readPubKeyFile reads the public key of an age-keygen file, where it's
+// found in the "# public key:" comment, or of a recipients file where the
+// first line that isn't a comment is used.
+func readPubKeyFile(path string) (string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	for _, l := range strings.Split(string(b), "\n") {
+		l = strings.TrimSpace(l)
+		switch {
+		case strings.HasPrefix(l, publicKeyComment):
+			return strings.TrimSpace(strings.TrimPrefix(l, publicKeyComment)), nil
+		case l != "" && !strings.HasPrefix(l, "#"):
+			return l, nil
+		}
+	}
+
+	return "", fmt.Errorf("%s doesn't contain a public key", path)
+}
+
+// decrypt decrypts the given slice of bytes with any of the identities of the
+// key ring.
 func (a *app) decrypt(b []byte) ([]byte, error) {
-	r, err := age.Decrypt(bytes.NewReader(b), a.identity)
+	r, err := age.Decrypt(bytes.NewReader(b), a.identities...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open encrypted file: %v", err)
 	}
@@ -42,3 +141,121 @@ func (a *app) decrypt(b []byte) ([]byte, error) {
 
 	return out.Bytes(), nil
 }
+
+const (
+	// encryptionNone is used for dumps that are stored in plaintext, which
+	// is the case for all dumps that were created before the contents
+	// were encrypted.
+	encryptionNone = "none"
+
+	// encryptionServer is used for dumps that are encrypted with the
+	// public key of the server.
+	encryptionServer = "server"
+
+	// encryptionPassword is used for dumps that are encrypted with the
+	// basic auth password of the dump, the server is unable to decrypt
+	// them without the password.
+	encryptionPassword = "password"
+)
+
+// scryptWorkFactor is the scrypt work factor of password encrypted dumps.
+// The key is derived on every download, so it's lower than the default of
+// age to keep the memory and time that is spent on a download reasonable.
+const scryptWorkFactor = 15
+
+// newPasswordRecipient returns the recipient that encrypts the contents of
+// a password encrypted dump.
+func newPasswordRecipient(password string) (age.Recipient, error) {
+	r, err := age.NewScryptRecipient(password)
+	if err != nil {
+		return nil, err
+	}
+	r.SetWorkFactor(scryptWorkFactor)
+
+	return r, nil
+}
+
+// newPasswordIdentity returns the identity that decrypts the contents of a
+// password encrypted dump.
+func newPasswordIdentity(password string) (age.Identity, error) {
+	i, err := age.NewScryptIdentity(password)
+	if err != nil {
+		return nil, err
+	}
+	i.SetMaxWorkFactor(scryptWorkFactor)
+
+	return i, nil
+}
+
+// encryptionName returns the name of the encryption of the dump as it's
+// presented to users. Client encrypted dumps are also encrypted by the server,
+// but only the client is able to read them.
+func encryptionName(du *dump) string {
+	if du.clientEncrypted {
+		return "client"
+	}
+	if du.encryption == encryptionPassword {
+		return "password"
+	}
+
+	return du.encryption
+}
+
+// ageHeaders are the beginnings of binary and armored age encrypted files.
+var ageHeaders = [][]byte{
+	[]byte("age-encryption.org/v1\n"),
+	[]byte("-----BEGIN AGE ENCRYPTED FILE-----"),
+}
+
+// isAgeEncrypted returns true if the contents that begins with head is an age
+// encrypted file. Such dumps are encrypted by the client and the server is
+// unable to read them, they are marked as client encrypted so that browsers
+// can be sent to the page that decrypts them.
+func isAgeEncrypted(head []byte) bool {
+	for _, h := range ageHeaders {
+		if bytes.HasPrefix(head, h) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// dumpIdentities returns the identities that decrypts the contents of the
+// dump, nil is returned for dumps that are stored in plaintext. Password
+// encrypted dumps are decrypted with the identity of the dump, which is set
+// when the credentials of the request has been verified.
+func (a *app) dumpIdentities(du *dump) ([]age.Identity, error) {
+	switch du.encryption {
+	case encryptionNone:
+		return nil, nil
+	case encryptionServer:
+		return a.identities, nil
+	case encryptionPassword:
+		if du.identity == nil {
+			return nil, fmt.Errorf("no password to decrypt %s with", du.publicID)
+		}
+		return []age.Identity{du.identity}, nil
+	default:
+		return nil, fmt.Errorf("unknown encryption %q", du.encryption)
+	}
+}
+
+// decryptStream returns a reader that decrypts the contents of the given
+// reader according to the encryption that the dump is stored with.
+func (a *app) decryptStream(r io.Reader, du *dump) (io.Reader, error) {
+	identities, err := a.dumpIdentities(du)
+	if err != nil {
+		return nil, err
+	}
+	if identities == nil {
+		return r, nil
+	}
+
+	dr, err := age.Decrypt(r, identities...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open encrypted file: %v", err)
+	}
+
+	return dr, nil
+}
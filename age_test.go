@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"filippo.io/age"
+)
+
+func TestParseKeyRing(t *testing.T) {
+	current, _ := age.GenerateX25519Identity()
+	old, _ := age.GenerateX25519Identity()
+	pubKey := current.Recipient().String()
+
+	identities, err := parseKeyRing(pubKey, old.String()+","+current.String())
+	if err != nil || len(identities) != 2 {
+		t.Errorf("got %v, %v", identities, err)
+	}
+
+	for _, privKeys := range []string{"", "foo", old.String(), old.String() + ",foo"} {
+		if _, err := parseKeyRing(pubKey, privKeys); err == nil {
+			t.Errorf("%q: expected an error", privKeys)
+		}
+	}
+}
+
+// keygenOutput returns the identity formatted like the output of age-keygen.
+func keygenOutput(i *age.X25519Identity) string {
+	return fmt.Sprintf("# created: 2021-01-01T00:00:00Z\n# public key: %s\n%s\n", i.Recipient(), i)
+}
+
+func TestReadKeyFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dumpinen-keys-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	old, _ := age.GenerateX25519Identity()
+	current, _ := age.GenerateX25519Identity()
+	path := filepath.Join(dir, "agekey.txt")
+	if err = ioutil.WriteFile(path, []byte(keygenOutput(old)+keygenOutput(current)), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// The public key of the last key of the file is the current one.
+	privKeys, pubKey, err := readPrivKeyFile(path)
+	if err != nil || privKeys != old.String()+","+current.String() || pubKey != current.Recipient().String() {
+		t.Errorf("got %q %q, %v", privKeys, pubKey, err)
+	}
+	if _, err = parseKeyRing(pubKey, privKeys); err != nil {
+		t.Errorf("parseKeyRing: %v", err)
+	}
+	if pubKey, err = readPubKeyFile(path); err != nil || pubKey != old.Recipient().String() {
+		t.Errorf("readPubKeyFile: got %q, %v", pubKey, err)
+	}
+
+	recipients := filepath.Join(dir, "recipients.txt")
+	if err = ioutil.WriteFile(recipients, []byte("# comment\n"+current.Recipient().String()+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if pubKey, err = readPubKeyFile(recipients); err != nil || pubKey != current.Recipient().String() {
+		t.Errorf("readPubKeyFile: got %q, %v", pubKey, err)
+	}
+
+	// Key files that others are able to read are refused.
+	for _, mode := range []os.FileMode{0640, 0604} {
+		if err = os.Chmod(path, mode); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err = readPrivKeyFile(path); err == nil {
+			t.Errorf("%o: expected an error", mode)
+		}
+	}
+	empty := filepath.Join(dir, "empty.txt")
+	if err = ioutil.WriteFile(empty, []byte("# public key: "+current.Recipient().String()+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = readPrivKeyFile(empty); err == nil {
+		t.Errorf("expected an error for a file without private keys")
+	}
+}
+
+func TestRekey(t *testing.T) {
+	a, repo := newTestApp(t, false)
+
+	// Protect a few dumps with the credentials encrypted with the old key,
+	// the way they were stored before the credentials were hashed.
+	var ids, filesystemIDs []string
+	for i := 0; i < 3; i++ {
+		path, _ := uploadDump(t, a, "/", "foo")
+		du, _ := repo.getDumpByPublicID(path[1:])
+		username, _ := a.encrypt("user")
+		password, _ := a.encrypt("pass")
+		if err := repo.updateDumpCredentials(du.id, username, password); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, du.id)
+		filesystemIDs = append(filesystemIDs, du.filesystemID)
+	}
+
+	old := a.identities[0].(*age.X25519Identity)
+	current, _ := age.GenerateX25519Identity()
+	var err error
+	if a.identities, err = parseKeyRing(current.Recipient().String(), old.String()+","+current.String()); err != nil {
+		t.Fatal(err)
+	}
+	a.recipient = current.Recipient()
+
+	if err = a.rekey(); err != nil {
+		t.Fatalf("rekey: %v", err)
+	}
+
+	// Dumps that are already encrypted with the current key are skipped.
+	path, _ := uploadDump(t, a, "/", "bar")
+	fresh, _ := repo.getDumpByPublicID(path[1:])
+	if err = a.rekey(); err != nil {
+		t.Fatalf("rekey again: %v", err)
+	}
+	if du, _ := repo.getDumpByPublicID(path[1:]); du.filesystemID != fresh.filesystemID {
+		t.Errorf("a dump encrypted with the current key was rewritten")
+	}
+
+	// The credentials and the contents must be possible to decrypt once the
+	// old key has been removed from the key ring.
+	a.identities = []age.Identity{current}
+	for i, id := range ids {
+		du := repo.findDump(func(d *dump) bool { return d.id == id })
+		if du.filesystemID == filesystemIDs[i] {
+			t.Errorf("the contents weren't rewritten")
+		}
+		if _, err := a.store.Stat(filesystemIDs[i]); err != errBlobNotFound {
+			t.Errorf("old file: got %v", err)
+		}
+		if u, err := a.decrypt(*du.username); err != nil || string(u) != "user" {
+			t.Errorf("username: got %q, %v", u, err)
+		}
+		if p, err := a.decrypt(*du.password); err != nil || string(p) != "pass" {
+			t.Errorf("password: got %q, %v", p, err)
+		}
+
+		// A successful login replaces the encrypted credentials with a
+		// hash, so the contents are checked last.
+		res := request(a, http.MethodGet, "/"+du.publicID, nil, "Authorization", basicAuth("user", "pass"))
+		if res.Code != http.StatusOK || res.Body.String() != "foo" {
+			t.Errorf("got %d %q", res.Code, res.Body.String())
+		}
+	}
+}
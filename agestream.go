@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"filippo.io/age"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	// streamChunkSize is the size of the plaintext chunks of the payload
+	// of an age encrypted file, each chunk is encrypted and authenticated
+	// on its own.
+	streamChunkSize = 64 * 1024
+
+	// streamNonceSize is the size of the nonce that precedes the payload.
+	streamNonceSize = 16
+
+	// maxStreamHeaderSize limits how much is read while looking for the end
+	// of the header of an age encrypted file.
+	maxStreamHeaderSize = 64 * 1024
+)
+
+// fileKeyIdentity keeps the file key that the identity unwraps, age doesn't
+// expose it but it's needed to decrypt the payload from an arbitrary chunk.
+type fileKeyIdentity struct {
+	age.Identity
+	fileKey []byte
+}
+
+// Unwrap unwraps the file key with the identity and keeps it.
+func (i *fileKeyIdentity) Unwrap(stanzas []*age.Stanza) ([]byte, error) {
+	fileKey, err := i.Identity.Unwrap(stanzas)
+	if err == nil {
+		i.fileKey = fileKey
+	}
+
+	return fileKey, err
+}
+
+// decryptStreamAt returns a reader that decrypts the contents of the given
+// reader from the offset of the decrypted contents. The header is verified
+// by age, and the payload is decrypted from the chunk that contains the
+// offset, so a seek only costs the decryption of a single chunk. The chunks
+// before it are skipped with a seek if the reader supports it, otherwise
+// they are read without being decrypted.
+func (a *app) decryptStreamAt(r io.Reader, du *dump, offset int64) (io.Reader, error) {
+	identities, err := a.dumpIdentities(du)
+	if err != nil {
+		return nil, err
+	}
+	if identities == nil {
+		return nil, errors.New("the contents aren't encrypted")
+	}
+
+	// The header ends with the line that contains its MAC.
+	br := bufio.NewReaderSize(r, streamChunkSize+chacha20poly1305.Overhead)
+	var header []byte
+	for {
+		line, err := br.ReadSlice('\n')
+		if err != nil {
+			return nil, fmt.Errorf("failed to read header: %v", err)
+		}
+		if header = append(header, line...); len(header) > maxStreamHeaderSize {
+			return nil, errors.New("failed to read header: the header is too large")
+		}
+		if bytes.HasPrefix(line, []byte("---")) {
+			break
+		}
+	}
+
+	nonce := make([]byte, streamNonceSize)
+	if _, err = io.ReadFull(br, nonce); err != nil {
+		return nil, fmt.Errorf("failed to read nonce: %v", err)
+	}
+
+	// Let age unwrap the file key and verify the MAC of the header.
+	wrapped := make([]age.Identity, len(identities))
+	for i, identity := range identities {
+		wrapped[i] = &fileKeyIdentity{Identity: identity}
+	}
+	if _, err = age.Decrypt(io.MultiReader(bytes.NewReader(header), bytes.NewReader(nonce)), wrapped...); err != nil {
+		return nil, fmt.Errorf("failed to open encrypted file: %v", err)
+	}
+	var fileKey []byte
+	for _, identity := range wrapped {
+		if k := identity.(*fileKeyIdentity).fileKey; k != nil {
+			fileKey = k
+		}
+	}
+
+	key := make([]byte, chacha20poly1305.KeySize)
+	if _, err = io.ReadFull(hkdf.New(sha256.New, fileKey, nonce, []byte("payload")), key); err != nil {
+		return nil, err
+	}
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, err
+	}
+
+	chunk := offset / streamChunkSize
+	skip := chunk * (streamChunkSize + chacha20poly1305.Overhead)
+	if s, ok := r.(io.Seeker); ok {
+		if _, err = s.Seek(int64(len(header))+streamNonceSize+skip, io.SeekStart); err != nil {
+			return nil, err
+		}
+		br.Reset(r)
+	} else if _, err = io.CopyN(ioutil.Discard, br, skip); err != nil {
+		return nil, err
+	}
+
+	sr := &streamReader{
+		src:     br,
+		aead:    aead,
+		counter: uint64(chunk),
+		buf:     make([]byte, streamChunkSize+chacha20poly1305.Overhead),
+	}
+	if _, err = io.CopyN(ioutil.Discard, sr, offset-chunk*streamChunkSize); err != nil {
+		return nil, err
+	}
+
+	return sr, nil
+}
+
+// streamReader decrypts the chunks of the payload of an age encrypted file,
+// starting with the chunk of the counter.
+type streamReader struct {
+	src     *bufio.Reader
+	aead    cipher.AEAD
+	counter uint64
+	buf     []byte
+	chunk   []byte
+	last    bool
+}
+
+// Read reads from the decrypted chunk, the next chunk is decrypted when the
+// current one has been read.
+func (sr *streamReader) Read(p []byte) (int, error) {
+	for len(sr.chunk) == 0 {
+		if sr.last {
+			return 0, io.EOF
+		}
+		if err := sr.next(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, sr.chunk)
+	sr.chunk = sr.chunk[n:]
+	return n, nil
+}
+
+// next decrypts the next chunk. The last chunk is the one that is shorter
+// than a full chunk or that is followed by the end of the file, and it's
+// only authenticated if it was encrypted as the last chunk.
+func (sr *streamReader) next() error {
+	n, err := io.ReadFull(sr.src, sr.buf)
+	switch err {
+	case nil:
+		if _, err = sr.src.Peek(1); err == io.EOF {
+			sr.last = true
+		} else if err != nil {
+			return err
+		}
+	case io.ErrUnexpectedEOF:
+		sr.last = true
+	case io.EOF:
+		return io.ErrUnexpectedEOF
+	default:
+		return err
+	}
+
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	binary.BigEndian.PutUint64(nonce[3:11], sr.counter)
+	if sr.last {
+		nonce[11] = 1
+	}
+
+	chunk, err := sr.aead.Open(sr.buf[:0], nonce, sr.buf[:n], nil)
+	if err != nil {
+		return errors.New("failed to decrypt and authenticate payload chunk")
+	}
+	if sr.last && len(chunk) == 0 && sr.counter > 0 {
+		return errors.New("the last chunk is empty")
+	}
+
+	sr.counter++
+	sr.chunk = chunk
+	return nil
+}
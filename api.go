@@ -0,0 +1,315 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// apiPath is the path prefix of the versioned JSON API.
+const apiPath = "/api/v1/"
+
+// minOwnerKeyLength is the minimum length of the owner key that is used to
+// list the dumps that was created by a client.
+const minOwnerKeyLength = 16
+
+// errInvalidOwnerKey is returned when the owner key is too short.
+var errInvalidOwnerKey = errors.New("invalid owner key")
+
+// apiError is the error body of the JSON API. The code is machine readable
+// and will not change, the message is meant for humans.
+type apiError struct {
+	status  int
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+var (
+	apiErrNotFound                = &apiError{http.StatusNotFound, "not_found", "not found"}
+	apiErrMethodNotAllowed        = &apiError{http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed"}
+	apiErrNotAcceptable           = &apiError{http.StatusNotAcceptable, "not_acceptable", "only application/json responses are available"}
+	apiErrUnauthorized            = &apiError{http.StatusUnauthorized, "unauthorized", "valid basic auth credentials are required"}
+	apiErrInvalidDeleteToken      = &apiError{http.StatusForbidden, "invalid_delete_token", "invalid delete token"}
+	apiErrInvalidDeleteAfter      = &apiError{http.StatusBadRequest, "invalid_delete_after", "invalid deleteAfter duration"}
+	apiErrInvalidExpiresAt        = &apiError{http.StatusBadRequest, "invalid_expires_at", "expiresAt must be a future RFC 3339 timestamp and can't be combined with deleteAfter"}
+	apiErrExpiryNotAllowed        = &apiError{http.StatusBadRequest, "expiry_not_allowed", "the expiry is longer than the server allows"}
+	apiErrInvalidExpireAfterIdle  = &apiError{http.StatusBadRequest, "invalid_expire_after_idle", "invalid expireAfterIdle duration"}
+	apiErrInvalidMaxDownloads     = &apiError{http.StatusBadRequest, "invalid_max_downloads", "invalid maxDownloads value"}
+	apiErrEncryptRequiresPassword = &apiError{http.StatusBadRequest, "encrypt_requires_password", "encrypt requires basic auth credentials"}
+	apiErrProtectionLocked        = &apiError{http.StatusBadRequest, "protection_locked", "the protection of a password encrypted dump can't be changed"}
+	apiErrInvalidSlug             = &apiError{http.StatusBadRequest, "invalid_slug", "the slug doesn't match the slug pattern or is reserved"}
+	apiErrSlugTaken               = &apiError{http.StatusConflict, "slug_taken", "the slug is already taken"}
+	apiErrInvalidOwnerKey         = &apiError{http.StatusBadRequest, "invalid_owner_key", fmt.Sprintf("the X-Owner-Key header must be at least %d characters", minOwnerKeyLength)}
+	apiErrOwnerKeyRequired        = &apiError{http.StatusBadRequest, "owner_key_required", "the X-Owner-Key header is required"}
+	apiErrInvalidForm             = &apiError{http.StatusBadRequest, "invalid_form", "invalid form"}
+	apiErrEmptyPayload            = &apiError{http.StatusBadRequest, "empty_payload", "empty request payload"}
+	apiErrPayloadTooLarge         = &apiError{http.StatusRequestEntityTooLarge, "payload_too_large", "request body too large"}
+	apiErrRateLimited             = &apiError{http.StatusTooManyRequests, "rate_limited", "too many requests, try again later"}
+	apiErrInternalServerError     = &apiError{http.StatusInternalServerError, "internal_error", "internal server error occured, try again later"}
+)
+
+// writeAPIError writes the error as a JSON body.
+func writeAPIError(w http.ResponseWriter, e *apiError) {
+	if e == apiErrUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Basic realm="restricted area"`)
+	}
+	writeJSON(w, e.status, map[string]*apiError{"error": e})
+}
+
+// toAPIError maps the errors that are returned by the dump operations to API
+// errors, unknown errors are logged and reported as internal errors.
+func toAPIError(err error) *apiError {
+	switch err {
+	case errDumpNotFound:
+		return apiErrNotFound
+	case errUnauthorized:
+		return apiErrUnauthorized
+	case errInvalidDeleteToken:
+		return apiErrInvalidDeleteToken
+	case errInvalidDeleteAfter:
+		return apiErrInvalidDeleteAfter
+	case errInvalidExpiresAt:
+		return apiErrInvalidExpiresAt
+	case errExpiryNotAllowed:
+		return apiErrExpiryNotAllowed
+	case errInvalidExpireAfterIdle:
+		return apiErrInvalidExpireAfterIdle
+	case errInvalidMaxDownloads:
+		return apiErrInvalidMaxDownloads
+	case errEncryptRequiresPassword:
+		return apiErrEncryptRequiresPassword
+	case errProtectionLocked:
+		return apiErrProtectionLocked
+	case errInvalidSlug:
+		return apiErrInvalidSlug
+	case errDuplicateSlug:
+		return apiErrSlugTaken
+	case errInvalidOwnerKey:
+		return apiErrInvalidOwnerKey
+	case errEmptyUpload:
+		return apiErrEmptyPayload
+	}
+	if isRequestTooLarge(err) {
+		return apiErrPayloadTooLarge
+	}
+
+	log.Printf("api error: %v\n", err)
+	return apiErrInternalServerError
+}
+
+// acceptsJSONResponse returns true if the client accepts a JSON response, a
+// missing Accept header means that anything is accepted.
+func acceptsJSONResponse(r *http.Request) bool {
+	accept := r.Header.Get("Accept")
+	return accept == "" ||
+		strings.Contains(accept, "application/json") ||
+		strings.Contains(accept, "application/*") ||
+		strings.Contains(accept, "*/*")
+}
+
+// ownerKeyHash returns the hash of the X-Owner-Key header, nil is returned if
+// the header is missing.
+func ownerKeyHash(r *http.Request) (*string, error) {
+	key := r.Header.Get("X-Owner-Key")
+	if key == "" {
+		return nil, nil
+	}
+	if len(key) < minOwnerKeyLength {
+		return nil, errInvalidOwnerKey
+	}
+
+	hash := hashToken(key)
+	return &hash, nil
+}
+
+// startAPI sets the CORS headers of the API and makes sure that the client
+// accepts a JSON response if negotiate is true. False is returned if an
+// error response has been written.
+func startAPI(w http.ResponseWriter, r *http.Request, negotiate bool) bool {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Expose-Headers", "Location, X-Delete-Token")
+
+	if negotiate && !acceptsJSONResponse(r) {
+		writeAPIError(w, apiErrNotAcceptable)
+		return false
+	}
+
+	return true
+}
+
+// routeAPINotMatched handles the API requests that didn't match any route,
+// the methods are the methods of the routes that matched the path. CORS
+// preflight requests are answered here as well.
+func (a *app) routeAPINotMatched(w http.ResponseWriter, r *http.Request, methods []string) {
+	startAPI(w, r, false)
+
+	if len(methods) == 0 {
+		writeAPIError(w, apiErrNotFound)
+		return
+	}
+
+	allow := strings.Join(methods, ", ")
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Access-Control-Allow-Methods", allow)
+		w.Header().Set("Access-Control-Allow-Headers", "Accept, Authorization, Content-Type, Content-Length, X-Delete-Token, X-Owner-Key")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.Header().Set("Allow", allow)
+	writeAPIError(w, apiErrMethodNotAllowed)
+}
+
+// routeAPICreate creates a new dump from the request body. The options are
+// the same query parameters as for POST /, and if a X-Owner-Key header is
+// given the dump will show up when the dumps of the owner are listed.
+func (a *app) routeAPICreate(w http.ResponseWriter, r *http.Request) {
+	log.Printf("api dump post request from %s\n", a.clientIP(r))
+	if !startAPI(w, r, true) {
+		return
+	}
+
+	o, err := a.parseDumpOptions(r)
+	if err != nil {
+		writeAPIError(w, toAPIError(err))
+		return
+	}
+	if o.ownerKeyHash, err = ownerKeyHash(r); err != nil {
+		writeAPIError(w, toAPIError(err))
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, a.maxFileSize)
+	up, err := a.newUpload(r.Body, o.recipient)
+	if err != nil {
+		writeAPIError(w, toAPIError(err))
+		return
+	}
+	defer up.blob.Abort()
+
+	du, deleteToken, err := a.storeDump(up, o)
+	if err != nil {
+		writeAPIError(w, toAPIError(err))
+		return
+	}
+
+	log.Printf("dump stored with public id at %s\n", du.publicID)
+	res := a.newDumpResource(r, du)
+	res.DeleteToken = deleteToken
+	w.Header().Set("Location", a.pathPrefix()+apiPath+"dumps/"+du.publicID)
+	w.Header().Set("X-Delete-Token", deleteToken)
+	writeJSON(w, http.StatusCreated, res)
+}
+
+// routeAPIList lists the dumps that was created with the owner key in the
+// X-Owner-Key header.
+func (a *app) routeAPIList(w http.ResponseWriter, r *http.Request) {
+	if !startAPI(w, r, true) {
+		return
+	}
+
+	hash, err := ownerKeyHash(r)
+	if err != nil {
+		writeAPIError(w, toAPIError(err))
+		return
+	}
+	if hash == nil {
+		writeAPIError(w, apiErrOwnerKeyRequired)
+		return
+	}
+
+	dumps, err := a.db.getDumpsByOwnerKeyHash(*hash)
+	if err != nil {
+		writeAPIError(w, toAPIError(err))
+		return
+	}
+
+	res := make([]*dumpResource, 0, len(dumps))
+	for _, du := range dumps {
+		res = append(res, a.newDumpResource(r, du))
+	}
+	writeJSON(w, http.StatusOK, map[string][]*dumpResource{"dumps": res})
+}
+
+// routeAPIGet returns the metadata of a dump together with the number of
+// times it has been downloaded.
+func (a *app) routeAPIGet(w http.ResponseWriter, r *http.Request) {
+	if !startAPI(w, r, true) {
+		return
+	}
+
+	publicID := pathParam(r)
+	du, err := a.getDumpForRequest(r, publicID)
+	if err != nil {
+		writeAPIError(w, toAPIError(err))
+		return
+	}
+
+	info, err := a.db.getDumpInfoByPublicID(du.publicID)
+	if err != nil {
+		writeAPIError(w, toAPIError(err))
+		return
+	}
+
+	res := a.newDumpResource(r, du)
+	res.Count = &info.count
+	writeJSON(w, http.StatusOK, res)
+}
+
+// routeAPIUpdate updates a dump, the delete token is given in the
+// X-Delete-Token header and the values are the same as for PATCH /:id.
+func (a *app) routeAPIUpdate(w http.ResponseWriter, r *http.Request) {
+	if !startAPI(w, r, true) {
+		return
+	}
+
+	publicID := pathParam(r)
+	if err := r.ParseForm(); err != nil {
+		writeAPIError(w, apiErrInvalidForm)
+		return
+	}
+
+	du, err := a.updateDumpWithToken(publicID, ownerToken(r), r.Form)
+	if err != nil {
+		writeAPIError(w, toAPIError(err))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, a.newDumpResource(r, du))
+}
+
+// routeAPIDelete deletes a dump, the delete token is given in the
+// X-Delete-Token header.
+func (a *app) routeAPIDelete(w http.ResponseWriter, r *http.Request) {
+	if !startAPI(w, r, true) {
+		return
+	}
+
+	publicID := pathParam(r)
+	if err := a.deleteDumpWithToken(publicID, ownerToken(r)); err != nil {
+		writeAPIError(w, toAPIError(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// routeAPIContent serves the contents of a dump.
+func (a *app) routeAPIContent(w http.ResponseWriter, r *http.Request) {
+	if !startAPI(w, r, false) {
+		return
+	}
+
+	publicID := pathParam(r)
+	du, err := a.getDumpForRequest(r, publicID)
+	if err != nil {
+		writeAPIError(w, toAPIError(err))
+		return
+	}
+
+	if err = a.serveDump(w, r, du); err != nil {
+		writeAPIError(w, toAPIError(err))
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// parseBaseURL parses the public base URL of the server, e.g.
+// https://example.com/paste. The trailing slash of the path is removed and
+// nil is returned if it's empty.
+func parseBaseURL(s string) (*url.URL, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base URL %q", s)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid base URL %q, it must be an absolute http or https URL", s)
+	}
+	if u.User != nil || u.RawQuery != "" || u.Fragment != "" {
+		return nil, fmt.Errorf("invalid base URL %q, it can't have credentials, a query or a fragment", s)
+	}
+
+	u.Path = strings.TrimRight(u.Path, "/")
+	u.RawPath = ""
+	return u, nil
+}
+
+// pathPrefix returns the path that the server is mounted under, it is empty
+// when the server is mounted at the root.
+func (a *app) pathPrefix() string {
+	if a.baseURL == nil {
+		return ""
+	}
+
+	return a.baseURL.Path
+}
+
+// stripPathPrefix returns the request with the path prefix removed from the
+// URL. Requests without the prefix are returned as they are, so that the
+// server works both with proxies that keep the prefix and with those that
+// remove it.
+func (a *app) stripPathPrefix(r *http.Request) *http.Request {
+	p := a.pathPrefix()
+	if p == "" || (r.URL.Path != p && !strings.HasPrefix(r.URL.Path, p+"/")) {
+		return r
+	}
+
+	r2 := new(http.Request)
+	*r2 = *r
+	r2.URL = new(url.URL)
+	*r2.URL = *r.URL
+	r2.URL.Path = "/" + strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, p), "/")
+	r2.URL.RawPath = ""
+	return r2
+}
+
+// forwardedValue returns the value of the parameter of the Forwarded header,
+// or of the X-Forwarded header if there is no Forwarded header, that was
+// added by the proxy that the client connected to. The hops are walked from
+// the closest proxy like in clientIP, so a value that the client sent is
+// never used as long as the proxies add their own values. The X-Forwarded
+// values are only matched with the X-Forwarded-For addresses when there are
+// as many of them, otherwise the value of the closest proxy is used.
+func (a *app) forwardedValue(h http.Header, param, header string) string {
+	if elems := forwardedElements(h); len(elems) > 0 {
+		var v string
+		for i := len(elems) - 1; i >= 0; i-- {
+			v = elems[i][param]
+			ip := net.ParseIP(forwardedAddr(elems[i]["for"]))
+			if ip == nil || !containsIP(a.trustedProxies, ip) {
+				break
+			}
+		}
+		return v
+	}
+
+	var values []string
+	for _, v := range h.Values(header) {
+		for _, s := range strings.Split(v, ",") {
+			values = append(values, strings.TrimSpace(s))
+		}
+	}
+	if len(values) == 0 {
+		return ""
+	}
+
+	i := len(values) - 1
+	if addrs := forwardedFor(h); len(addrs) == len(values) {
+		for ; i > 0; i-- {
+			ip := net.ParseIP(addrs[i])
+			if ip == nil || !containsIP(a.trustedProxies, ip) {
+				break
+			}
+		}
+	}
+
+	return values[i]
+}
+
+// publicURL returns the URL that the clients reach the server at, without a
+// trailing slash. The base URL is used if it is set. Otherwise the URL is
+// built from the host of the request, and the scheme and host that a trusted
+// proxy forwards replace those that the server sees.
+func (a *app) publicURL(r *http.Request) string {
+	if a.baseURL != nil {
+		return a.baseURL.String()
+	}
+
+	scheme, host := a.urlScheme, r.Host
+	if a.isTrustedPeer(r) {
+		if p := strings.ToLower(a.forwardedValue(r.Header, "proto", "X-Forwarded-Proto")); p == "http" || p == "https" {
+			scheme = p
+		}
+		if h := a.forwardedValue(r.Header, "host", "X-Forwarded-Host"); h != "" && !strings.ContainsAny(h, "/\\@?# ") {
+			host = h
+		}
+	}
+
+	return fmt.Sprintf("%s://%s", scheme, host)
+}
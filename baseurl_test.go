@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseBaseURL(t *testing.T) {
+	tests := []struct {
+		s    string
+		want string
+		path string
+	}{
+		{"", "", ""},
+		{"https://example.com", "https://example.com", ""},
+		{"https://example.com/", "https://example.com", ""},
+		{"https://example.com/paste/", "https://example.com/paste", "/paste"},
+		{"http://example.com:8080/a/b", "http://example.com:8080/a/b", "/a/b"},
+	}
+	for _, tt := range tests {
+		u, err := parseBaseURL(tt.s)
+		if err != nil {
+			t.Errorf("%q: %v", tt.s, err)
+			continue
+		}
+		if u == nil {
+			if tt.want != "" {
+				t.Errorf("%q: got nil", tt.s)
+			}
+			continue
+		}
+		if u.String() != tt.want || u.Path != tt.path {
+			t.Errorf("%q: got %q with path %q", tt.s, u, u.Path)
+		}
+	}
+
+	for _, s := range []string{"example.com", "/paste", "ftp://example.com", "https://u:p@example.com", "https://example.com/?a=b", "https://example.com/#a"} {
+		if _, err := parseBaseURL(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
+
+func TestPublicURL(t *testing.T) {
+	a := &app{urlScheme: "http"}
+	a.trustedProxies, _ = parseCIDRs("127.0.0.1, 10.0.0.0/8")
+
+	tests := []struct {
+		remoteAddr string
+		headers    []string
+		want       string
+	}{
+		{"203.0.113.1:1234", nil, "http://example.com"},
+		{"203.0.113.1:1234", []string{"X-Forwarded-Proto", "https", "X-Forwarded-Host", "evil.example"}, "http://example.com"},
+		{"127.0.0.1:1234", []string{"X-Forwarded-Proto", "https"}, "https://example.com"},
+		{"127.0.0.1:1234", []string{"X-Forwarded-Proto", "HTTPS, http", "X-Forwarded-Host", "dumps.example, proxy.internal", "X-Forwarded-For", "192.0.2.1, 10.0.0.1"}, "https://dumps.example"},
+		{"127.0.0.1:1234", []string{"X-Forwarded-Proto", "https, http", "X-Forwarded-Host", "dumps.example, proxy.internal"}, "http://proxy.internal"},
+		{"127.0.0.1:1234", []string{"Forwarded", `for=192.0.2.1;proto=https;host="dumps.example:8443", for=10.0.0.1;proto=http`}, "https://dumps.example:8443"},
+		{"127.0.0.1:1234", []string{"Forwarded", `for=192.0.2.1;proto=https;host=dumps.example, for=198.51.100.1;proto=http`}, "http://example.com"},
+
+		// The client sends its own headers, which a trusted proxy
+		// appends its values to.
+		{"127.0.0.1:1234", []string{"X-Forwarded-Proto", "http, https", "X-Forwarded-Host", "evil.example, dumps.example", "X-Forwarded-For", "192.0.2.1"}, "https://dumps.example"},
+		{"127.0.0.1:1234", []string{"X-Forwarded-Host", "evil.example, dumps.example", "X-Forwarded-For", "10.0.0.9, 192.0.2.1"}, "http://dumps.example"},
+		{"127.0.0.1:1234", []string{"X-Forwarded-Host", "evil.example, dumps.example, proxy.internal", "X-Forwarded-For", "10.0.0.9, 192.0.2.1, 10.0.0.1"}, "http://dumps.example"},
+		{"127.0.0.1:1234", []string{"Forwarded", `for=10.0.0.9;host=evil.example, for=192.0.2.1;host=dumps.example`}, "http://dumps.example"},
+		{"127.0.0.1:1234", []string{"Forwarded", `for=_hidden;host=dumps.example, for=10.0.0.1;host=proxy.internal`}, "http://dumps.example"},
+		{"127.0.0.1:1234", []string{"X-Forwarded-Proto", "gopher", "X-Forwarded-Host", "a.example/path"}, "http://example.com"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = tt.remoteAddr
+		for i := 0; i+1 < len(tt.headers); i += 2 {
+			r.Header.Set(tt.headers[i], tt.headers[i+1])
+		}
+		if u := a.publicURL(r); u != tt.want {
+			t.Errorf("%s %v: got %s, want %s", tt.remoteAddr, tt.headers, u, tt.want)
+		}
+	}
+
+	// The base URL wins over the headers.
+	a.baseURL, _ = parseBaseURL("https://dumps.example/paste/")
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "127.0.0.1:1234"
+	r.Header.Set("X-Forwarded-Host", "other.example")
+	if u := a.publicURL(r); u != "https://dumps.example/paste" {
+		t.Errorf("base URL: got %s", u)
+	}
+}
+
+func TestPathPrefix(t *testing.T) {
+	a, _ := newTestApp(t, true)
+	a.baseURL, _ = parseBaseURL("https://dumps.example/paste")
+
+	// Both the prefixed paths and the paths that a proxy has removed the
+	// prefix from are served.
+	for _, prefix := range []string{"/paste", ""} {
+		w := request(a, http.MethodPost, prefix+"/", strings.NewReader("foo"))
+		u := strings.TrimSpace(w.Body.String())
+		if w.Code != http.StatusCreated || !strings.HasPrefix(u, "https://dumps.example/paste/") {
+			t.Fatalf("%q: upload got %d %q", prefix, w.Code, u)
+		}
+
+		path := strings.TrimPrefix(u, "https://dumps.example/paste")
+		if w = request(a, http.MethodGet, prefix+path, nil); w.Code != http.StatusOK || w.Body.String() != "foo" {
+			t.Errorf("%q: get got %d %q", prefix, w.Code, w.Body)
+		}
+	}
+
+	w := request(a, http.MethodGet, "/paste", nil, "User-Agent", "curl/8.0")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "curl --data-binary @- https://dumps.example/paste\n") {
+		t.Errorf("man: got %d %q", w.Code, w.Body)
+	}
+
+	w = request(a, http.MethodGet, "/paste/text", nil)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `action="https://dumps.example/paste/dump"`) {
+		t.Errorf("ui: got %d", w.Code)
+	}
+
+	w = request(a, http.MethodPost, "/paste/api/v1/dumps", strings.NewReader("foo"), "Accept", "application/json")
+	var res dumpResource
+	json.NewDecoder(w.Body).Decode(&res)
+	if w.Code != http.StatusCreated || w.Header().Get("Location") != "/paste/api/v1/dumps/"+res.ID || res.URL != "https://dumps.example/paste/"+res.ID {
+		t.Errorf("api: got %d %q %+v", w.Code, w.Header().Get("Location"), res)
+	}
+
+	// Paths that only start like the prefix aren't stripped.
+	if w = request(a, http.MethodGet, "/pastebin", nil); w.Code != http.StatusNotFound {
+		t.Errorf("/pastebin: got %d", w.Code)
+	}
+}
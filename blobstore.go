@@ -0,0 +1,274 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"time"
+)
+
+// errBlobNotFound is returned by the blob stores when the requested blob
+// doesn't exist.
+var errBlobNotFound = errors.New("blob not found")
+
+// blobInfo holds information about a stored blob.
+type blobInfo struct {
+	name       string
+	size       int64
+	modifiedAt time.Time
+}
+
+// blobStore is the interface that the storage backends for the dump contents
+// needs to implement.
+type blobStore interface {
+	// Put stores the contents of the reader under the given name, an
+	// existing blob with the same name is replaced.
+	Put(name string, r io.Reader) error
+
+	// Get returns a reader for the blob with the given name, it is up to
+	// the caller to close it.
+	Get(name string) (io.ReadCloser, error)
+
+	// Stat returns information about the blob with the given name.
+	Stat(name string) (*blobInfo, error)
+
+	// Delete removes the blob with the given name.
+	Delete(name string) error
+
+	// List returns the names of all blobs that starts with the given
+	// prefix.
+	List(prefix string) ([]string, error)
+
+	// Create returns a writer for a new temporary blob, the blob isn't
+	// visible in the store until it has been committed.
+	Create() (blobWriter, error)
+}
+
+// blobWriter is a blob that is being written to a temporary location.
+type blobWriter interface {
+	io.Writer
+
+	// Commit moves the blob into place under the given name, an existing
+	// blob with the same name is replaced.
+	Commit(name string) error
+
+	// Abort discards the temporary blob, it is safe to call Abort after
+	// the blob has been committed.
+	Abort() error
+}
+
+// readCloser combines a reader with the closer of the underlying blob.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// putContents encrypts the contents of the reader with the public key of the
+// server and stores it under the given filesystem id. The size and SHA-256
+// hash of the contents are returned.
+func (a *app) putContents(filesystemID string, r io.Reader) (int64, string, error) {
+	up, err := a.newUpload(r, a.recipient)
+	if err != nil {
+		return 0, "", err
+	}
+	defer up.blob.Abort()
+
+	if err = up.blob.Commit(filesystemID); err != nil {
+		return 0, "", err
+	}
+
+	return up.size, up.hash, nil
+}
+
+// rewriteContents encrypts the contents of the dump with the public key of
+// the server and stores them under a new filesystem id before the dump is
+// updated, that way the dump never points to contents that aren't in place,
+// and the old file is only removed when the dump has been updated.
+func (a *app) rewriteContents(du *dump) error {
+	data, err := a.openContents(du)
+	if err != nil {
+		return err
+	}
+	defer data.Close()
+
+	filesystemID := newUUID()
+	size, hash, err := a.putContents(filesystemID, data)
+	if err != nil {
+		return err
+	}
+
+	if err = a.db.updateDumpStorage(du.id, filesystemID, encryptionServer, size, hash); err != nil {
+		a.store.Delete(filesystemID)
+		return err
+	}
+
+	if err = a.store.Delete(du.filesystemID); err != nil {
+		log.Printf("failed to delete old file %s: %v\n", du.filesystemID, err)
+	}
+
+	return nil
+}
+
+// openContents opens the stored contents of the dump, the returned reader
+// yields the decrypted contents and it is up to the caller to close it.
+func (a *app) openContents(du *dump) (io.ReadCloser, error) {
+	rc, err := a.store.Get(du.filesystemID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Plaintext contents are returned as is, which keeps the blob
+	// seekable if the store supports it.
+	if du.encryption == encryptionNone {
+		return rc, nil
+	}
+
+	r, err := a.decryptStream(rc, du)
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+
+	return readCloser{r, rc}, nil
+}
+
+// openContentsAt opens the stored contents of the dump at the given offset
+// of the decrypted contents. Encrypted contents are decrypted from the 64 KiB
+// chunk of the age payload that contains the offset. Blobs that can't seek,
+// like the ones of the S3 store, are still read from the start, but the
+// chunks before the offset are skipped without being decrypted.
+func (a *app) openContentsAt(du *dump, offset int64) (io.ReadCloser, error) {
+	if offset == 0 {
+		return a.openContents(du)
+	}
+
+	rc, err := a.store.Get(du.filesystemID)
+	if err != nil {
+		return nil, err
+	}
+
+	var r io.Reader = rc
+	if du.encryption != encryptionNone {
+		r, err = a.decryptStreamAt(rc, du, offset)
+	} else if s, ok := rc.(io.Seeker); ok {
+		_, err = s.Seek(offset, io.SeekStart)
+	} else {
+		_, err = io.CopyN(ioutil.Discard, rc, offset)
+	}
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+
+	return readCloser{r, rc}, nil
+}
+
+// contentReader is a seekable reader for the decrypted contents of a dump.
+// A seek reopens the contents at the new offset, which for encrypted
+// contents costs the decryption of the header and of the chunk that
+// contains the offset, see openContentsAt. The contents are opened lazily
+// on the first read, which makes it cheap for http.ServeContent to seek
+// around before serving the data.
+type contentReader struct {
+	a    *app
+	du   *dump
+	size int64
+	rc   io.ReadCloser
+	pos  int64
+	off  int64
+}
+
+// newContentReader returns a new seekable reader for the contents of the
+// dump. The size of the contents is determined from the dump if possible,
+// otherwise it is calculated from the blob.
+func (a *app) newContentReader(du *dump) (*contentReader, error) {
+	cr := &contentReader{a: a, du: du}
+
+	switch {
+	case du.size != nil:
+		cr.size = *du.size
+	case du.encryption == encryptionNone:
+		bi, err := a.store.Stat(du.filesystemID)
+		if err != nil {
+			return nil, err
+		}
+		cr.size = bi.size
+	default:
+		rc, err := a.openContents(du)
+		if err != nil {
+			return nil, err
+		}
+		cr.size, err = io.Copy(ioutil.Discard, rc)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return cr, nil
+}
+
+// Read reads from the current offset. The open stream is read up to the
+// offset if it's less than a chunk ahead, otherwise the contents are
+// reopened at the offset.
+func (cr *contentReader) Read(p []byte) (int, error) {
+	if cr.off >= cr.size {
+		return 0, io.EOF
+	}
+
+	if cr.rc != nil && (cr.off < cr.pos || cr.off-cr.pos > streamChunkSize) {
+		cr.rc.Close()
+		cr.rc = nil
+	}
+
+	if cr.rc == nil {
+		rc, err := cr.a.openContentsAt(cr.du, cr.off)
+		if err != nil {
+			return 0, err
+		}
+		cr.rc, cr.pos = rc, cr.off
+	}
+
+	if cr.off > cr.pos {
+		if _, err := io.CopyN(ioutil.Discard, cr.rc, cr.off-cr.pos); err != nil {
+			return 0, err
+		}
+		cr.pos = cr.off
+	}
+
+	n, err := cr.rc.Read(p)
+	cr.pos += int64(n)
+	cr.off = cr.pos
+	return n, err
+}
+
+// Seek sets the offset for the next read.
+func (cr *contentReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += cr.off
+	case io.SeekEnd:
+		offset += cr.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+
+	cr.off = offset
+	return offset, nil
+}
+
+// Close closes the open stream, if any.
+func (cr *contentReader) Close() error {
+	if cr.rc == nil {
+		return nil
+	}
+
+	err := cr.rc.Close()
+	cr.rc = nil
+	return err
+}
@@ -2,37 +2,137 @@ package main
 
 import (
 	"log"
-	"os"
-	"path/filepath"
 	"time"
 )
 
 // cleaner is responsible for deleting the files where the deleteAfter date
-// has passed.
+// has passed, the files that has been idle for too long, the tus uploads
+// that has expired and the rate limit buckets that are full.
 func (a *app) cleaner() {
 	for {
-		filesystemIDs, err := a.db.getFilesystemIDsToDelete()
+		a.deleteExpiredDumps()
+		a.deleteIdleDumps()
+		a.deleteExpiredTusUploads()
+		a.pruneRateLimits()
+		time.Sleep(time.Minute * 1)
+	}
+}
+
+// deleteExpiredDumps deletes the files where the deleteAfter date has passed.
+func (a *app) deleteExpiredDumps() {
+	filesystemIDs, err := a.db.getFilesystemIDsToDelete()
+	if err != nil {
+		log.Printf("failed to fetch files to delete: %v\n", err)
+		return
+	}
+
+	a.deleteDumps(filesystemIDs)
+}
+
+// deleteIdleDumps deletes the files that hasn't been downloaded for longer
+// than their idle expiry or the idle expiry of the server, whichever is the
+// shortest. Files that never has been downloaded are idle since they were
+// uploaded. The idle expiry of the server is the same for every file, so
+// the database finds those files, while the activity of the files with an
+// idle expiry of their own is checked here.
+func (a *app) deleteIdleDumps() {
+	now := time.Now()
+	var filesystemIDs []string
+	if a.idleExpiry > 0 {
+		ids, err := a.db.getIdleFilesystemIDs(now.Add(-a.idleExpiry))
 		if err != nil {
-			log.Printf("failed to fetch files to delete: %v\n", err)
-			goto sleep
+			log.Printf("failed to fetch idle files: %v\n", err)
+			return
 		}
+		filesystemIDs = ids
+	}
 
-		if len(filesystemIDs) == 0 {
-			goto sleep
+	activity, err := a.db.getDumpActivity()
+	if err != nil {
+		log.Printf("failed to fetch dump activity: %v\n", err)
+		return
+	}
+
+	// The files that has passed the idle expiry of the server are already
+	// on the list.
+	found := make(map[string]bool)
+	for _, filesystemID := range filesystemIDs {
+		found[filesystemID] = true
+	}
+	for _, da := range activity {
+		if !found[da.filesystemID] && da.isIdle(0, now) {
+			filesystemIDs = append(filesystemIDs, da.filesystemID)
 		}
+	}
+
+	a.deleteDumps(filesystemIDs)
+}
 
-		log.Printf("got %d files to delete\n", len(filesystemIDs))
-		for _, filesystemID := range filesystemIDs {
-			log.Printf("deleting %s\n", filesystemID)
-			if err = a.db.deleteDumpByFilesystemID(filesystemID); err != nil {
-				log.Printf("failed to delete file from database: %v\n", err)
-				continue
-			}
-			if err = os.Remove(filepath.Join(a.dataDir, filesystemID)); err != nil {
-				log.Printf("failed to delete file from filesystem: %v\n", err)
-			}
+// isIdle returns true if the dump has been idle for longer than its own idle
+// expiry or the given server idle expiry, zero means no server idle expiry.
+func (da *dumpActivity) isIdle(idleExpiry time.Duration, now time.Time) bool {
+	if da.idleExpiry != nil {
+		if d := time.Duration(*da.idleExpiry) * time.Second; idleExpiry == 0 || d < idleExpiry {
+			idleExpiry = d
 		}
-	sleep:
-		time.Sleep(time.Minute * 1)
+	}
+	if idleExpiry == 0 {
+		return false
+	}
+
+	lastAccessAt := da.insertedAt
+	if da.lastAccessAt != nil {
+		lastAccessAt = *da.lastAccessAt
+	}
+
+	return now.Sub(lastAccessAt) > idleExpiry
+}
+
+// deleteDumps marks the dumps as deleted and deletes the files from the blob
+// store.
+func (a *app) deleteDumps(filesystemIDs []string) {
+	if len(filesystemIDs) == 0 {
+		return
+	}
+
+	var err error
+	log.Printf("got %d files to delete\n", len(filesystemIDs))
+	for _, filesystemID := range filesystemIDs {
+		log.Printf("deleting %s\n", filesystemID)
+		if err = a.db.deleteDumpByFilesystemID(filesystemID); err != nil {
+			log.Printf("failed to delete file from database: %v\n", err)
+			continue
+		}
+		if err = a.store.Delete(filesystemID); err != nil {
+			log.Printf("failed to delete file from blob store: %v\n", err)
+		}
+	}
+}
+
+// deleteExpiredTusUploads deletes the tus uploads that has expired together
+// with the chunks that has been received for them.
+func (a *app) deleteExpiredTusUploads() {
+	uploadIDs, err := a.db.getExpiredTusUploadIDs()
+	if err != nil {
+		log.Printf("failed to fetch expired uploads: %v\n", err)
+		return
+	}
+
+	for _, uploadID := range uploadIDs {
+		log.Printf("deleting upload %s\n", uploadID)
+		if err = a.deleteTusUpload(uploadID); err != nil {
+			log.Printf("failed to delete upload: %v\n", err)
+		}
+	}
+}
+
+// pruneRateLimits removes the rate limit buckets that has been refilled.
+func (a *app) pruneRateLimits() {
+	if a.limits == nil {
+		return
+	}
+
+	if err := a.limits.limiter.prune(time.Now()); err != nil {
+		log.Printf("failed to prune rate limits: %v\n", err)
 	}
 }
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDumpActivityIsIdle(t *testing.T) {
+	now := time.Date(2021, 1, 31, 0, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+	week := int64(7 * 24 * 60 * 60)
+	accessed := now.Add(-3 * day)
+
+	tests := []struct {
+		idleExpiry   *int64
+		insertedAt   time.Time
+		lastAccessAt *time.Time
+		serverIdle   time.Duration
+		want         bool
+	}{
+		{nil, now.Add(-30 * day), nil, 0, false},
+		{nil, now.Add(-30 * day), nil, 10 * day, true},
+		{nil, now.Add(-30 * day), &accessed, 10 * day, false},
+		{&week, now.Add(-8 * day), nil, 0, true},
+		{&week, now.Add(-6 * day), nil, 0, false},
+		{&week, now.Add(-30 * day), &accessed, 0, false},
+		{&week, now.Add(-30 * day), &accessed, 2 * day, true},
+		{&week, now.Add(-8 * day), nil, 30 * day, true},
+	}
+
+	for i, tt := range tests {
+		da := &dumpActivity{idleExpiry: tt.idleExpiry, insertedAt: tt.insertedAt, lastAccessAt: tt.lastAccessAt}
+		if got := da.isIdle(tt.serverIdle, now); got != tt.want {
+			t.Errorf("%d: got %v, want %v", i, got, tt.want)
+		}
+	}
+}
@@ -5,27 +5,42 @@ import (
 	"fmt"
 	"time"
 
-	_ "github.com/lib/pq"
+	"filippo.io/age"
 	"github.com/osm/migrator"
 )
 
 // db contains the database connection and holds the database related methods.
 type db struct {
-	conn *sql.DB
+	conn    *sql.DB
+	dialect dialect
 }
 
 // dump is a model of the dump table.
 type dump struct {
-	id           string
-	publicID     string
-	filesystemID string
-	contentType  string
-	insertedAt   string
-	ipAddress    string
-	deleteAfter  time.Time
-	username     *[]byte
-	password     *[]byte
-	deletedAt    *string
+	id                 string
+	publicID           string
+	filesystemID       string
+	contentType        string
+	insertedAt         time.Time
+	ipAddress          string
+	deleteAfter        *time.Time
+	username           *[]byte
+	password           *[]byte
+	passwordHash       *string
+	encryption         string
+	clientEncrypted    bool
+	size               *int64
+	contentHash        *string
+	remainingDownloads *int
+	deleteTokenHash    *string
+	ownerKeyHash       *string
+	idleExpiry         *int64
+	slug               *string
+	deletedAt          *string
+
+	// identity decrypts the contents of a password encrypted dump, it's
+	// derived from the credentials of the request and is never stored.
+	identity age.Identity
 }
 
 // dumpAccessLog is a model of the dump_access_log table.
@@ -36,6 +51,34 @@ type dumpAccessLog struct {
 	insertedAt string
 }
 
+// tusUpload is a model of the tus_upload table.
+type tusUpload struct {
+	id           string
+	length       int64
+	offset       int64
+	contentType  *string
+	deleteAfter  *string
+	ipAddress    string
+	username     *[]byte
+	password     *[]byte
+	passwordHash *string
+	idleExpiry   *int64
+	maxDownloads *int
+	slug         *string
+	ownerKeyHash *string
+	publicID     *string
+	expiresAt    time.Time
+}
+
+// dumpActivity holds the last access of a dump, it's used to find the dumps
+// that has been idle for too long.
+type dumpActivity struct {
+	filesystemID string
+	idleExpiry   *int64
+	insertedAt   time.Time
+	lastAccessAt *time.Time
+}
+
 // dumpInfo is the model that holds some basic info about a dump.
 type dumpInfo struct {
 	createdAt time.Time
@@ -45,17 +88,25 @@ type dumpInfo struct {
 // newDB initializes a new database connection with the provided connection
 // string.
 func newDB(cs string) (*db, error) {
+	dialect, driver, dsn := parseConnectionString(cs)
+
 	var conn *sql.DB
 	var err error
-	if conn, err = sql.Open("postgres", cs); err != nil {
+	if conn, err = sql.Open(driver, dsn); err != nil {
 		return nil, fmt.Errorf("can't initialize database connection: %w", err)
 	}
 
-	if err = migrator.ToLatest(conn, getDatabaseRepository()); err != nil {
+	// SQLite only allows one writer at a time, so we'll use a single
+	// connection to avoid busy errors.
+	if dialect == dialectSQLite {
+		conn.SetMaxOpenConns(1)
+	}
+
+	if err = migrator.ToLatest(conn, getDatabaseRepository(dialect)); err != nil {
 		return nil, err
 	}
 
-	return &db{conn}, nil
+	return &db{conn, dialect}, nil
 }
 
 // insertDump inserts a new dump to the database.
@@ -68,7 +119,17 @@ func (d *db) insertDump(du *dump) error {
 		ip_address,
 		encrypted_username,
 		encrypted_password,
-		delete_after
+		delete_after,
+		encryption,
+		size,
+		content_hash,
+		remaining_downloads,
+		delete_token_hash,
+		owner_key_hash,
+		idle_expiry,
+		password_hash,
+		client_encrypted,
+		slug
 	) VALUES (
 		$1,
 		$2,
@@ -77,9 +138,19 @@ func (d *db) insertDump(du *dump) error {
 		$5,
 		$6,
 		$7,
-		$8
+		$8,
+		$9,
+		$10,
+		$11,
+		$12,
+		$13,
+		$14,
+		$15,
+		$16,
+		$17,
+		$18
 	);`
-	stmt, err := d.conn.Prepare(query)
+	stmt, err := d.prepare(query)
 	if err != nil {
 		return err
 	}
@@ -94,7 +165,23 @@ func (d *db) insertDump(du *dump) error {
 		du.username,
 		du.password,
 		du.deleteAfter,
+		du.encryption,
+		du.size,
+		du.contentHash,
+		du.remainingDownloads,
+		du.deleteTokenHash,
+		du.ownerKeyHash,
+		du.idleExpiry,
+		du.passwordHash,
+		du.clientEncrypted,
+		du.slug,
 	)
+	if isUniqueViolation(err, "dump_public_id_uniq_idx", "dump.public_id") {
+		return errDuplicatePublicID
+	}
+	if isUniqueViolation(err, "dump_slug_uniq_idx", "dump.slug") {
+		return errDuplicateSlug
+	}
 	if err != nil {
 		return err
 	}
@@ -102,34 +189,162 @@ func (d *db) insertDump(du *dump) error {
 	return nil
 }
 
-// getDumpByPublicID fetches the given file by the public id from the
-// database.
-func (d *db) getDumpByPublicID(publicID string) (*dump, error) {
-	var du dump
-	query := `SELECT
+// dumpColumns is the list of columns that are selected by scanDump.
+const dumpColumns = `
 		id,
+		public_id,
 		content_type,
 		filesystem_id,
 		encrypted_username,
 		encrypted_password,
-		deleted_at
+		password_hash,
+		encryption,
+		client_encrypted,
+		size,
+		content_hash,
+		remaining_downloads,
+		delete_token_hash,
+		delete_after,
+		idle_expiry,
+		slug,
+		inserted_at,
+		deleted_at`
+
+// scanner is implemented by both *sql.Row and *sql.Rows.
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanDump scans a row that was selected with dumpColumns.
+func scanDump(row scanner) (*dump, error) {
+	var du dump
+	err := row.Scan(
+		&du.id,
+		&du.publicID,
+		&du.contentType,
+		&du.filesystemID,
+		&du.username,
+		&du.password,
+		&du.passwordHash,
+		&du.encryption,
+		&du.clientEncrypted,
+		&du.size,
+		&du.contentHash,
+		&du.remainingDownloads,
+		&du.deleteTokenHash,
+		&du.deleteAfter,
+		&du.idleExpiry,
+		&du.slug,
+		&du.insertedAt,
+		&du.deletedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &du, nil
+}
+
+// getDumpByPublicID fetches the given file by the public id from the
+// database.
+func (d *db) getDumpByPublicID(publicID string) (*dump, error) {
+	query := `SELECT` + dumpColumns + `
 	FROM dump
 	WHERE
 		public_id = $1`
-	err := d.conn.QueryRow(query, publicID).
-		Scan(
-			&du.id,
-			&du.contentType,
-			&du.filesystemID,
-			&du.username,
-			&du.password,
-			&du.deletedAt,
-		)
+
+	return scanDump(d.queryRow(query, publicID))
+}
+
+// getDumpBySlug fetches the dump that hasn't been deleted and that has the
+// given slug, a slug can be used again when the dump has been deleted.
+func (d *db) getDumpBySlug(slug string) (*dump, error) {
+	query := `SELECT` + dumpColumns + `
+	FROM dump
+	WHERE
+		deleted_at IS NULL
+		AND slug = $1`
+
+	return scanDump(d.queryRow(query, slug))
+}
+
+// getDumpsByOwnerKeyHash returns the dumps that hasn't been deleted and that
+// were created with the given owner key, the newest dump comes first.
+func (d *db) getDumpsByOwnerKeyHash(ownerKeyHash string) ([]*dump, error) {
+	query := `SELECT` + dumpColumns + `
+	FROM dump
+	WHERE
+		deleted_at IS NULL
+		AND owner_key_hash = $1
+	ORDER BY inserted_at DESC`
+
+	rows, err := d.query(query, ownerKeyHash)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
-	return &du, nil
+	var dumps []*dump
+	for rows.Next() {
+		du, err := scanDump(rows)
+		if err != nil {
+			return nil, err
+		}
+		dumps = append(dumps, du)
+	}
+
+	return dumps, nil
+}
+
+// updateDumpMetadata updates the content type, expiry, idle expiry and basic
+// auth credentials of the given dump.
+func (d *db) updateDumpMetadata(du *dump) error {
+	query := `UPDATE dump
+	SET
+		content_type = $1,
+		delete_after = $2,
+		encrypted_username = $3,
+		encrypted_password = $4,
+		password_hash = $5,
+		idle_expiry = $6
+	WHERE
+		id = $7`
+	stmt, err := d.prepare(query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(du.contentType, du.deleteAfter, du.username, du.password, du.passwordHash, du.idleExpiry, du.id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// updateDumpPasswordHash replaces the encrypted basic auth credentials of the
+// given dump with the password hash.
+func (d *db) updateDumpPasswordHash(id, passwordHash string) error {
+	query := `UPDATE dump
+	SET
+		encrypted_username = NULL,
+		encrypted_password = NULL,
+		password_hash = $1
+	WHERE
+		id = $2`
+	stmt, err := d.prepare(query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(passwordHash, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
 }
 
 // getFilesystemIDsToDelete returns a slice of filesystem ids that is up for
@@ -140,10 +355,50 @@ func (d *db) getFilesystemIDsToDelete() ([]string, error) {
 	FROM dump
 	WHERE
 		deleted_at IS NULL
-		AND delete_after <> '0001-01-01 01:12:12+01:12:12'
-		AND now() > delete_after;`
+		AND delete_after IS NOT NULL
+		AND $1 > delete_after;`
+
+	rows, err := d.query(query, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var filesystemIDs []string
+	for rows.Next() {
+		var filesystemID string
+		err = rows.Scan(&filesystemID)
+		if err != nil {
+			return nil, err
+		}
+
+		filesystemIDs = append(filesystemIDs, filesystemID)
+	}
+
+	return filesystemIDs, nil
+}
+
+// getIdleFilesystemIDs returns the filesystem ids of the dumps that hasn't
+// been deleted and that hasn't been accessed since the cutoff, the dumps
+// that never has been accessed are idle since they were uploaded. The
+// condition is the same as COALESCE(last access, inserted_at) < cutoff, but
+// it lets the access log index answer the question for each dump.
+func (d *db) getIdleFilesystemIDs(cutoff time.Time) ([]string, error) {
+	query := `SELECT
+		d.filesystem_id
+	FROM dump d
+	WHERE
+		d.deleted_at IS NULL
+		AND d.inserted_at < $1
+		AND NOT EXISTS (
+			SELECT 1
+			FROM dump_access_log a
+			WHERE
+				a.dump_id = d.id
+				AND a.inserted_at >= $2
+		)`
 
-	rows, err := d.conn.Query(query)
+	rows, err := d.query(query, cutoff, cutoff)
 	if err != nil {
 		return nil, err
 	}
@@ -163,16 +418,55 @@ func (d *db) getFilesystemIDsToDelete() ([]string, error) {
 	return filesystemIDs, nil
 }
 
+// getDumpActivity returns the last access of the dumps that has an idle
+// expiry and that hasn't been deleted.
+func (d *db) getDumpActivity() ([]*dumpActivity, error) {
+	query := `SELECT
+		d.filesystem_id,
+		d.idle_expiry,
+		d.inserted_at,
+		(
+			SELECT a.inserted_at
+			FROM dump_access_log a
+			WHERE a.dump_id = d.id
+			ORDER BY a.inserted_at DESC
+			LIMIT 1
+		)
+	FROM dump d
+	WHERE
+		d.deleted_at IS NULL
+		AND d.idle_expiry IS NOT NULL`
+
+	rows, err := d.query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var activity []*dumpActivity
+	for rows.Next() {
+		var da dumpActivity
+		err = rows.Scan(&da.filesystemID, &da.idleExpiry, &da.insertedAt, &da.lastAccessAt)
+		if err != nil {
+			return nil, err
+		}
+
+		activity = append(activity, &da)
+	}
+
+	return activity, nil
+}
+
 // deleteDumpByFilesystemID deletes the file by the given filesystem id.
 func (d *db) deleteDumpByFilesystemID(filesystemID string) error {
-	query := "UPDATE dump SET deleted_at = now() WHERE filesystem_id = $1"
-	stmt, err := d.conn.Prepare(query)
+	query := "UPDATE dump SET deleted_at = $1 WHERE filesystem_id = $2"
+	stmt, err := d.prepare(query)
 	if err != nil {
 		return err
 	}
 	defer stmt.Close()
 
-	_, err = stmt.Exec(filesystemID)
+	_, err = stmt.Exec(time.Now(), filesystemID)
 	if err != nil {
 		return err
 	}
@@ -180,6 +474,27 @@ func (d *db) deleteDumpByFilesystemID(filesystemID string) error {
 	return nil
 }
 
+// decrementRemainingDownloads decrements the number of remaining downloads
+// for the given dump and returns the number of downloads that remains.
+// sql.ErrNoRows is returned if there are no downloads left.
+func (d *db) decrementRemainingDownloads(id string) (int, error) {
+	query := `UPDATE dump
+	SET
+		remaining_downloads = remaining_downloads - 1
+	WHERE
+		id = $1
+		AND deleted_at IS NULL
+		AND remaining_downloads > 0
+	RETURNING remaining_downloads`
+
+	var remaining int
+	if err := d.queryRow(query, id).Scan(&remaining); err != nil {
+		return 0, err
+	}
+
+	return remaining, nil
+}
+
 // insertDumpAccessLog inserts a new entry to the dump access log.
 func (d *db) insertDumpAccessLog(dal *dumpAccessLog) error {
 	query := `INSERT INTO dump_access_log (
@@ -191,7 +506,7 @@ func (d *db) insertDumpAccessLog(dal *dumpAccessLog) error {
 		$2,
 		$3
 	);`
-	stmt, err := d.conn.Prepare(query)
+	stmt, err := d.prepare(query)
 	if err != nil {
 		return err
 	}
@@ -205,18 +520,441 @@ func (d *db) insertDumpAccessLog(dal *dumpAccessLog) error {
 	return nil
 }
 
+// getDumpInfoByPublicID returns the creation time and the number of
+// downloads for the given public id.
 func (d *db) getDumpInfoByPublicID(publicID string) (*dumpInfo, error) {
 	var di dumpInfo
 
 	query := `SELECT inserted_at FROM dump WHERE public_id = $1`
-	if err := d.conn.QueryRow(query, publicID).Scan(&di.createdAt); err != nil {
+	if err := d.queryRow(query, publicID).Scan(&di.createdAt); err != nil {
 		return nil, err
 	}
 
 	query = `SELECT COUNT(1) FROM dump_access_log a INNER JOIN dump d ON d.id = a.dump_id WHERE d.public_id = $1`
-	if err := d.conn.QueryRow(query, publicID).Scan(&di.count); err != nil {
+	if err := d.queryRow(query, publicID).Scan(&di.count); err != nil {
 		return nil, err
 	}
 
 	return &di, nil
 }
+
+// getDumpsByEncryption returns at most limit dumps that hasn't been deleted
+// and that are stored with the given encryption, ordered by id and starting
+// after the given id.
+func (d *db) getDumpsByEncryption(encryption, afterID string, limit int) ([]*dump, error) {
+	query := `SELECT
+		id,
+		public_id,
+		filesystem_id,
+		encryption
+	FROM dump
+	WHERE
+		deleted_at IS NULL
+		AND encryption = $1
+		AND id > $2
+	ORDER BY id
+	LIMIT $3`
+
+	rows, err := d.query(query, encryption, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var dumps []*dump
+	for rows.Next() {
+		var du dump
+		err = rows.Scan(&du.id, &du.publicID, &du.filesystemID, &du.encryption)
+		if err != nil {
+			return nil, err
+		}
+
+		dumps = append(dumps, &du)
+	}
+
+	return dumps, nil
+}
+
+// updateDumpStorage updates the filesystem id, encryption, size and content
+// hash for the given dump.
+func (d *db) updateDumpStorage(id, filesystemID, encryption string, size int64, contentHash string) error {
+	query := "UPDATE dump SET filesystem_id = $1, encryption = $2, size = $3, content_hash = $4 WHERE id = $5"
+	stmt, err := d.prepare(query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(filesystemID, encryption, size, contentHash, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// getDumpsWithEncryptedCredentials returns at most limit dumps that hasn't
+// been deleted and that has encrypted basic auth credentials, ordered by id
+// and starting after the given id.
+func (d *db) getDumpsWithEncryptedCredentials(afterID string, limit int) ([]*dump, error) {
+	query := `SELECT
+		id,
+		public_id,
+		encrypted_username,
+		encrypted_password
+	FROM dump
+	WHERE
+		deleted_at IS NULL
+		AND length(encrypted_username) > 0
+		AND length(encrypted_password) > 0
+		AND id > $1
+	ORDER BY id
+	LIMIT $2`
+
+	rows, err := d.query(query, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var dumps []*dump
+	for rows.Next() {
+		var du dump
+		err = rows.Scan(&du.id, &du.publicID, &du.username, &du.password)
+		if err != nil {
+			return nil, err
+		}
+
+		dumps = append(dumps, &du)
+	}
+
+	return dumps, nil
+}
+
+// updateDumpCredentials updates the encrypted basic auth credentials of the
+// given dump.
+func (d *db) updateDumpCredentials(id string, username, password []byte) error {
+	query := `UPDATE dump
+	SET
+		encrypted_username = $1,
+		encrypted_password = $2
+	WHERE
+		id = $3`
+	stmt, err := d.prepare(query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(username, password, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// insertTusUpload inserts a new tus upload to the database.
+func (d *db) insertTusUpload(tu *tusUpload) error {
+	query := `INSERT INTO tus_upload (
+		id,
+		upload_length,
+		content_type,
+		delete_after,
+		ip_address,
+		encrypted_username,
+		encrypted_password,
+		password_hash,
+		idle_expiry,
+		max_downloads,
+		slug,
+		owner_key_hash,
+		expires_at
+	) VALUES (
+		$1,
+		$2,
+		$3,
+		$4,
+		$5,
+		$6,
+		$7,
+		$8,
+		$9,
+		$10,
+		$11,
+		$12,
+		$13
+	);`
+	stmt, err := d.prepare(query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(
+		tu.id,
+		tu.length,
+		tu.contentType,
+		tu.deleteAfter,
+		tu.ipAddress,
+		tu.username,
+		tu.password,
+		tu.passwordHash,
+		tu.idleExpiry,
+		tu.maxDownloads,
+		tu.slug,
+		tu.ownerKeyHash,
+		tu.expiresAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// getTusUploadByID fetches the tus upload with the given id.
+func (d *db) getTusUploadByID(id string) (*tusUpload, error) {
+	var tu tusUpload
+	query := `SELECT
+		id,
+		upload_length,
+		upload_offset,
+		content_type,
+		delete_after,
+		ip_address,
+		encrypted_username,
+		encrypted_password,
+		password_hash,
+		idle_expiry,
+		max_downloads,
+		slug,
+		owner_key_hash,
+		public_id,
+		expires_at
+	FROM tus_upload
+	WHERE
+		id = $1`
+	err := d.queryRow(query, id).
+		Scan(
+			&tu.id,
+			&tu.length,
+			&tu.offset,
+			&tu.contentType,
+			&tu.deleteAfter,
+			&tu.ipAddress,
+			&tu.username,
+			&tu.password,
+			&tu.passwordHash,
+			&tu.idleExpiry,
+			&tu.maxDownloads,
+			&tu.slug,
+			&tu.ownerKeyHash,
+			&tu.publicID,
+			&tu.expiresAt,
+		)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tu, nil
+}
+
+// updateTusUploadOffset moves the offset of the tus upload forward, the
+// offset is only updated if it hasn't changed since it was read. False is
+// returned if the offset wasn't updated.
+func (d *db) updateTusUploadOffset(id string, from, to int64, expiresAt time.Time) (bool, error) {
+	query := `UPDATE tus_upload
+	SET
+		upload_offset = $1,
+		expires_at = $2
+	WHERE
+		id = $3
+		AND upload_offset = $4`
+	stmt, err := d.prepare(query)
+	if err != nil {
+		return false, err
+	}
+	defer stmt.Close()
+
+	res, err := stmt.Exec(to, expiresAt, id, from)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n == 1, nil
+}
+
+// setTusUploadPublicID sets the public id of the dump that the tus upload
+// was assembled into.
+func (d *db) setTusUploadPublicID(id, publicID string) error {
+	query := "UPDATE tus_upload SET public_id = $1 WHERE id = $2"
+	stmt, err := d.prepare(query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(publicID, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// deleteTusUpload deletes the tus upload with the given id.
+func (d *db) deleteTusUpload(id string) error {
+	query := "DELETE FROM tus_upload WHERE id = $1"
+	stmt, err := d.prepare(query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// getExpiredTusUploadIDs returns a slice of tus upload ids that has expired.
+func (d *db) getExpiredTusUploadIDs() ([]string, error) {
+	query := `SELECT
+		id
+	FROM tus_upload
+	WHERE
+		$1 > expires_at;`
+
+	rows, err := d.query(query, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		err = rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+// getRateLimitBucket returns the rate limit bucket with the given id,
+// sql.ErrNoRows is returned if it doesn't exist.
+func (d *db) getRateLimitBucket(id string) (*rateLimitBucket, error) {
+	query := `SELECT
+		tokens,
+		version,
+		updated_at,
+		full_at
+	FROM rate_limit_bucket
+	WHERE
+		id = $1`
+
+	var b rateLimitBucket
+	err := d.queryRow(query, id).Scan(&b.tokens, &b.version, &b.updatedAt, &b.fullAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &b, nil
+}
+
+// insertRateLimitBucket inserts a new rate limit bucket. False is returned if
+// another instance inserted the bucket first.
+func (d *db) insertRateLimitBucket(id string, b *rateLimitBucket) (bool, error) {
+	query := `INSERT INTO rate_limit_bucket (
+		id,
+		tokens,
+		version,
+		updated_at,
+		full_at
+	) VALUES (
+		$1,
+		$2,
+		$3,
+		$4,
+		$5
+	) ON CONFLICT (id) DO NOTHING`
+	stmt, err := d.prepare(query)
+	if err != nil {
+		return false, err
+	}
+	defer stmt.Close()
+
+	res, err := stmt.Exec(id, b.tokens, b.version, b.updatedAt, b.fullAt)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n == 1, nil
+}
+
+// updateRateLimitBucket updates the rate limit bucket, the bucket is only
+// updated if the version hasn't changed since it was read. False is returned
+// if the bucket wasn't updated.
+func (d *db) updateRateLimitBucket(id string, b *rateLimitBucket, version int64) (bool, error) {
+	query := `UPDATE rate_limit_bucket
+	SET
+		tokens = $1,
+		version = $2,
+		updated_at = $3,
+		full_at = $4
+	WHERE
+		id = $5
+		AND version = $6`
+	stmt, err := d.prepare(query)
+	if err != nil {
+		return false, err
+	}
+	defer stmt.Close()
+
+	res, err := stmt.Exec(b.tokens, b.version, b.updatedAt, b.fullAt, id, version)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n == 1, nil
+}
+
+// deleteFullRateLimitBuckets deletes the rate limit buckets that has been
+// refilled, they are the same as a bucket that doesn't exist.
+func (d *db) deleteFullRateLimitBuckets(now time.Time) error {
+	query := "DELETE FROM rate_limit_bucket WHERE full_at <= $1"
+	stmt, err := d.prepare(query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(now)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,471 @@
+package main
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/osm/migrator"
+)
+
+// newTestSQLiteDB returns a migrated SQLite database in a temporary
+// directory. The database is migrated twice to make sure that migrating an
+// already migrated database works.
+func newTestSQLiteDB(t *testing.T) *db {
+	dir, err := ioutil.TempDir("", "dumpinen-db-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	cs := sqlitePrefix + filepath.Join(dir, "db.sqlite")
+	d, err := newDB(cs)
+	if err != nil {
+		t.Fatalf("newDB: %v", err)
+	}
+	d.conn.Close()
+
+	if d, err = newDB(cs); err != nil {
+		t.Fatalf("newDB on migrated database: %v", err)
+	}
+	t.Cleanup(func() { d.conn.Close() })
+
+	return d
+}
+
+func TestParseConnectionString(t *testing.T) {
+	tests := []struct {
+		cs      string
+		dialect dialect
+		driver  string
+		dsn     string
+	}{
+		{"postgres://u:p@localhost/dumpinen", dialectPostgres, "postgres", "postgres://u:p@localhost/dumpinen"},
+		{"host=localhost dbname=dumpinen", dialectPostgres, "postgres", "host=localhost dbname=dumpinen"},
+		{"sqlite:///var/lib/dumpinen/db.sqlite", dialectSQLite, "sqlite3", "/var/lib/dumpinen/db.sqlite?_busy_timeout=5000&_foreign_keys=on"},
+		{"sqlite://db.sqlite?cache=shared", dialectSQLite, "sqlite3", "db.sqlite?cache=shared&_busy_timeout=5000&_foreign_keys=on"},
+	}
+
+	for _, tt := range tests {
+		dialect, driver, dsn := parseConnectionString(tt.cs)
+		if dialect != tt.dialect || driver != tt.driver || dsn != tt.dsn {
+			t.Errorf("%s: got %s %s %s", tt.cs, dialect, driver, dsn)
+		}
+	}
+}
+
+func TestRebind(t *testing.T) {
+	d := &db{dialect: dialectSQLite}
+	if q := d.rebind("UPDATE t SET a = $1 WHERE b = $10"); q != "UPDATE t SET a = ?1 WHERE b = ?10" {
+		t.Errorf("got %s", q)
+	}
+
+	d = &db{dialect: dialectPostgres}
+	if q := d.rebind("SELECT $1"); q != "SELECT $1" {
+		t.Errorf("got %s", q)
+	}
+}
+
+func TestSQLiteDumps(t *testing.T) {
+	d := newTestSQLiteDB(t)
+
+	size := int64(3)
+	hash := "hash"
+	tokenHash := hashToken("token")
+	remaining := 2
+	empty := []byte{}
+	deleteAfter := time.Now().Add(time.Hour)
+	expiredAt := time.Now().Add(-time.Minute)
+	for _, du := range []*dump{
+		{publicID: "aaaaaaaaaaa", filesystemID: newUUID(), deleteAfter: &deleteAfter, remainingDownloads: &remaining, clientEncrypted: true},
+		{publicID: "bbbbbbbbbbb", filesystemID: newUUID(), deleteAfter: &expiredAt},
+		{publicID: "ccccccccccc", filesystemID: newUUID()},
+	} {
+		du.contentType = "text/plain"
+		du.encryption = encryptionServer
+		du.size = &size
+		du.contentHash = &hash
+		du.deleteTokenHash = &tokenHash
+		du.username = &empty
+		du.password = &empty
+		if err := d.insertDump(du); err != nil {
+			t.Fatalf("insertDump: %v", err)
+		}
+	}
+
+	// A taken public id is reported so that a new one can be generated.
+	dup := &dump{publicID: "aaaaaaaaaaa", filesystemID: newUUID(), contentType: "text/plain", encryption: encryptionServer}
+	if err := d.insertDump(dup); err != errDuplicatePublicID {
+		t.Errorf("got %v, want errDuplicatePublicID", err)
+	}
+
+	du, err := d.getDumpByPublicID("aaaaaaaaaaa")
+	if err != nil {
+		t.Fatalf("getDumpByPublicID: %v", err)
+	}
+	if du.publicID != "aaaaaaaaaaa" || du.contentType != "text/plain" || *du.size != 3 || du.deletedAt != nil || !du.clientEncrypted {
+		t.Errorf("unexpected dump %+v", du)
+	}
+	if du.deleteAfter == nil || !du.deleteAfter.Equal(deleteAfter) {
+		t.Errorf("got deleteAfter %v, want %v", du.deleteAfter, deleteAfter)
+	}
+	if du, _ := d.getDumpByPublicID("ccccccccccc"); du.deleteAfter != nil || du.clientEncrypted {
+		t.Errorf("got deleteAfter %v and client encrypted %v, want nil and false", du.deleteAfter, du.clientEncrypted)
+	}
+	if time.Since(du.insertedAt) > time.Minute || time.Since(du.insertedAt) < 0 {
+		t.Errorf("got insertedAt %v", du.insertedAt)
+	}
+
+	if _, err = d.getDumpByPublicID("ddddddddddd"); err != sql.ErrNoRows {
+		t.Errorf("got %v, want sql.ErrNoRows", err)
+	}
+
+	// Only the dump with a passed expiry is returned, dumps without
+	// expiry are never deleted.
+	ids, err := d.getFilesystemIDsToDelete()
+	if err != nil {
+		t.Fatalf("getFilesystemIDsToDelete: %v", err)
+	}
+	expired, _ := d.getDumpByPublicID("bbbbbbbbbbb")
+	if len(ids) != 1 || ids[0] != expired.filesystemID {
+		t.Errorf("got %v, want [%s]", ids, expired.filesystemID)
+	}
+
+	if err = d.deleteDumpByFilesystemID(expired.filesystemID); err != nil {
+		t.Fatalf("deleteDumpByFilesystemID: %v", err)
+	}
+	if ids, _ = d.getFilesystemIDsToDelete(); len(ids) != 0 {
+		t.Errorf("got %v after delete", ids)
+	}
+	if expired, _ = d.getDumpByPublicID("bbbbbbbbbbb"); expired.deletedAt == nil {
+		t.Errorf("dump is not marked as deleted")
+	}
+
+	for _, want := range []int{1, 0} {
+		n, err := d.decrementRemainingDownloads(du.id)
+		if err != nil || n != want {
+			t.Errorf("decrementRemainingDownloads: got %d, %v, want %d", n, err, want)
+		}
+	}
+	if _, err = d.decrementRemainingDownloads(du.id); err != sql.ErrNoRows {
+		t.Errorf("got %v, want sql.ErrNoRows", err)
+	}
+
+	if err = d.insertDumpAccessLog(&dumpAccessLog{dumpID: du.id, ipAddress: "127.0.0.1"}); err != nil {
+		t.Fatalf("insertDumpAccessLog: %v", err)
+	}
+	info, err := d.getDumpInfoByPublicID("aaaaaaaaaaa")
+	if err != nil || info.count != 1 {
+		t.Errorf("getDumpInfoByPublicID: got %+v, %v", info, err)
+	}
+
+	if err = d.updateDumpPasswordHash(du.id, "hash"); err != nil {
+		t.Fatalf("updateDumpPasswordHash: %v", err)
+	}
+	if du, _ = d.getDumpByPublicID("aaaaaaaaaaa"); du.passwordHash == nil || *du.passwordHash != "hash" || du.username != nil || du.password != nil {
+		t.Errorf("updateDumpPasswordHash: got %v %v %v", du.passwordHash, du.username, du.password)
+	}
+
+	// Only the dumps with an idle expiry are returned, and the deleted
+	// dump is never returned.
+	idle := int64(60)
+	du.idleExpiry = &idle
+	if err = d.updateDumpMetadata(du); err != nil {
+		t.Fatalf("updateDumpMetadata: %v", err)
+	}
+	activity, err := d.getDumpActivity()
+	if err != nil || len(activity) != 1 {
+		t.Fatalf("getDumpActivity: got %v, %v", activity, err)
+	}
+	if a := activity[0]; a.filesystemID != du.filesystemID || *a.idleExpiry != 60 || a.lastAccessAt == nil ||
+		time.Since(*a.lastAccessAt) > time.Minute || !a.insertedAt.Equal(du.insertedAt) {
+		t.Errorf("getDumpActivity: got %+v", a)
+	}
+
+	// A dump is idle if it was uploaded before the cutoff and hasn't been
+	// accessed since then.
+	if ids, err := d.getIdleFilesystemIDs(time.Now().Add(-time.Minute)); err != nil || len(ids) != 0 {
+		t.Errorf("getIdleFilesystemIDs: got %v, %v", ids, err)
+	}
+	if ids, _ := d.getIdleFilesystemIDs(time.Now().Add(time.Minute)); len(ids) != 2 {
+		t.Errorf("getIdleFilesystemIDs: got %d dumps, want 2", len(ids))
+	}
+	stmt, err := d.prepare("UPDATE dump SET inserted_at = $1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+	if _, err = stmt.Exec(time.Now().Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if ids, err := d.getIdleFilesystemIDs(time.Now().Add(-time.Minute)); err != nil || len(ids) != 1 || ids[0] == du.filesystemID {
+		t.Errorf("getIdleFilesystemIDs: got %v, %v", ids, err)
+	}
+
+	// Only dumps with non-empty encrypted credentials are rekeyed.
+	if dumps, err := d.getDumpsWithEncryptedCredentials(nilUUID, 10); err != nil || len(dumps) != 0 {
+		t.Errorf("getDumpsWithEncryptedCredentials: got %v, %v", dumps, err)
+	}
+	protected, _ := d.getDumpByPublicID("ccccccccccc")
+	if err = d.updateDumpCredentials(protected.id, []byte("user"), []byte("pass")); err != nil {
+		t.Fatalf("updateDumpCredentials: %v", err)
+	}
+	dumps, err := d.getDumpsWithEncryptedCredentials(nilUUID, 10)
+	if err != nil || len(dumps) != 1 || dumps[0].id != protected.id ||
+		string(*dumps[0].username) != "user" || string(*dumps[0].password) != "pass" {
+		t.Errorf("getDumpsWithEncryptedCredentials: got %v, %v", dumps, err)
+	}
+	if dumps, _ = d.getDumpsWithEncryptedCredentials(protected.id, 10); len(dumps) != 0 {
+		t.Errorf("getDumpsWithEncryptedCredentials after %s: got %v", protected.id, dumps)
+	}
+}
+
+// testDumpPaging inserts a few dumps and pages through them one at a time,
+// the database may contain other dumps.
+func testDumpPaging(t *testing.T, d *db) {
+	inserted := map[string]bool{}
+	for i := 0; i < 3; i++ {
+		du := &dump{publicID: newUUID()[:11], filesystemID: newUUID(), contentType: "text/plain", encryption: encryptionServer}
+		if err := d.insertDump(du); err != nil {
+			t.Fatal(err)
+		}
+		du, _ = d.getDumpByPublicID(du.publicID)
+		if err := d.updateDumpCredentials(du.id, []byte("user"), []byte("pass")); err != nil {
+			t.Fatal(err)
+		}
+		inserted[du.id] = true
+	}
+
+	pages := map[string]func(afterID string) ([]*dump, error){
+		"getDumpsByEncryption": func(afterID string) ([]*dump, error) {
+			return d.getDumpsByEncryption(encryptionServer, afterID, 1)
+		},
+		"getDumpsWithEncryptedCredentials": func(afterID string) ([]*dump, error) {
+			return d.getDumpsWithEncryptedCredentials(afterID, 1)
+		},
+	}
+	for name, page := range pages {
+		found := 0
+		for afterID := nilUUID; ; {
+			dumps, err := page(afterID)
+			if err != nil {
+				t.Fatalf("%s after %s: %v", name, afterID, err)
+			}
+			if len(dumps) == 0 {
+				break
+			}
+			if len(dumps) != 1 || dumps[0].id <= afterID {
+				t.Fatalf("%s after %s: got %v", name, afterID, dumps)
+			}
+			if inserted[dumps[0].id] {
+				found++
+			}
+			afterID = dumps[0].id
+		}
+		if found != len(inserted) {
+			t.Errorf("%s: found %d of %d dumps", name, found, len(inserted))
+		}
+	}
+}
+
+func TestSQLiteDumpPaging(t *testing.T) {
+	testDumpPaging(t, newTestSQLiteDB(t))
+}
+
+// TestPostgresDumpPaging runs against the PostgreSQL database of the
+// DUMPINEN_TEST_POSTGRES connection string, where the ids are uuids.
+func TestPostgresDumpPaging(t *testing.T) {
+	cs := os.Getenv("DUMPINEN_TEST_POSTGRES")
+	if cs == "" {
+		t.Skip("DUMPINEN_TEST_POSTGRES isn't set")
+	}
+
+	d, err := newDB(cs)
+	if err != nil {
+		t.Fatalf("newDB: %v", err)
+	}
+	defer d.conn.Close()
+
+	testDumpPaging(t, d)
+}
+
+func TestSQLiteSlugs(t *testing.T) {
+	d := newTestSQLiteDB(t)
+
+	// Slugs are unique among the dumps that hasn't been deleted.
+	slug := "runbook"
+	dup := &dump{publicID: "eeeeeeeeeee", filesystemID: newUUID(), contentType: "text/plain", encryption: encryptionServer, slug: &slug}
+	if err := d.insertDump(dup); err != nil {
+		t.Fatalf("insertDump with slug: %v", err)
+	}
+	dup.publicID, dup.filesystemID = "fffffffffff", newUUID()
+	if err := d.insertDump(dup); err != errDuplicateSlug {
+		t.Errorf("got %v, want errDuplicateSlug", err)
+	}
+	if du, err := d.getDumpBySlug(slug); err != nil || du.publicID != "eeeeeeeeeee" || *du.slug != slug {
+		t.Errorf("getDumpBySlug: got %+v, %v", du, err)
+	}
+	if err := d.deleteDumpByFilesystemID(dup.filesystemID); err != nil {
+		t.Fatal(err)
+	}
+	du, _ := d.getDumpByPublicID("eeeeeeeeeee")
+	if err := d.deleteDumpByFilesystemID(du.filesystemID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.getDumpBySlug(slug); err != sql.ErrNoRows {
+		t.Errorf("got %v, want sql.ErrNoRows for a deleted slug", err)
+	}
+	if err := d.insertDump(dup); err != nil {
+		t.Errorf("insertDump with the slug of a deleted dump: %v", err)
+	}
+}
+
+func TestSQLiteUpdateDumpStorage(t *testing.T) {
+	d := newTestSQLiteDB(t)
+
+	du := &dump{publicID: "ggggggggggg", filesystemID: newUUID(), contentType: "text/plain", encryption: encryptionNone}
+	if err := d.insertDump(du); err != nil {
+		t.Fatal(err)
+	}
+	du, _ = d.getDumpByPublicID(du.publicID)
+	other := &dump{publicID: "hhhhhhhhhhh", filesystemID: newUUID(), contentType: "text/plain", encryption: encryptionNone}
+	if err := d.insertDump(other); err != nil {
+		t.Fatal(err)
+	}
+
+	// The dumps are fetched in batches ordered by id.
+	first, err := d.getDumpsByEncryption(encryptionNone, nilUUID, 1)
+	if err != nil || len(first) != 1 {
+		t.Fatalf("getDumpsByEncryption: got %v, %v", first, err)
+	}
+	second, err := d.getDumpsByEncryption(encryptionNone, first[0].id, 1)
+	if err != nil || len(second) != 1 || second[0].id <= first[0].id {
+		t.Errorf("getDumpsByEncryption after %s: got %v, %v", first[0].id, second, err)
+	}
+
+	filesystemID := newUUID()
+	if err := d.updateDumpStorage(du.id, filesystemID, encryptionServer, 3, "hash"); err != nil {
+		t.Fatalf("updateDumpStorage: %v", err)
+	}
+	du, err = d.getDumpByPublicID(du.publicID)
+	if err != nil || du.filesystemID != filesystemID || du.encryption != encryptionServer ||
+		du.size == nil || *du.size != 3 || du.contentHash == nil || *du.contentHash != "hash" {
+		t.Errorf("got %+v, %v", du, err)
+	}
+	if dumps, _ := d.getDumpsByEncryption(encryptionNone, nilUUID, 10); len(dumps) != 1 || dumps[0].publicID != other.publicID {
+		t.Errorf("getDumpsByEncryption after update: got %v", dumps)
+	}
+}
+
+// TestSQLiteNullableDeleteAfter makes sure that the zero timestamps that
+// were used for dumps without expiry are converted to NULL, regardless of
+// the timezone they were written in.
+func TestSQLiteNullableDeleteAfter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dumpinen-db-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	cs := sqlitePrefix + filepath.Join(dir, "db.sqlite")
+	_, driver, dsn := parseConnectionString(cs)
+	conn, err := sql.Open(driver, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = migrator.ToVersion(conn, getDatabaseRepository(dialectSQLite), 9); err != nil {
+		t.Fatalf("migrate to version 9: %v", err)
+	}
+
+	rows := map[string]string{
+		"aaaaaaaaaaa": "0001-01-01 00:00:00+00:00",
+		"bbbbbbbbbbb": "0001-01-01 01:12:12+01:12:12",
+		"ccccccccccc": "2030-01-01 00:00:00+00:00",
+	}
+	for publicID, deleteAfter := range rows {
+		id := newUUID()
+		_, err = conn.Exec(`INSERT INTO dump (id, public_id, filesystem_id, content_type, ip_address, delete_after)
+			VALUES (?, ?, ?, 'text/plain', '127.0.0.1', ?)`, id, publicID, newUUID(), deleteAfter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = conn.Exec(`INSERT INTO dump_access_log (id, dump_id, ip_address) VALUES (?, ?, '127.0.0.1')`, newUUID(), id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	conn.Close()
+
+	d, err := newDB(cs)
+	if err != nil {
+		t.Fatalf("newDB: %v", err)
+	}
+	defer d.conn.Close()
+
+	for publicID := range rows {
+		du, err := d.getDumpByPublicID(publicID)
+		if err != nil {
+			t.Fatalf("getDumpByPublicID: %v", err)
+		}
+		if publicID == "ccccccccccc" {
+			if du.deleteAfter == nil || du.deleteAfter.Year() != 2030 {
+				t.Errorf("%s: got deleteAfter %v", publicID, du.deleteAfter)
+			}
+		} else if du.deleteAfter != nil {
+			t.Errorf("%s: got deleteAfter %v, want nil", publicID, du.deleteAfter)
+		}
+
+		if info, err := d.getDumpInfoByPublicID(publicID); err != nil || info.count != 1 {
+			t.Errorf("%s: got %+v, %v", publicID, info, err)
+		}
+	}
+
+	if ids, err := d.getFilesystemIDsToDelete(); err != nil || len(ids) != 0 {
+		t.Errorf("getFilesystemIDsToDelete: got %v, %v", ids, err)
+	}
+
+	// The foreign key of the access log must reference the rebuilt table.
+	_, err = d.conn.Exec(`INSERT INTO dump_access_log (id, dump_id, ip_address) VALUES (?, ?, '127.0.0.1')`, newUUID(), newUUID())
+	if err == nil {
+		t.Errorf("access log accepted an unknown dump id")
+	}
+}
+
+func TestSQLiteTusUploads(t *testing.T) {
+	d := newTestSQLiteDB(t)
+
+	idleExpiry, maxDownloads, slug := int64(3600), 2, "big-file"
+	tu := &tusUpload{
+		id:           newUUID(),
+		length:       10,
+		ipAddress:    "127.0.0.1",
+		idleExpiry:   &idleExpiry,
+		maxDownloads: &maxDownloads,
+		slug:         &slug,
+		expiresAt:    time.Now().Add(time.Hour),
+	}
+	if err := d.insertTusUpload(tu); err != nil {
+		t.Fatalf("insertTusUpload: %v", err)
+	}
+
+	ok, err := d.updateTusUploadOffset(tu.id, 0, 5, time.Now().Add(-time.Minute))
+	if err != nil || !ok {
+		t.Fatalf("updateTusUploadOffset: %v, %v", ok, err)
+	}
+	if ok, _ = d.updateTusUploadOffset(tu.id, 0, 5, time.Now()); ok {
+		t.Errorf("offset was updated from a stale offset")
+	}
+
+	got, err := d.getTusUploadByID(tu.id)
+	if err != nil || got.offset != 5 || got.length != 10 || *got.idleExpiry != idleExpiry ||
+		*got.maxDownloads != maxDownloads || *got.slug != slug || got.ownerKeyHash != nil {
+		t.Errorf("getTusUploadByID: got %+v, %v", got, err)
+	}
+
+	ids, err := d.getExpiredTusUploadIDs()
+	if err != nil || len(ids) != 1 || ids[0] != tu.id {
+		t.Errorf("getExpiredTusUploadIDs: got %v, %v", ids, err)
+	}
+}
@@ -4,38 +4,277 @@ import (
 	"github.com/osm/migrator/repository"
 )
 
-// getDatabaseRepository returns the migrations for the database.
-func getDatabaseRepository() repository.Source {
-	return repository.FromMemory(map[int]string{
-		1: `
-			CREATE TABLE migration (
-				version int NOT NULL PRIMARY KEY
-			);
-		`,
-		2: `
-			CREATE TABLE dump (
-				id uuid NOT NULL PRIMARY KEY,
-				public_id text NOT NULL,
-				filesystem_id uuid NOT NULL,
-				content_type text NOT NULL,
-				ip_address text NOT NULL,
-				delete_after timestamptz NOT NULL,
-				encrypted_username bytea DEFAULT NULL,
-				encrypted_password bytea DEFAULT NULL,
-				deleted_at timestamptz DEFAULT NULL,
-				inserted_at timestamptz  DEFAULT transaction_timestamp() NOT NULL
-			);
-			CREATE INDEX dump_delete_after ON dump(delete_after);
-			CREATE INDEX dump_public_id_idx ON dump(public_id);
-			CREATE UNIQUE INDEX dump_filesystem_id_uniq_idx ON dump(filesystem_id);
-			CREATE UNIQUE INDEX dump_public_id_uniq_idx ON dump(public_id);
-
-			CREATE TABLE dump_access_log (
-				id uuid NOT NULL PRIMARY KEY,
-				dump_id uuid NOT NULL REFERENCES dump(id),
-				ip_address text NOT NULL,
-				inserted_at timestamptz  DEFAULT transaction_timestamp() NOT NULL
-			);
-		`,
-	})
+// getDatabaseRepository returns the migrations for the database dialect.
+func getDatabaseRepository(d dialect) repository.Source {
+	if d == dialectSQLite {
+		return repository.FromMemory(sqliteMigrations)
+	}
+
+	return repository.FromMemory(postgresMigrations)
+}
+
+// postgresMigrations contains the migrations for PostgreSQL.
+var postgresMigrations = map[int]string{
+	1: `
+		CREATE TABLE migration (
+			version int NOT NULL PRIMARY KEY
+		);
+	`,
+	2: `
+		CREATE TABLE dump (
+			id uuid NOT NULL PRIMARY KEY,
+			public_id text NOT NULL,
+			filesystem_id uuid NOT NULL,
+			content_type text NOT NULL,
+			ip_address text NOT NULL,
+			delete_after timestamptz NOT NULL,
+			encrypted_username bytea DEFAULT NULL,
+			encrypted_password bytea DEFAULT NULL,
+			deleted_at timestamptz DEFAULT NULL,
+			inserted_at timestamptz  DEFAULT transaction_timestamp() NOT NULL
+		);
+		CREATE INDEX dump_delete_after ON dump(delete_after);
+		CREATE INDEX dump_public_id_idx ON dump(public_id);
+		CREATE UNIQUE INDEX dump_filesystem_id_uniq_idx ON dump(filesystem_id);
+		CREATE UNIQUE INDEX dump_public_id_uniq_idx ON dump(public_id);
+
+		CREATE TABLE dump_access_log (
+			id uuid NOT NULL PRIMARY KEY,
+			dump_id uuid NOT NULL REFERENCES dump(id),
+			ip_address text NOT NULL,
+			inserted_at timestamptz  DEFAULT transaction_timestamp() NOT NULL
+		);
+	`,
+	3: `
+		ALTER TABLE dump ADD COLUMN encryption text NOT NULL DEFAULT 'none';
+	`,
+	4: `
+		ALTER TABLE dump ADD COLUMN size bigint DEFAULT NULL;
+	`,
+	5: `
+		ALTER TABLE dump ADD COLUMN content_hash text DEFAULT NULL;
+	`,
+	6: `
+		CREATE TABLE tus_upload (
+			id uuid NOT NULL PRIMARY KEY,
+			upload_length bigint NOT NULL,
+			upload_offset bigint NOT NULL DEFAULT 0,
+			content_type text DEFAULT NULL,
+			delete_after text DEFAULT NULL,
+			ip_address text NOT NULL,
+			encrypted_username bytea DEFAULT NULL,
+			encrypted_password bytea DEFAULT NULL,
+			public_id text DEFAULT NULL,
+			expires_at timestamptz NOT NULL,
+			inserted_at timestamptz DEFAULT transaction_timestamp() NOT NULL
+		);
+		CREATE INDEX tus_upload_expires_at_idx ON tus_upload(expires_at);
+	`,
+	7: `
+		ALTER TABLE dump ADD COLUMN remaining_downloads integer DEFAULT NULL;
+	`,
+	8: `
+		ALTER TABLE dump ADD COLUMN delete_token_hash text DEFAULT NULL;
+	`,
+	9: `
+		ALTER TABLE dump ADD COLUMN owner_key_hash text DEFAULT NULL;
+		CREATE INDEX dump_owner_key_hash_idx ON dump(owner_key_hash);
+	`,
+	10: `
+		ALTER TABLE dump ALTER COLUMN delete_after DROP NOT NULL;
+		UPDATE dump SET delete_after = NULL WHERE delete_after < '0002-01-01 00:00:00+00';
+	`,
+	11: `
+		ALTER TABLE dump ADD COLUMN idle_expiry bigint DEFAULT NULL;
+		CREATE INDEX dump_access_log_dump_id_idx ON dump_access_log(dump_id, inserted_at);
+	`,
+	12: `
+		ALTER TABLE dump ADD COLUMN password_hash text DEFAULT NULL;
+		ALTER TABLE tus_upload ADD COLUMN password_hash text DEFAULT NULL;
+	`,
+	13: `
+		ALTER TABLE dump ADD COLUMN client_encrypted boolean NOT NULL DEFAULT false;
+	`,
+	14: `
+		ALTER TABLE dump ADD COLUMN slug text DEFAULT NULL;
+		CREATE UNIQUE INDEX dump_slug_uniq_idx ON dump(slug) WHERE deleted_at IS NULL;
+	`,
+	15: `
+		CREATE TABLE rate_limit_bucket (
+			id text NOT NULL PRIMARY KEY,
+			tokens double precision NOT NULL,
+			version bigint NOT NULL,
+			updated_at timestamptz NOT NULL,
+			full_at timestamptz NOT NULL
+		);
+		CREATE INDEX rate_limit_bucket_full_at_idx ON rate_limit_bucket(full_at);
+	`,
+	16: `
+		ALTER TABLE tus_upload ADD COLUMN idle_expiry bigint DEFAULT NULL;
+		ALTER TABLE tus_upload ADD COLUMN max_downloads integer DEFAULT NULL;
+		ALTER TABLE tus_upload ADD COLUMN slug text DEFAULT NULL;
+		ALTER TABLE tus_upload ADD COLUMN owner_key_hash text DEFAULT NULL;
+	`,
+}
+
+// sqliteMigrations contains the migrations for SQLite, they must result in
+// a schema that is equivalent to the PostgreSQL schema. Timestamps are
+// declared as timestamp so that the driver parses them to time.Time.
+var sqliteMigrations = map[int]string{
+	1: `
+		CREATE TABLE migration (
+			version int NOT NULL PRIMARY KEY
+		);
+	`,
+	2: `
+		CREATE TABLE dump (
+			id text NOT NULL PRIMARY KEY,
+			public_id text NOT NULL,
+			filesystem_id text NOT NULL,
+			content_type text NOT NULL,
+			ip_address text NOT NULL,
+			delete_after timestamp NOT NULL,
+			encrypted_username blob DEFAULT NULL,
+			encrypted_password blob DEFAULT NULL,
+			deleted_at timestamp DEFAULT NULL,
+			inserted_at timestamp DEFAULT CURRENT_TIMESTAMP NOT NULL
+		);
+		CREATE INDEX dump_delete_after ON dump(delete_after);
+		CREATE INDEX dump_public_id_idx ON dump(public_id);
+		CREATE UNIQUE INDEX dump_filesystem_id_uniq_idx ON dump(filesystem_id);
+		CREATE UNIQUE INDEX dump_public_id_uniq_idx ON dump(public_id);
+
+		CREATE TABLE dump_access_log (
+			id text NOT NULL PRIMARY KEY,
+			dump_id text NOT NULL REFERENCES dump(id),
+			ip_address text NOT NULL,
+			inserted_at timestamp DEFAULT CURRENT_TIMESTAMP NOT NULL
+		);
+	`,
+	3: `
+		ALTER TABLE dump ADD COLUMN encryption text NOT NULL DEFAULT 'none';
+	`,
+	4: `
+		ALTER TABLE dump ADD COLUMN size integer DEFAULT NULL;
+	`,
+	5: `
+		ALTER TABLE dump ADD COLUMN content_hash text DEFAULT NULL;
+	`,
+	6: `
+		CREATE TABLE tus_upload (
+			id text NOT NULL PRIMARY KEY,
+			upload_length integer NOT NULL,
+			upload_offset integer NOT NULL DEFAULT 0,
+			content_type text DEFAULT NULL,
+			delete_after text DEFAULT NULL,
+			ip_address text NOT NULL,
+			encrypted_username blob DEFAULT NULL,
+			encrypted_password blob DEFAULT NULL,
+			public_id text DEFAULT NULL,
+			expires_at timestamp NOT NULL,
+			inserted_at timestamp DEFAULT CURRENT_TIMESTAMP NOT NULL
+		);
+		CREATE INDEX tus_upload_expires_at_idx ON tus_upload(expires_at);
+	`,
+	7: `
+		ALTER TABLE dump ADD COLUMN remaining_downloads integer DEFAULT NULL;
+	`,
+	8: `
+		ALTER TABLE dump ADD COLUMN delete_token_hash text DEFAULT NULL;
+	`,
+	9: `
+		ALTER TABLE dump ADD COLUMN owner_key_hash text DEFAULT NULL;
+		CREATE INDEX dump_owner_key_hash_idx ON dump(owner_key_hash);
+	`,
+	// SQLite can't drop a NOT NULL constraint, so the dump table is
+	// rebuilt. The access log is rebuilt as well, its foreign key
+	// references dump_new and follows the table when it is renamed.
+	10: `
+		CREATE TABLE dump_new (
+			id text NOT NULL PRIMARY KEY,
+			public_id text NOT NULL,
+			filesystem_id text NOT NULL,
+			content_type text NOT NULL,
+			ip_address text NOT NULL,
+			delete_after timestamp DEFAULT NULL,
+			encrypted_username blob DEFAULT NULL,
+			encrypted_password blob DEFAULT NULL,
+			deleted_at timestamp DEFAULT NULL,
+			inserted_at timestamp DEFAULT CURRENT_TIMESTAMP NOT NULL,
+			encryption text NOT NULL DEFAULT 'none',
+			size integer DEFAULT NULL,
+			content_hash text DEFAULT NULL,
+			remaining_downloads integer DEFAULT NULL,
+			delete_token_hash text DEFAULT NULL,
+			owner_key_hash text DEFAULT NULL
+		);
+		INSERT INTO dump_new SELECT
+			id,
+			public_id,
+			filesystem_id,
+			content_type,
+			ip_address,
+			CASE WHEN delete_after < '0002-01-01' THEN NULL ELSE delete_after END,
+			encrypted_username,
+			encrypted_password,
+			deleted_at,
+			inserted_at,
+			encryption,
+			size,
+			content_hash,
+			remaining_downloads,
+			delete_token_hash,
+			owner_key_hash
+		FROM dump;
+
+		CREATE TABLE dump_access_log_new (
+			id text NOT NULL PRIMARY KEY,
+			dump_id text NOT NULL REFERENCES dump_new(id),
+			ip_address text NOT NULL,
+			inserted_at timestamp DEFAULT CURRENT_TIMESTAMP NOT NULL
+		);
+		INSERT INTO dump_access_log_new SELECT id, dump_id, ip_address, inserted_at FROM dump_access_log;
+
+		DROP TABLE dump_access_log;
+		DROP TABLE dump;
+		ALTER TABLE dump_new RENAME TO dump;
+		ALTER TABLE dump_access_log_new RENAME TO dump_access_log;
+
+		CREATE INDEX dump_delete_after ON dump(delete_after);
+		CREATE INDEX dump_public_id_idx ON dump(public_id);
+		CREATE UNIQUE INDEX dump_filesystem_id_uniq_idx ON dump(filesystem_id);
+		CREATE UNIQUE INDEX dump_public_id_uniq_idx ON dump(public_id);
+		CREATE INDEX dump_owner_key_hash_idx ON dump(owner_key_hash);
+	`,
+	11: `
+		ALTER TABLE dump ADD COLUMN idle_expiry integer DEFAULT NULL;
+		CREATE INDEX dump_access_log_dump_id_idx ON dump_access_log(dump_id, inserted_at);
+	`,
+	12: `
+		ALTER TABLE dump ADD COLUMN password_hash text DEFAULT NULL;
+		ALTER TABLE tus_upload ADD COLUMN password_hash text DEFAULT NULL;
+	`,
+	13: `
+		ALTER TABLE dump ADD COLUMN client_encrypted boolean NOT NULL DEFAULT false;
+	`,
+	14: `
+		ALTER TABLE dump ADD COLUMN slug text DEFAULT NULL;
+		CREATE UNIQUE INDEX dump_slug_uniq_idx ON dump(slug) WHERE deleted_at IS NULL;
+	`,
+	15: `
+		CREATE TABLE rate_limit_bucket (
+			id text NOT NULL PRIMARY KEY,
+			tokens real NOT NULL,
+			version integer NOT NULL,
+			updated_at timestamp NOT NULL,
+			full_at timestamp NOT NULL
+		);
+		CREATE INDEX rate_limit_bucket_full_at_idx ON rate_limit_bucket(full_at);
+	`,
+	16: `
+		ALTER TABLE tus_upload ADD COLUMN idle_expiry integer DEFAULT NULL;
+		ALTER TABLE tus_upload ADD COLUMN max_downloads integer DEFAULT NULL;
+		ALTER TABLE tus_upload ADD COLUMN slug text DEFAULT NULL;
+		ALTER TABLE tus_upload ADD COLUMN owner_key_hash text DEFAULT NULL;
+	`,
 }
@@ -0,0 +1,581 @@
+package main
+
+// decryptJS is the script of the decrypt page of the UI. It decrypts client
+// encrypted dumps in the browser, the dumps are age encrypted with an X25519
+// recipient or a passphrase and the identity or passphrase is read from the
+// URL fragment, which is never sent to the server. Only the primitives that
+// age needs and that WebCrypto lacks are implemented, which are X25519,
+// ChaCha20-Poly1305 and scrypt.
+const decryptJS = `(function () {
+	"use strict";
+
+	var utf8 = new TextEncoder();
+	var subtle = crypto.subtle;
+
+	// concat returns the concatenation of the byte arrays.
+	function concat() {
+		var n = 0, i;
+		for (i = 0; i < arguments.length; i++) {
+			n += arguments[i].length;
+		}
+		var out = new Uint8Array(n);
+		for (n = 0, i = 0; i < arguments.length; i++) {
+			out.set(arguments[i], n);
+			n += arguments[i].length;
+		}
+		return out;
+	}
+
+	// equal compares the byte arrays without returning early.
+	function equal(a, b) {
+		if (a.length !== b.length) {
+			return false;
+		}
+		var d = 0;
+		for (var i = 0; i < a.length; i++) {
+			d |= a[i] ^ b[i];
+		}
+		return d === 0;
+	}
+
+	// base64 decodes standard base64 with or without padding.
+	function base64(s) {
+		if (!/^[A-Za-z0-9+\/]*=*$/.test(s)) {
+			throw new Error("invalid base64");
+		}
+		s = s.replace(/=+$/, "");
+		while (s.length % 4 !== 0) {
+			s += "=";
+		}
+		var b = atob(s);
+		var out = new Uint8Array(b.length);
+		for (var i = 0; i < b.length; i++) {
+			out[i] = b.charCodeAt(i);
+		}
+		return out;
+	}
+
+	// leToBig reads a little endian number.
+	function leToBig(b) {
+		var v = 0n;
+		for (var i = b.length - 1; i >= 0; i--) {
+			v = (v << 8n) | BigInt(b[i]);
+		}
+		return v;
+	}
+
+	// bigToLE writes a little endian number of n bytes.
+	function bigToLE(v, n) {
+		var out = new Uint8Array(n);
+		for (var i = 0; i < n; i++) {
+			out[i] = Number(v & 255n);
+			v >>= 8n;
+		}
+		return out;
+	}
+
+	// rotl rotates the 32 bit word to the left.
+	function rotl(v, c) {
+		return (v << c) | (v >>> (32 - c));
+	}
+
+	// chacha20 xors the data with the ChaCha20 key stream from RFC 8439.
+	function chacha20(key, nonce, counter, data) {
+		var kv = new DataView(key.buffer, key.byteOffset, key.byteLength);
+		var nv = new DataView(nonce.buffer, nonce.byteOffset, nonce.byteLength);
+		var s = new Uint32Array(16), x = new Uint32Array(16);
+		var block = new Uint8Array(64), bv = new DataView(block.buffer);
+		var out = new Uint8Array(data.length);
+		var i, j;
+
+		s[0] = 0x61707865;
+		s[1] = 0x3320646e;
+		s[2] = 0x79622d32;
+		s[3] = 0x6b206574;
+		for (i = 0; i < 8; i++) {
+			s[4 + i] = kv.getUint32(i * 4, true);
+		}
+		for (i = 0; i < 3; i++) {
+			s[13 + i] = nv.getUint32(i * 4, true);
+		}
+
+		function qr(a, b, c, d) {
+			x[a] += x[b]; x[d] = rotl(x[d] ^ x[a], 16);
+			x[c] += x[d]; x[b] = rotl(x[b] ^ x[c], 12);
+			x[a] += x[b]; x[d] = rotl(x[d] ^ x[a], 8);
+			x[c] += x[d]; x[b] = rotl(x[b] ^ x[c], 7);
+		}
+
+		for (var off = 0; off < data.length; off += 64) {
+			s[12] = counter++;
+			x.set(s);
+			for (i = 0; i < 10; i++) {
+				qr(0, 4, 8, 12);
+				qr(1, 5, 9, 13);
+				qr(2, 6, 10, 14);
+				qr(3, 7, 11, 15);
+				qr(0, 5, 10, 15);
+				qr(1, 6, 11, 12);
+				qr(2, 7, 8, 13);
+				qr(3, 4, 9, 14);
+			}
+			for (i = 0; i < 16; i++) {
+				bv.setUint32(i * 4, (x[i] + s[i]) >>> 0, true);
+			}
+			for (j = 0; j < 64 && off + j < data.length; j++) {
+				out[off + j] = data[off + j] ^ block[j];
+			}
+		}
+
+		return out;
+	}
+
+	var poly1305P = (1n << 130n) - 5n;
+	var poly1305Clamp = 0x0ffffffc0ffffffc0ffffffc0fffffffn;
+
+	// poly1305 returns the Poly1305 tag of the message.
+	function poly1305(key, msg) {
+		var r = leToBig(key.subarray(0, 16)) & poly1305Clamp;
+		var s = leToBig(key.subarray(16, 32));
+		var acc = 0n;
+		for (var off = 0; off < msg.length; off += 16) {
+			var block = msg.subarray(off, off + 16);
+			var n = leToBig(block) | (1n << BigInt(8 * block.length));
+			acc = ((acc + n) * r) % poly1305P;
+		}
+		return bigToLE(acc + s, 16);
+	}
+
+	// open decrypts and authenticates the ChaCha20-Poly1305 ciphertext
+	// without additional data.
+	function open(key, nonce, data) {
+		if (data.length < 16) {
+			throw new Error("ciphertext too short");
+		}
+		var ct = data.subarray(0, data.length - 16);
+		var tag = data.subarray(data.length - 16);
+		var polyKey = chacha20(key, nonce, 0, new Uint8Array(32));
+
+		var pad = (16 - ct.length % 16) % 16;
+		var lengths = new Uint8Array(16);
+		lengths.set(bigToLE(BigInt(ct.length), 8), 8);
+		if (!equal(poly1305(polyKey, concat(ct, new Uint8Array(pad), lengths)), tag)) {
+			throw new Error("authentication failed");
+		}
+
+		return chacha20(key, nonce, 1, ct);
+	}
+
+	var x25519P = (1n << 255n) - 19n;
+
+	// mod returns the non-negative remainder of a modulo p.
+	function mod(a) {
+		a %= x25519P;
+		return a < 0n ? a + x25519P : a;
+	}
+
+	// inv returns the multiplicative inverse of a modulo p.
+	function inv(a) {
+		var r = 1n, e = x25519P - 2n;
+		for (a = mod(a); e > 0n; e >>= 1n) {
+			if (e & 1n) {
+				r = r * a % x25519P;
+			}
+			a = a * a % x25519P;
+		}
+		return r;
+	}
+
+	// x25519 multiplies the point u by the scalar k as in RFC 7748.
+	function x25519(k, u) {
+		k = k.slice();
+		k[0] &= 248;
+		k[31] &= 127;
+		k[31] |= 64;
+		var scalar = leToBig(k);
+		var x1 = leToBig(u) & ((1n << 255n) - 1n);
+		var x2 = 1n, z2 = 0n, x3 = x1, z3 = 1n, swap = 0n, t;
+
+		for (var i = 254; i >= 0; i--) {
+			var bit = (scalar >> BigInt(i)) & 1n;
+			if (swap ^ bit) {
+				t = x2; x2 = x3; x3 = t;
+				t = z2; z2 = z3; z3 = t;
+			}
+			swap = bit;
+
+			var a = x2 + z2, aa = a * a % x25519P;
+			var b = x2 - z2, bb = b * b % x25519P;
+			var e = aa - bb;
+			var c = x3 + z3, d = x3 - z3;
+			var da = d * a % x25519P, cb = c * b % x25519P;
+			x3 = mod((da + cb) * (da + cb));
+			z3 = mod(x1 * mod((da - cb) * (da - cb)));
+			x2 = mod(aa * bb);
+			z2 = mod(e * (aa + 121665n * e));
+		}
+		if (swap) {
+			x2 = x3;
+			z2 = z3;
+		}
+
+		return bigToLE(mod(x2 * inv(z2)), 32);
+	}
+
+	var bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l";
+
+	// bech32 decodes the bech32 string and returns the data of the given
+	// human readable part.
+	function bech32(s, hrp) {
+		s = s.toLowerCase();
+		var sep = s.lastIndexOf("1");
+		if (sep < 1 || s.slice(0, sep) !== hrp) {
+			throw new Error("invalid key");
+		}
+
+		var values = [], i;
+		for (i = 0; i < hrp.length; i++) {
+			values.push(hrp.charCodeAt(i) >> 5);
+		}
+		values.push(0);
+		for (i = 0; i < hrp.length; i++) {
+			values.push(hrp.charCodeAt(i) & 31);
+		}
+		var data = [];
+		for (i = sep + 1; i < s.length; i++) {
+			var v = bech32Charset.indexOf(s[i]);
+			if (v < 0) {
+				throw new Error("invalid key");
+			}
+			data.push(v);
+		}
+
+		var gen = [0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3];
+		var chk = 1;
+		values.concat(data).forEach(function (v) {
+			var top = chk >>> 25;
+			chk = ((chk & 0x1ffffff) << 5) ^ v;
+			for (var j = 0; j < 5; j++) {
+				if ((top >>> j) & 1) {
+					chk ^= gen[j];
+				}
+			}
+		});
+		if (chk !== 1 || data.length < 6) {
+			throw new Error("invalid key checksum");
+		}
+
+		var out = [], acc = 0, bits = 0;
+		data.slice(0, data.length - 6).forEach(function (v) {
+			acc = (acc << 5) | v;
+			bits += 5;
+			if (bits >= 8) {
+				bits -= 8;
+				out.push((acc >> bits) & 255);
+			}
+		});
+		return new Uint8Array(out);
+	}
+
+	// hkdf returns 32 bytes of HKDF-SHA-256 output.
+	async function hkdf(ikm, salt, info) {
+		var key = await subtle.importKey("raw", ikm, "HKDF", false, ["deriveBits"]);
+		var bits = await subtle.deriveBits({name: "HKDF", hash: "SHA-256", salt: salt, info: utf8.encode(info)}, key, 256);
+		return new Uint8Array(bits);
+	}
+
+	// hmac returns the HMAC-SHA-256 of the message.
+	async function hmac(key, msg) {
+		var k = await subtle.importKey("raw", key, {name: "HMAC", hash: "SHA-256"}, false, ["sign"]);
+		return new Uint8Array(await subtle.sign("HMAC", k, msg));
+	}
+
+	// pbkdf2 returns n bytes of PBKDF2-HMAC-SHA-256 output with a single
+	// iteration, which is what scrypt uses.
+	async function pbkdf2(password, salt, n) {
+		var key = await subtle.importKey("raw", password, "PBKDF2", false, ["deriveBits"]);
+		var bits = await subtle.deriveBits({name: "PBKDF2", hash: "SHA-256", salt: salt, iterations: 1}, key, n * 8);
+		return new Uint8Array(bits);
+	}
+
+	// salsa208 applies the Salsa20/8 core to the 16 words of b at the
+	// offset, x is used as scratch space.
+	function salsa208(b, off, x) {
+		var i;
+		for (i = 0; i < 16; i++) {
+			x[i] = b[off + i];
+		}
+		for (i = 0; i < 8; i += 2) {
+			x[4] ^= rotl(x[0] + x[12], 7); x[8] ^= rotl(x[4] + x[0], 9);
+			x[12] ^= rotl(x[8] + x[4], 13); x[0] ^= rotl(x[12] + x[8], 18);
+			x[9] ^= rotl(x[5] + x[1], 7); x[13] ^= rotl(x[9] + x[5], 9);
+			x[1] ^= rotl(x[13] + x[9], 13); x[5] ^= rotl(x[1] + x[13], 18);
+			x[14] ^= rotl(x[10] + x[6], 7); x[2] ^= rotl(x[14] + x[10], 9);
+			x[6] ^= rotl(x[2] + x[14], 13); x[10] ^= rotl(x[6] + x[2], 18);
+			x[3] ^= rotl(x[15] + x[11], 7); x[7] ^= rotl(x[3] + x[15], 9);
+			x[11] ^= rotl(x[7] + x[3], 13); x[15] ^= rotl(x[11] + x[7], 18);
+			x[1] ^= rotl(x[0] + x[3], 7); x[2] ^= rotl(x[1] + x[0], 9);
+			x[3] ^= rotl(x[2] + x[1], 13); x[0] ^= rotl(x[3] + x[2], 18);
+			x[6] ^= rotl(x[5] + x[4], 7); x[7] ^= rotl(x[6] + x[5], 9);
+			x[4] ^= rotl(x[7] + x[6], 13); x[5] ^= rotl(x[4] + x[7], 18);
+			x[11] ^= rotl(x[10] + x[9], 7); x[8] ^= rotl(x[11] + x[10], 9);
+			x[9] ^= rotl(x[8] + x[11], 13); x[10] ^= rotl(x[9] + x[8], 18);
+			x[12] ^= rotl(x[15] + x[14], 7); x[13] ^= rotl(x[12] + x[15], 9);
+			x[14] ^= rotl(x[13] + x[12], 13); x[15] ^= rotl(x[14] + x[13], 18);
+		}
+		for (i = 0; i < 16; i++) {
+			b[off + i] += x[i];
+		}
+	}
+
+	// blockMix is the scrypt BlockMix of b with Salsa20/8, y is used as
+	// scratch space.
+	function blockMix(b, y, r, x) {
+		var i, j;
+		y.set(b.subarray((2 * r - 1) * 16, 2 * r * 16), 0);
+		for (i = 0; i < 2 * r; i++) {
+			for (j = 0; j < 16; j++) {
+				y[j] ^= b[i * 16 + j];
+			}
+			salsa208(y, 0, x);
+			// The even blocks goes to the first half and the odd
+			// blocks to the second half.
+			b.set(y.subarray(0, 16), 2 * r * 16 + ((i & 1) * r + (i >> 1)) * 16);
+		}
+		b.copyWithin(0, 2 * r * 16, 4 * r * 16);
+	}
+
+	// scrypt derives a 32 byte key from the password with r = 8 and p = 1.
+	async function scrypt(password, salt, logN) {
+		var r = 8, n = 1 << logN, words = 32 * r, i, j;
+		var bytes = await pbkdf2(password, salt, 128 * r);
+		var dv = new DataView(bytes.buffer);
+
+		// b holds the block followed by space for the shuffled block.
+		var b = new Uint32Array(2 * words), y = new Uint32Array(16), x = new Uint32Array(16);
+		for (i = 0; i < words; i++) {
+			b[i] = dv.getUint32(i * 4, true);
+		}
+
+		var v = new Uint32Array(words * n);
+		for (i = 0; i < n; i++) {
+			v.set(b.subarray(0, words), i * words);
+			blockMix(b, y, r, x);
+		}
+		for (i = 0; i < n; i++) {
+			var k = (b[(2 * r - 1) * 16] & (n - 1)) * words;
+			for (j = 0; j < words; j++) {
+				b[j] ^= v[k + j];
+			}
+			blockMix(b, y, r, x);
+		}
+
+		for (i = 0; i < words; i++) {
+			dv.setUint32(i * 4, b[i], true);
+		}
+		return pbkdf2(password, bytes, 32);
+	}
+
+	// parseHeader parses the age header and returns the stanzas, the MAC,
+	// the part of the header that the MAC covers and the payload.
+	function parseHeader(data) {
+		var pos = 0;
+		function line() {
+			var end = data.indexOf(10, pos);
+			if (end < 0 || end - pos > 1024) {
+				throw new Error("invalid header");
+			}
+			var l = String.fromCharCode.apply(null, data.subarray(pos, end));
+			pos = end + 1;
+			return l;
+		}
+
+		if (line() !== "age-encryption.org/v1") {
+			throw new Error("not an age encrypted file");
+		}
+
+		var stanzas = [];
+		for (;;) {
+			var start = pos, l = line();
+			if (l.indexOf("--- ") === 0) {
+				return {
+					stanzas: stanzas,
+					mac: base64(l.slice(4)),
+					header: data.subarray(0, start + 3),
+					payload: data.subarray(pos)
+				};
+			}
+			if (l.indexOf("-> ") !== 0) {
+				throw new Error("invalid header");
+			}
+
+			var body = "", b;
+			do {
+				b = line();
+				body += b;
+			} while (b.length === 64);
+			stanzas.push({args: l.slice(3).split(" "), body: base64(body)});
+		}
+	}
+
+	// dearmor returns the binary age file of an armored file, other
+	// files are returned as is.
+	function dearmor(data) {
+		var begin = "-----BEGIN AGE ENCRYPTED FILE-----";
+		var text = String.fromCharCode.apply(null, data.subarray(0, begin.length));
+		if (text !== begin) {
+			return data;
+		}
+
+		text = new TextDecoder().decode(data).trim();
+		var end = text.indexOf("-----END AGE ENCRYPTED FILE-----");
+		if (end < 0) {
+			throw new Error("invalid armor");
+		}
+		return base64(text.slice(begin.length, end).replace(/\s+/g, ""));
+	}
+
+	var zeroNonce = new Uint8Array(12);
+
+	// unwrap returns the file key of the header, the key is either an age
+	// X25519 identity or a passphrase.
+	async function unwrap(stanzas, key, progress) {
+		var i, s;
+		if (key.indexOf("AGE-SECRET-KEY-1") === 0) {
+			var identity = bech32(key, "age-secret-key-");
+			var base = new Uint8Array(32);
+			base[0] = 9;
+			var recipient = x25519(identity, base);
+
+			for (i = 0; i < stanzas.length; i++) {
+				s = stanzas[i];
+				if (s.args[0] !== "X25519" || s.args.length !== 2) {
+					continue;
+				}
+				var share = base64(s.args[1]);
+				var shared = x25519(identity, share);
+				var wrapKey = await hkdf(shared, concat(share, recipient), "age-encryption.org/v1/X25519");
+				try {
+					return open(wrapKey, zeroNonce, s.body);
+				} catch (e) {
+					// The stanza belongs to another recipient.
+				}
+			}
+			throw new Error("the dump isn't encrypted to this key");
+		}
+
+		for (i = 0; i < stanzas.length; i++) {
+			s = stanzas[i];
+			if (s.args[0] !== "scrypt") {
+				continue;
+			}
+			if (stanzas.length !== 1 || s.args.length !== 3) {
+				throw new Error("invalid scrypt stanza");
+			}
+			var logN = parseInt(s.args[2], 10);
+			if (!(logN > 0 && logN <= 20)) {
+				throw new Error("the scrypt work factor is too large");
+			}
+			progress("Deriving the key from the passphrase, this may take a while.");
+			var k = await scrypt(utf8.encode(key), concat(utf8.encode("age-encryption.org/v1/scrypt"), base64(s.args[1])), logN);
+			try {
+				return open(k, zeroNonce, s.body);
+			} catch (e) {
+				throw new Error("wrong passphrase");
+			}
+		}
+		throw new Error("the dump isn't encrypted with a passphrase");
+	}
+
+	var chunkSize = 64 * 1024;
+
+	// decrypt decrypts the age file with the identity or passphrase.
+	async function decrypt(data, key, progress) {
+		var h = parseHeader(dearmor(data));
+		var fileKey = await unwrap(h.stanzas, key, progress);
+		if (!equal(await hmac(await hkdf(fileKey, new Uint8Array(0), "header"), h.header), h.mac)) {
+			throw new Error("invalid header mac");
+		}
+
+		progress("Decrypting.");
+		var nonce = h.payload.subarray(0, 16);
+		var streamKey = await hkdf(fileKey, nonce, "payload");
+		var ct = h.payload.subarray(16), chunks = [];
+		var chunkNonce = new Uint8Array(12), nv = new DataView(chunkNonce.buffer);
+		for (var off = 0, counter = 0; ; counter++) {
+			var end = Math.min(off + chunkSize + 16, ct.length);
+			nv.setUint32(3, Math.floor(counter / 0x100000000));
+			nv.setUint32(7, counter >>> 0);
+			chunkNonce[11] = end === ct.length ? 1 : 0;
+			// The last chunk can only be empty when the file is empty.
+			if (counter > 0 && end - off === 16) {
+				throw new Error("the last chunk is empty");
+			}
+			chunks.push(open(streamKey, chunkNonce, ct.subarray(off, end)));
+			off = end;
+			if (off === ct.length) {
+				break;
+			}
+		}
+
+		return concat.apply(null, chunks);
+	}
+
+	// page runs the decrypt page, the dump is downloaded and decrypted
+	// with the key of the URL fragment, or with the key that is entered if
+	// the fragment is empty.
+	function page() {
+		var el = document.getElementById("decrypt");
+		if (!el) {
+			return;
+		}
+		var id = el.getAttribute("data-id");
+		var status = document.getElementById("decryptStatus");
+		var form = document.getElementById("decryptForm");
+		var input = document.getElementById("decryptKey");
+		var output = document.getElementById("decryptOutput");
+		var download = document.getElementById("decryptDownload");
+		function progress(text) {
+			status.textContent = text;
+		}
+
+		async function run(key) {
+			form.hidden = true;
+			try {
+				progress("Downloading.");
+				var res = await fetch(encodeURIComponent(id), {cache: "no-store"});
+				if (!res.ok) {
+					throw new Error((await res.text()).trim());
+				}
+				var data = await decrypt(new Uint8Array(await res.arrayBuffer()), key, progress);
+
+				download.href = URL.createObjectURL(new Blob([data], {type: "application/octet-stream"}));
+				download.download = id;
+				download.hidden = false;
+				try {
+					output.value = new TextDecoder("utf-8", {fatal: true}).decode(data);
+					output.hidden = false;
+					progress("Decrypted.");
+				} catch (e) {
+					progress("Decrypted, the contents are binary.");
+				}
+			} catch (e) {
+				progress("Error: " + e.message);
+				form.hidden = false;
+			}
+		}
+
+		form.addEventListener("submit", function (e) {
+			e.preventDefault();
+			run(input.value.trim());
+		});
+
+		var key = decodeURIComponent(location.hash.slice(1));
+		if (key) {
+			run(key);
+		}
+	}
+
+	page();
+})();
+`
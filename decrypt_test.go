@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"filippo.io/age"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+// decryptHarness runs decryptJS with a minimal DOM, the dump is returned by
+// fetch and the key is set as the URL fragment. The status and the
+// decrypted contents are written as JSON when the page is done.
+const decryptHarness = `
+const fs = require("fs");
+const [script, file, key] = process.argv.slice(2);
+const data = fs.readFileSync(file);
+
+const elements = {};
+function element() {
+	return {hidden: false, textContent: "", value: "", getAttribute: () => "id", addEventListener: () => {}};
+}
+globalThis.document = {getElementById: (id) => elements[id] || (elements[id] = element())};
+globalThis.location = {hash: "#" + encodeURIComponent(key)};
+globalThis.fetch = async () => new Response(data);
+let blob;
+URL.createObjectURL = (b) => {
+	blob = b;
+	return "blob:";
+};
+
+eval(fs.readFileSync(script, "utf8"));
+
+const timer = setInterval(async () => {
+	const status = elements.decryptStatus.textContent;
+	if (!status.startsWith("Decrypted") && !status.startsWith("Error")) {
+		return;
+	}
+	clearInterval(timer);
+	const out = blob ? Buffer.from(await blob.arrayBuffer()) : Buffer.alloc(0);
+	process.stdout.write(JSON.stringify({status: status, data: out.toString("base64")}));
+}, 10);
+`
+
+// runDecryptJS decrypts the age file with decryptJS in node and returns the
+// status of the page and the decrypted contents. The test is skipped if
+// node isn't installed.
+func runDecryptJS(t *testing.T, data []byte, key string) (string, []byte) {
+	node, err := exec.LookPath("node")
+	if err != nil {
+		t.Skip("node is required to run decrypt.js")
+	}
+
+	dir := t.TempDir()
+	files := map[string]string{"harness.js": decryptHarness, "decrypt.js": decryptJS, "dump.age": string(data)}
+	for name, contents := range files {
+		if err = ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, node, filepath.Join(dir, "harness.js"),
+		filepath.Join(dir, "decrypt.js"), filepath.Join(dir, "dump.age"), key).Output()
+	if err != nil {
+		t.Fatalf("node: %v", err)
+	}
+
+	var res struct {
+		Status string `json:"status"`
+		Data   []byte `json:"data"`
+	}
+	if err = json.Unmarshal(out, &res); err != nil {
+		t.Fatalf("invalid output %q: %v", out, err)
+	}
+
+	return res.Status, res.Data
+}
+
+// encryptAge encrypts the contents to the recipients with the age library.
+func encryptAge(t *testing.T, contents []byte, recipients ...age.Recipient) []byte {
+	var buf bytes.Buffer
+	w, err := age.Encrypt(&buf, recipients...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(contents)
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// armorAge returns the armored version of the age file.
+func armorAge(data []byte) []byte {
+	b64 := base64.StdEncoding.EncodeToString(data)
+	var buf bytes.Buffer
+	buf.WriteString("-----BEGIN AGE ENCRYPTED FILE-----\n")
+	for len(b64) > 64 {
+		buf.WriteString(b64[:64] + "\n")
+		b64 = b64[64:]
+	}
+	buf.WriteString(b64 + "\n-----END AGE ENCRYPTED FILE-----\n")
+	return buf.Bytes()
+}
+
+// fileKeyRecipient wraps the file key for another recipient and keeps the
+// file key, which makes it possible to build payloads that the age library
+// never writes.
+type fileKeyRecipient struct {
+	age.Recipient
+	fileKey []byte
+}
+
+func (r *fileKeyRecipient) Wrap(fileKey []byte) ([]*age.Stanza, error) {
+	r.fileKey = append([]byte(nil), fileKey...)
+	return r.Recipient.Wrap(fileKey)
+}
+
+// encryptChunks encrypts the chunks to the recipient as they are given,
+// only the last chunk is marked as the last one.
+func encryptChunks(t *testing.T, recipient age.Recipient, chunks ...[]byte) []byte {
+	r := &fileKeyRecipient{Recipient: recipient}
+	data := encryptAge(t, nil, r)
+
+	// The empty payload consists of the nonce and a single empty chunk.
+	header := data[:len(data)-16-chacha20poly1305.Overhead]
+	nonce := data[len(header) : len(header)+16]
+	key := make([]byte, chacha20poly1305.KeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, r.fileKey, nonce, []byte("payload")), key); err != nil {
+		t.Fatal(err)
+	}
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := append([]byte(nil), data[:len(header)+16]...)
+	for i, chunk := range chunks {
+		chunkNonce := make([]byte, chacha20poly1305.NonceSize)
+		binary.BigEndian.PutUint64(chunkNonce[3:11], uint64(i))
+		if i == len(chunks)-1 {
+			chunkNonce[11] = 1
+		}
+		out = aead.Seal(out, chunkNonce, chunk, nil)
+	}
+	return out
+}
+
+func TestDecryptJS(t *testing.T) {
+	identity, _ := age.GenerateX25519Identity()
+	other, _ := age.GenerateX25519Identity()
+	passphrase, _ := age.NewScryptRecipient("correct horse")
+	passphrase.SetWorkFactor(10)
+
+	text := []byte("secret\n")
+	full := bytes.Repeat([]byte("0123456789abcdef"), 64*1024/16)
+	long := append(append([]byte(nil), full...), full[:100]...)
+
+	flipped := encryptAge(t, text, identity.Recipient())
+	flipped[len(flipped)-1] ^= 1
+	twoChunks := encryptAge(t, append(append([]byte(nil), full...), full...), identity.Recipient())
+
+	tests := []struct {
+		name     string
+		data     []byte
+		key      string
+		expected []byte
+		err      string
+	}{
+		{"x25519", encryptAge(t, text, identity.Recipient()), identity.String(), text, ""},
+		{"several recipients", encryptAge(t, text, other.Recipient(), identity.Recipient()), identity.String(), text, ""},
+		{"passphrase", encryptAge(t, text, passphrase), "correct horse", text, ""},
+		{"armored", armorAge(encryptAge(t, text, identity.Recipient())), identity.String(), text, ""},
+		{"empty", encryptAge(t, nil, identity.Recipient()), identity.String(), []byte{}, ""},
+		{"full last chunk", encryptAge(t, full, identity.Recipient()), identity.String(), full, ""},
+		{"two full chunks", twoChunks, identity.String(), append(append([]byte(nil), full...), full...), ""},
+		{"short last chunk", encryptAge(t, long, identity.Recipient()), identity.String(), long, ""},
+		{"flipped tag", flipped, identity.String(), nil, "Error: authentication failed"},
+		{"truncated", twoChunks[:len(twoChunks)-len(full)-chacha20poly1305.Overhead], identity.String(), nil, "Error: authentication failed"},
+		{"empty last chunk", encryptChunks(t, identity.Recipient(), full, nil), identity.String(), nil, "Error: the last chunk is empty"},
+		{"wrong key", encryptAge(t, text, other.Recipient()), identity.String(), nil, "Error: the dump isn't encrypted to this key"},
+		{"wrong passphrase", encryptAge(t, text, passphrase), "wrong horse", nil, "Error: wrong passphrase"},
+	}
+
+	for _, test := range tests {
+		status, data := runDecryptJS(t, test.data, test.key)
+		if test.err != "" {
+			if status != test.err {
+				t.Errorf("%s: got %q, expected %q", test.name, status, test.err)
+			}
+			continue
+		}
+		if !strings.HasPrefix(status, "Decrypted") || !bytes.Equal(data, test.expected) {
+			t.Errorf("%s: got %q with %d bytes, expected %d bytes", test.name, status, len(data), len(test.expected))
+		}
+	}
+
+	// encryptChunks writes valid files, the empty last chunk is the only
+	// reason that the crafted file above is refused.
+	status, data := runDecryptJS(t, encryptChunks(t, identity.Recipient(), full[:10]), identity.String())
+	if !strings.HasPrefix(status, "Decrypted") || !bytes.Equal(data, full[:10]) {
+		t.Errorf("crafted payload: got %q, %q", status, data)
+	}
+}
+
+func TestDecryptRedirect(t *testing.T) {
+	a, _ := newTestApp(t, true)
+
+	identity, _ := age.GenerateX25519Identity()
+	path, _ := uploadDump(t, a, "/", string(encryptAge(t, []byte("secret\n"), identity.Recipient())))
+
+	tests := []struct {
+		target   string
+		headers  []string
+		location string
+	}{
+		{path, []string{"Accept", "text/html,*/*"}, "/decrypt?id=" + path[1:]},
+		{path, []string{"Accept", "*/*"}, ""},
+		{path + "?saveAs=dump.age", []string{"Accept", "text/html"}, ""},
+	}
+	for _, test := range tests {
+		w := request(a, http.MethodGet, test.target, nil, test.headers...)
+		loc := w.Header().Get("Location")
+		if test.location != "" && (w.Code != http.StatusFound || loc != test.location) ||
+			test.location == "" && w.Code != http.StatusOK {
+			t.Errorf("%s %v: got %d %q", test.target, test.headers, w.Code, loc)
+		}
+	}
+	if w := request(a, http.MethodHead, path, nil, "Accept", "text/html"); w.Code != http.StatusOK {
+		t.Errorf("HEAD: got %d", w.Code)
+	}
+
+	// The page is behind the path prefix of the public URL.
+	a.baseURL, _ = parseBaseURL("https://example.com/dumps")
+	w := request(a, http.MethodGet, path, nil, "Accept", "text/html")
+	if loc := w.Header().Get("Location"); w.Code != http.StatusFound || loc != "/dumps/decrypt?id="+path[1:] {
+		t.Errorf("with a path prefix: got %d %q", w.Code, loc)
+	}
+
+	// There's no decrypt page without the UI.
+	a, _ = newTestApp(t, false)
+	path, _ = uploadDump(t, a, "/", string(encryptAge(t, []byte("secret\n"), identity.Recipient())))
+	if w = request(a, http.MethodGet, path, nil, "Accept", "text/html"); w.Code != http.StatusOK {
+		t.Errorf("without the UI: got %d", w.Code)
+	}
+}
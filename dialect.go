@@ -0,0 +1,126 @@
+package main
+
+import (
+	"database/sql"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+// dialect is the SQL dialect of the database.
+type dialect string
+
+const (
+	dialectPostgres dialect = "postgres"
+	dialectSQLite   dialect = "sqlite"
+)
+
+// sqlitePrefix is the prefix of SQLite connection strings, e.g.
+// sqlite:///var/lib/dumpinen/db.sqlite.
+const sqlitePrefix = "sqlite://"
+
+// parseConnectionString returns the dialect, the name of the database/sql
+// driver and the data source name for the connection string. Connection
+// strings that doesn't start with sqlite:// are passed to the PostgreSQL
+// driver as is.
+func parseConnectionString(cs string) (dialect, string, string) {
+	if !strings.HasPrefix(cs, sqlitePrefix) {
+		return dialectPostgres, "postgres", cs
+	}
+
+	dsn := strings.TrimPrefix(cs, sqlitePrefix)
+	if strings.Contains(dsn, "?") {
+		dsn += "&"
+	} else {
+		dsn += "?"
+	}
+	dsn += "_busy_timeout=5000&_foreign_keys=on"
+
+	return dialectSQLite, "sqlite3", dsn
+}
+
+// postgresPlaceholder matches the $N placeholders of the queries.
+var postgresPlaceholder = regexp.MustCompile(`\$(\d+)`)
+
+// rebind rewrites the placeholders of the query for the dialect, the queries
+// are written with the $N placeholders of PostgreSQL and SQLite uses ?N.
+func (d *db) rebind(query string) string {
+	if d.dialect != dialectSQLite {
+		return query
+	}
+
+	return postgresPlaceholder.ReplaceAllString(query, "?$1")
+}
+
+// bindArgs converts the arguments for the dialect. SQLite stores timestamps
+// as text, so they are always stored in UTC to make them comparable. Nil
+// timestamps are stored as NULL.
+func (d *db) bindArgs(args []interface{}) []interface{} {
+	if d.dialect != dialectSQLite {
+		return args
+	}
+
+	for i, arg := range args {
+		switch t := arg.(type) {
+		case time.Time:
+			args[i] = t.UTC()
+		case *time.Time:
+			if t == nil {
+				args[i] = nil
+			} else {
+				args[i] = t.UTC()
+			}
+		}
+	}
+
+	return args
+}
+
+// stmt is a prepared statement that binds the arguments for the dialect of
+// the database.
+type stmt struct {
+	*sql.Stmt
+	d *db
+}
+
+// Exec executes the prepared statement.
+func (s *stmt) Exec(args ...interface{}) (sql.Result, error) {
+	return s.Stmt.Exec(s.d.bindArgs(args)...)
+}
+
+// prepare creates a prepared statement for the query.
+func (d *db) prepare(query string) (*stmt, error) {
+	s, err := d.conn.Prepare(d.rebind(query))
+	if err != nil {
+		return nil, err
+	}
+
+	return &stmt{s, d}, nil
+}
+
+// query executes a query that returns rows.
+func (d *db) query(query string, args ...interface{}) (*sql.Rows, error) {
+	return d.conn.Query(d.rebind(query), d.bindArgs(args)...)
+}
+
+// queryRow executes a query that returns at most one row.
+func (d *db) queryRow(query string, args ...interface{}) *sql.Row {
+	return d.conn.QueryRow(d.rebind(query), d.bindArgs(args)...)
+}
+
+// isUniqueViolation returns true if the error is a violation of the unique
+// index with the given name, the index is identified by its column in the
+// SQLite error messages.
+func isUniqueViolation(err error, index, column string) bool {
+	switch e := err.(type) {
+	case *pq.Error:
+		return e.Code == "23505" && e.Constraint == index
+	case sqlite3.Error:
+		return e.ExtendedCode == sqlite3.ErrConstraintUnique && strings.HasSuffix(e.Error(), column)
+	}
+
+	return false
+}
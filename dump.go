@@ -0,0 +1,464 @@
+package main
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+)
+
+var (
+	// errInvalidDeleteAfter is returned when a request contains an invalid
+	// deleteAfter duration.
+	errInvalidDeleteAfter = errors.New("invalid deleteAfter duration")
+
+	// errInvalidMaxDownloads is returned when a request contains an
+	// invalid maxDownloads value.
+	errInvalidMaxDownloads = errors.New("invalid maxDownloads value")
+
+	// errUnauthorized is returned when a protected dump is requested
+	// without valid basic auth credentials.
+	errUnauthorized = errors.New("unauthorized")
+
+	// errEncryptRequiresPassword is returned when a dump is encrypted with
+	// the password without basic auth credentials.
+	errEncryptRequiresPassword = errors.New("encrypt requires basic auth credentials")
+
+	// errProtectionLocked is returned when the basic auth protection of a
+	// password encrypted dump is changed, the contents can only be
+	// decrypted with the password that they were encrypted with.
+	errProtectionLocked = errors.New("the protection of a password encrypted dump can't be changed")
+
+	// errInvalidSlug is returned when a request contains a slug that
+	// doesn't match the slug pattern or is reserved.
+	errInvalidSlug = errors.New("invalid slug")
+)
+
+// dumpOptions holds the options that are given when a dump is created.
+type dumpOptions struct {
+	deleteAfter  *time.Time
+	idleExpiry   *int64
+	maxDownloads *int
+	contentType  string
+	username     string
+	password     string
+	ownerKeyHash *string
+	ipAddress    string
+	encryption   string
+	recipient    age.Recipient
+	slug         *string
+}
+
+// parseDumpOptions reads the dump options from the query parameters and the
+// basic auth credentials of the request.
+func (a *app) parseDumpOptions(r *http.Request) (*dumpOptions, error) {
+	o := dumpOptions{
+		ipAddress:  a.clientIP(r),
+		encryption: encryptionServer,
+		recipient:  a.recipient,
+	}
+	q := r.URL.Query()
+
+	err := a.parseDumpValues(q, &o)
+	if err != nil {
+		return nil, err
+	}
+
+	// If the request contains basic auth credentials we'll use them to
+	// protect the uploaded file.
+	if u, p, ok := r.BasicAuth(); ok {
+		o.username = u
+		o.password = p
+	}
+
+	// The contents are encrypted with the password instead of the public
+	// key of the server if the user asks for it, which means that the
+	// server can't read them without the password.
+	if e := q.Get("encrypt"); e != "" && e != "0" && e != "false" {
+		if o.username == "" || o.password == "" {
+			return nil, errEncryptRequiresPassword
+		}
+		if o.recipient, err = newPasswordRecipient(o.password); err != nil {
+			return nil, err
+		}
+		o.encryption = encryptionPassword
+	}
+
+	return &o, nil
+}
+
+// parseDumpValues reads the options that are given the same way by the
+// query parameters and by the form of the HTML UI.
+func (a *app) parseDumpValues(v url.Values, o *dumpOptions) error {
+	// Resolve the expiry from the deleteAfter or expiresAt values and
+	// make sure that it's allowed by the server.
+	deleteAfter, err := a.expiry.resolve(v, time.Now())
+	if err != nil {
+		return err
+	}
+	o.deleteAfter = deleteAfter
+
+	if o.idleExpiry, err = parseIdleExpiry(v); err != nil {
+		return err
+	}
+
+	// Check if the user wants the dump to be deleted after a number of
+	// downloads.
+	maxDownloads, err := parseMaxDownloads(v)
+	if err != nil {
+		return errInvalidMaxDownloads
+	}
+	o.maxDownloads = maxDownloads
+
+	if c, ok := v["contentType"]; ok {
+		o.contentType = c[0]
+	}
+
+	if o.slug, err = a.parseSlug(v.Get("slug")); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// parseSlug validates the requested slug, nil is returned if it's empty.
+func (a *app) parseSlug(slug string) (*string, error) {
+	if slug == "" {
+		return nil, nil
+	}
+	if !a.slugs.isValid(slug) {
+		return nil, errInvalidSlug
+	}
+
+	return &slug, nil
+}
+
+// dumpPath returns the path of the dump, which is the slug path if it has a
+// slug.
+func dumpPath(du *dump) string {
+	if du.slug != nil {
+		return slugPath + *du.slug
+	}
+
+	return "/" + du.publicID
+}
+
+// storeDump inserts the dump for the upload into the database and moves the
+// uploaded file into place in the blob store. The created dump is returned
+// together with the delete token, which is never stored in plain text.
+func (a *app) storeDump(up *upload, o *dumpOptions) (*dump, string, error) {
+	// If no content type was given we'll try to autodetect it.
+	contentType := o.contentType
+	if contentType == "" {
+		contentType = http.DetectContentType(up.head)
+	}
+
+	var passwordHash *string
+	if o.username != "" || o.password != "" {
+		hash, err := hashPassword(o.username, o.password)
+		if err != nil {
+			return nil, "", fmt.Errorf("error when hashing password, %v", err)
+		}
+		passwordHash = &hash
+	}
+
+	// The hash of the contents of password encrypted dumps isn't stored,
+	// since it could be used to confirm a guess of the contents.
+	contentHash := &up.hash
+	if o.encryption == encryptionPassword {
+		contentHash = nil
+	}
+
+	deleteToken := newDeleteToken()
+	deleteTokenHash := hashToken(deleteToken)
+	du := &dump{
+		clientEncrypted:    isAgeEncrypted(up.head),
+		contentHash:        contentHash,
+		contentType:        contentType,
+		deleteAfter:        o.deleteAfter,
+		deleteTokenHash:    &deleteTokenHash,
+		encryption:         o.encryption,
+		filesystemID:       newUUID(),
+		idleExpiry:         o.idleExpiry,
+		insertedAt:         time.Now(),
+		ipAddress:          o.ipAddress,
+		ownerKeyHash:       o.ownerKeyHash,
+		passwordHash:       passwordHash,
+		remainingDownloads: o.maxDownloads,
+		size:               &up.size,
+		slug:               o.slug,
+	}
+
+	if err := a.insertDump(du); err == errDuplicateSlug {
+		return nil, "", err
+	} else if err != nil {
+		return nil, "", fmt.Errorf("insert dump error: %v", err)
+	}
+
+	if err := up.blob.Commit(du.filesystemID); err != nil {
+		a.db.deleteDumpByFilesystemID(du.filesystemID)
+		return nil, "", fmt.Errorf("write file error: %v", err)
+	}
+
+	return du, deleteToken, nil
+}
+
+// maxPublicIDAttempts is the number of public ids that are tried before an
+// insert is given up. A collision is unlikely unless the ids are short.
+const maxPublicIDAttempts = 5
+
+// insertDump generates a public id for the dump and inserts it, a new id is
+// generated if the id is already taken.
+func (a *app) insertDump(du *dump) error {
+	for i := 1; ; i++ {
+		du.publicID = a.ids.newID()
+		err := a.db.insertDump(du)
+		if err != errDuplicatePublicID || i == maxPublicIDAttempts {
+			return err
+		}
+		log.Printf("public id %s is already taken, generating a new one\n", du.publicID)
+	}
+}
+
+// getDump fetches the dump with the given public id, or with the given slug
+// if the id is the slug path without the leading slash, e.g.
+// s/oncall-checklist. The slugs are only looked up under the slug path, so a
+// slug never shadows a public id.
+func (a *app) getDump(id string) (*dump, error) {
+	var du *dump
+	var err error
+	slug := strings.TrimPrefix(id, slugPath[1:])
+	switch {
+	case slug != id && a.slugs.isValid(slug):
+		du, err = a.db.getDumpBySlug(slug)
+	case a.ids.isValid(id):
+		du, err = a.db.getDumpByPublicID(id)
+	default:
+		return nil, errDumpNotFound
+	}
+	if err == sql.ErrNoRows {
+		return nil, errDumpNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// The file has been deleted, which means not found is an approperiate
+	// error.
+	if du.deletedAt != nil {
+		return nil, errDumpNotFound
+	}
+
+	return du, nil
+}
+
+// getDumpForRequest fetches the dump with the given public id or slug and
+// makes sure that the request contains the basic auth credentials if the
+// dump is protected.
+func (a *app) getDumpForRequest(r *http.Request, publicID string) (*dump, error) {
+	// Query the database for information about the requested file.
+	du, err := a.getDump(publicID)
+	if err == errDumpNotFound {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("getting file from database error: %v", err)
+	}
+
+	// If we've got a password hash or a bau and bap from the database we
+	// should treat the file as protected and apply basic auth.
+	if !isProtected(du) {
+		return du, nil
+	}
+
+	// The resource is protected, if we didn't get any credentials or if
+	// the credentials doesn't match we'll return an error.
+	u, p, ok := r.BasicAuth()
+	if !ok {
+		return nil, errUnauthorized
+	}
+
+	if du.passwordHash != nil {
+		valid, err := verifyPassword(*du.passwordHash, u, p)
+		if err != nil {
+			return nil, fmt.Errorf("error when verifying password, %v", err)
+		}
+		if !valid {
+			return nil, errUnauthorized
+		}
+
+		// The contents of password encrypted dumps are decrypted with
+		// the password of the request.
+		if du.encryption == encryptionPassword {
+			if du.identity, err = newPasswordIdentity(p); err != nil {
+				return nil, fmt.Errorf("error when creating identity, %v", err)
+			}
+		}
+		return du, nil
+	}
+
+	// Dumps that were protected before the passwords were hashed has
+	// encrypted credentials, they are replaced with a hash as soon as the
+	// right credentials are given.
+	username, err := a.decrypt(*du.username)
+	if err != nil {
+		return nil, fmt.Errorf("error when decrypting username, %v", err)
+	}
+	password, err := a.decrypt(*du.password)
+	if err != nil {
+		return nil, fmt.Errorf("error when decrypting password, %v", err)
+	}
+	if subtle.ConstantTimeCompare([]byte(u), username) != 1 ||
+		subtle.ConstantTimeCompare([]byte(p), password) != 1 {
+		return nil, errUnauthorized
+	}
+
+	if hash, err := hashPassword(u, p); err != nil {
+		log.Printf("error when hashing password of %s, %v\n", du.publicID, err)
+	} else if err = a.db.updateDumpPasswordHash(du.id, hash); err != nil {
+		log.Printf("error when storing password hash of %s, %v\n", du.publicID, err)
+	}
+
+	return du, nil
+}
+
+// isProtected returns true if the dump is protected with basic auth, either
+// with a password hash or with the encrypted credentials of older dumps.
+func isProtected(du *dump) bool {
+	if du.passwordHash != nil {
+		return true
+	}
+
+	return du.username != nil && du.password != nil &&
+		len(*du.username) > 0 && len(*du.password) > 0
+}
+
+// serveDump writes the contents of the dump to the response writer. An error
+// is only returned if nothing has been written to the response writer.
+func (a *app) serveDump(w http.ResponseWriter, r *http.Request, du *dump) error {
+	// Open a seekable reader for the file in the blob store.
+	data, err := a.newContentReader(du)
+	if err != nil {
+		log.Printf("unable to open %s: %v\n", du.filesystemID, err)
+		return errDumpNotFound
+	}
+	defer data.Close()
+
+	// Dumps with a limited number of downloads has the number of
+	// remaining downloads decremented before they are served. When the
+	// last download is served the dump is marked as deleted, and the file
+	// is removed when it has been served. Since every request counts as a
+	// download we'll always serve the whole file, the range and conditional
+	// headers are removed so that a download is never answered with a
+	// partial or empty body.
+	if du.remainingDownloads != nil && r.Method != http.MethodHead {
+		remaining, err := a.db.decrementRemainingDownloads(du.id)
+		if err == sql.ErrNoRows {
+			return errDumpNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("unable to decrement remaining downloads: %v", err)
+		}
+
+		if remaining == 0 {
+			log.Printf("last download of %s, deleting\n", du.publicID)
+			if err = a.db.deleteDumpByFilesystemID(du.filesystemID); err != nil {
+				log.Printf("failed to delete file from database: %v\n", err)
+			}
+			defer func() {
+				data.Close()
+				if err := a.store.Delete(du.filesystemID); err != nil {
+					log.Printf("failed to delete file from blob store: %v\n", err)
+				}
+			}()
+		}
+
+		for _, h := range []string{"Range", "If-Range", "If-Match", "If-None-Match", "If-Modified-Since", "If-Unmodified-Since"} {
+			r.Header.Del(h)
+		}
+	}
+
+	// Insert an entry to the access log, HEAD requests doesn't count as
+	// an access.
+	if r.Method != http.MethodHead {
+		if err = a.db.insertDumpAccessLog(&dumpAccessLog{
+			dumpID:    du.id,
+			ipAddress: a.clientIP(r),
+		}); err != nil {
+			log.Printf("unable to insert access log: %v\n", err)
+		}
+	}
+
+	// Serve the requested file, http.ServeContent takes care of HEAD,
+	// range and conditional requests for us. The hash of the contents is
+	// used as ETag if we've got one.
+	w.Header().Set("Content-Type", du.contentType)
+	if du.contentHash != nil {
+		w.Header().Set("ETag", fmt.Sprintf(`"%s"`, *du.contentHash))
+	}
+
+	var saveAs string
+	if s, ok := r.URL.Query()["saveAs"]; ok {
+		saveAs = s[0]
+	}
+	if saveAs == "" {
+		w.Header().Set("Content-Disposition", "inline")
+	} else {
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, saveAs))
+	}
+
+	http.ServeContent(w, r, "", du.insertedAt, data)
+	return nil
+}
+
+// dumpResource is the JSON representation of a dump.
+type dumpResource struct {
+	ID                 string     `json:"id"`
+	URL                string     `json:"url"`
+	Slug               *string    `json:"slug,omitempty"`
+	ContentType        string     `json:"contentType"`
+	Size               *int64     `json:"size,omitempty"`
+	CreatedAt          time.Time  `json:"createdAt"`
+	ExpiresAt          *time.Time `json:"expiresAt"`
+	ExpireAfterIdle    *string    `json:"expireAfterIdle"`
+	RemainingDownloads *int       `json:"remainingDownloads"`
+	Protected          bool       `json:"protected"`
+	ClientEncrypted    bool       `json:"clientEncrypted"`
+	PasswordEncrypted  bool       `json:"passwordEncrypted"`
+	Count              *int       `json:"count,omitempty"`
+	DeleteToken        string     `json:"deleteToken,omitempty"`
+}
+
+// newDumpResource returns the JSON representation of the dump, the URL is
+// built from the public URL of the server.
+func (a *app) newDumpResource(r *http.Request, du *dump) *dumpResource {
+	res := &dumpResource{
+		ID:                 du.publicID,
+		URL:                a.publicURL(r) + dumpPath(du),
+		Slug:               du.slug,
+		ContentType:        du.contentType,
+		Size:               du.size,
+		CreatedAt:          du.insertedAt.UTC(),
+		RemainingDownloads: du.remainingDownloads,
+		Protected:          isProtected(du),
+		ClientEncrypted:    du.clientEncrypted,
+		PasswordEncrypted:  du.encryption == encryptionPassword,
+	}
+	if du.deleteAfter != nil {
+		expiresAt := du.deleteAfter.UTC()
+		res.ExpiresAt = &expiresAt
+	}
+	if du.idleExpiry != nil {
+		idle := formatDuration(time.Duration(*du.idleExpiry) * time.Second)
+		res.ExpireAfterIdle = &idle
+	}
+
+	return res
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// expiryNever is the deleteAfter value that explicitly asks for a dump that
+// never expires.
+const expiryNever = "never"
+
+var (
+	// errInvalidExpiresAt is returned when a request contains an invalid
+	// expiresAt timestamp, or when it's combined with deleteAfter.
+	errInvalidExpiresAt = errors.New("invalid expiresAt timestamp")
+
+	// errExpiryNotAllowed is returned when the requested expiry is longer
+	// than the server allows.
+	errExpiryNotAllowed = errors.New("expiry not allowed by the server")
+
+	// errInvalidExpireAfterIdle is returned when a request contains an
+	// invalid expireAfterIdle duration.
+	errInvalidExpireAfterIdle = errors.New("invalid expireAfterIdle duration")
+)
+
+// durationUnits are the units that are supported by parseDuration in
+// addition to the ones of time.ParseDuration.
+var durationUnits = map[byte]time.Duration{
+	'd': 24 * time.Hour,
+	'w': 7 * 24 * time.Hour,
+}
+
+// parseDuration parses a duration like time.ParseDuration but also accepts
+// days and weeks, e.g. 7d or 2w. Only positive durations are valid, and days
+// and weeks that don't fit in a duration are refused instead of overflowing.
+func parseDuration(s string) (time.Duration, error) {
+	var d time.Duration
+	var err error
+
+	if n := len(s); n > 1 && durationUnits[s[n-1]] != 0 {
+		unit := durationUnits[s[n-1]]
+		var i int64
+		if i, err = strconv.ParseInt(s[:n-1], 10, 64); err == nil {
+			if i > math.MaxInt64/int64(unit) {
+				return 0, fmt.Errorf("invalid duration %q", s)
+			}
+			d = time.Duration(i) * unit
+		}
+	} else {
+		d, err = time.ParseDuration(s)
+	}
+
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+
+	return d, nil
+}
+
+// formatDuration formats the duration with the largest unit that is
+// supported by parseDuration and that represents it exactly.
+func formatDuration(d time.Duration) string {
+	for _, u := range []byte{'w', 'd'} {
+		if d%durationUnits[u] == 0 {
+			return fmt.Sprintf("%d%c", d/durationUnits[u], u)
+		}
+	}
+
+	// Strip the zero minutes and seconds, e.g. 1h0m0s is formatted as 1h.
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = s[:len(s)-2]
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = s[:len(s)-2]
+	}
+
+	return s
+}
+
+// parseIdleExpiry returns the expireAfterIdle duration in seconds, nil is
+// returned if it's empty. The dump is deleted when it hasn't been downloaded
+// for the duration.
+func parseIdleExpiry(v url.Values) (*int64, error) {
+	s := v.Get("expireAfterIdle")
+	if s == "" {
+		return nil, nil
+	}
+
+	d, err := parseDuration(s)
+	if err != nil || d < time.Second {
+		return nil, errInvalidExpireAfterIdle
+	}
+
+	seconds := int64(d / time.Second)
+	return &seconds, nil
+}
+
+// expiryPolicy holds the rules for the lifetime of the dumps.
+type expiryPolicy struct {
+	// defaultExpiry is used when no expiry is requested, zero means that
+	// the dumps never expires.
+	defaultExpiry time.Duration
+
+	// maxExpiry is the longest allowed expiry, zero means that there is
+	// no limit.
+	maxExpiry time.Duration
+
+	// allowInfinite is true if dumps are allowed to never expire.
+	allowInfinite bool
+}
+
+// newExpiryPolicy returns the policy for the given flag values. Infinite
+// retention is never allowed when there is a maximum expiry, and the maximum
+// expiry is used as default when no default is given and infinite retention
+// isn't allowed.
+func newExpiryPolicy(defaultExpiry, maxExpiry string, allowInfinite bool) (*expiryPolicy, error) {
+	p := &expiryPolicy{allowInfinite: allowInfinite}
+
+	var err error
+	if defaultExpiry != "" {
+		if p.defaultExpiry, err = parseDuration(defaultExpiry); err != nil {
+			return nil, fmt.Errorf("invalid default expiry: %v", err)
+		}
+	}
+	if maxExpiry != "" {
+		if p.maxExpiry, err = parseDuration(maxExpiry); err != nil {
+			return nil, fmt.Errorf("invalid max expiry: %v", err)
+		}
+		p.allowInfinite = false
+	}
+
+	if p.maxExpiry > 0 && p.defaultExpiry > p.maxExpiry {
+		return nil, fmt.Errorf("the default expiry can't be longer than the max expiry")
+	}
+	if !p.allowInfinite && p.defaultExpiry == 0 {
+		if p.maxExpiry == 0 {
+			return nil, fmt.Errorf("a default or max expiry is required when infinite retention isn't allowed")
+		}
+		p.defaultExpiry = p.maxExpiry
+	}
+
+	return p, nil
+}
+
+// resolve returns the expiry time for the deleteAfter and expiresAt values,
+// nil is returned for dumps that never expires. deleteAfter is a duration
+// from now or never, and expiresAt is an RFC 3339 timestamp. The default
+// expiry is used when none of them are given.
+func (p *expiryPolicy) resolve(v url.Values, now time.Time) (*time.Time, error) {
+	da := v.Get("deleteAfter")
+	ea := v.Get("expiresAt")
+
+	var expiry *time.Time
+	switch {
+	case da != "" && ea != "":
+		return nil, errInvalidExpiresAt
+	case da == expiryNever:
+		if !p.allowInfinite {
+			return nil, errExpiryNotAllowed
+		}
+		return nil, nil
+	case da != "":
+		d, err := parseDuration(da)
+		if err != nil {
+			return nil, errInvalidDeleteAfter
+		}
+		t := now.Add(d)
+		expiry = &t
+	case ea != "":
+		t, err := time.Parse(time.RFC3339, ea)
+		if err != nil || !t.After(now) {
+			return nil, errInvalidExpiresAt
+		}
+		expiry = &t
+	case p.defaultExpiry > 0:
+		t := now.Add(p.defaultExpiry)
+		return &t, nil
+	default:
+		return nil, nil
+	}
+
+	if p.maxExpiry > 0 && expiry.After(now.Add(p.maxExpiry)) {
+		return nil, errExpiryNotAllowed
+	}
+
+	return expiry, nil
+}
+
+// expiryOption is an option of the lifetime select of the HTML UI.
+type expiryOption struct {
+	Value    string
+	Label    string
+	Selected bool
+}
+
+// uiExpiryOptions are the lifetimes that are offered by the HTML UI.
+var uiExpiryOptions = []expiryOption{
+	{"10m", "Ten minutes", false},
+	{"1h", "One hour", false},
+	{"24h", "24 hours", false},
+	{"1w", "One week", false},
+	{"30d", "30 days", false},
+	{"90d", "90 days", false},
+}
+
+// options returns the lifetimes that the HTML UI offers, the ones that are
+// longer than the max expiry are left out and the default is selected.
+func (p *expiryPolicy) options() []expiryOption {
+	var options []expiryOption
+	if p.allowInfinite {
+		options = append(options, expiryOption{expiryNever, "Infinite", p.defaultExpiry == 0})
+	}
+
+	var hasDefault bool
+	for _, o := range uiExpiryOptions {
+		d, _ := parseDuration(o.Value)
+		if p.maxExpiry > 0 && d > p.maxExpiry {
+			continue
+		}
+		o.Selected = d == p.defaultExpiry
+		hasDefault = hasDefault || o.Selected
+		options = append(options, o)
+	}
+
+	// Make sure that the default is possible to pick even if it isn't
+	// one of the predefined lifetimes.
+	if p.defaultExpiry > 0 && !hasDefault {
+		v := formatDuration(p.defaultExpiry)
+		options = append(options, expiryOption{v, v, true})
+	}
+
+	return options
+}
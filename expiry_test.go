@@ -0,0 +1,145 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		s string
+		d time.Duration
+	}{
+		{"10m", 10 * time.Minute},
+		{"1h30m", 90 * time.Minute},
+		{"7d", 7 * 24 * time.Hour},
+		{"2w", 14 * 24 * time.Hour},
+		{"90d", 90 * 24 * time.Hour},
+		{"106751d", 106751 * 24 * time.Hour},
+		{"15250w", 15250 * 7 * 24 * time.Hour},
+	}
+	for _, tt := range tests {
+		if d, err := parseDuration(tt.s); err != nil || d != tt.d {
+			t.Errorf("%s: got %v, %v", tt.s, d, err)
+		}
+		if d, err := parseDuration(formatDuration(tt.d)); err != nil || d != tt.d {
+			t.Errorf("formatDuration(%v): got %s", tt.d, formatDuration(tt.d))
+		}
+	}
+
+	if s := formatDuration(90 * time.Minute); s != "1h30m" {
+		t.Errorf("got %s", s)
+	}
+	if s := formatDuration(14 * 24 * time.Hour); s != "2w" {
+		t.Errorf("got %s", s)
+	}
+
+	for _, s := range []string{"", "d", "-1h", "0s", "-7d", "1.5d", "foo", "7x", "999999999999d", "106752d", "15251w", "9223372036854775808d"} {
+		if _, err := parseDuration(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
+
+func TestNewExpiryPolicy(t *testing.T) {
+	day := 24 * time.Hour
+	tests := []struct {
+		defaultExpiry string
+		maxExpiry     string
+		allowInfinite bool
+		want          expiryPolicy
+	}{
+		{"", "", true, expiryPolicy{allowInfinite: true}},
+		{"7d", "", true, expiryPolicy{defaultExpiry: 7 * day, allowInfinite: true}},
+		{"", "90d", true, expiryPolicy{defaultExpiry: 90 * day, maxExpiry: 90 * day}},
+		{"1w", "90d", true, expiryPolicy{defaultExpiry: 7 * day, maxExpiry: 90 * day}},
+		{"24h", "", false, expiryPolicy{defaultExpiry: day}},
+	}
+	for _, tt := range tests {
+		p, err := newExpiryPolicy(tt.defaultExpiry, tt.maxExpiry, tt.allowInfinite)
+		if err != nil || *p != tt.want {
+			t.Errorf("%q %q %v: got %+v, %v", tt.defaultExpiry, tt.maxExpiry, tt.allowInfinite, p, err)
+		}
+	}
+
+	for _, flags := range [][]string{{"foo", ""}, {"", "-1h"}, {"91d", "90d"}} {
+		if _, err := newExpiryPolicy(flags[0], flags[1], true); err == nil {
+			t.Errorf("%v: expected an error", flags)
+		}
+	}
+	if _, err := newExpiryPolicy("", "", false); err == nil {
+		t.Errorf("expected an error when infinite retention is disallowed without a default")
+	}
+}
+
+func TestExpiryPolicyResolve(t *testing.T) {
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+	open := &expiryPolicy{allowInfinite: true}
+	limited := &expiryPolicy{defaultExpiry: 7 * day, maxExpiry: 90 * day}
+
+	tests := []struct {
+		policy *expiryPolicy
+		query  string
+		want   time.Duration
+		err    error
+	}{
+		{open, "", 0, nil},
+		{open, "deleteAfter=", 0, nil},
+		{open, "deleteAfter=never", 0, nil},
+		{open, "deleteAfter=2w", 14 * day, nil},
+		{open, "expiresAt=2021-01-02T01:00:00%2B01:00", day, nil},
+		{open, "deleteAfter=-1h", 0, errInvalidDeleteAfter},
+		{open, "deleteAfter=foo", 0, errInvalidDeleteAfter},
+		{open, "expiresAt=2021-01-02", 0, errInvalidExpiresAt},
+		{open, "expiresAt=2020-12-31T00:00:00Z", 0, errInvalidExpiresAt},
+		{open, "deleteAfter=1h&expiresAt=2021-01-02T00:00:00Z", 0, errInvalidExpiresAt},
+		{limited, "", 7 * day, nil},
+		{limited, "deleteAfter=90d", 90 * day, nil},
+		{limited, "deleteAfter=91d", 0, errExpiryNotAllowed},
+		{limited, "deleteAfter=never", 0, errExpiryNotAllowed},
+		{limited, "expiresAt=2022-01-01T00:00:00Z", 0, errExpiryNotAllowed},
+	}
+
+	for _, tt := range tests {
+		v, _ := url.ParseQuery(tt.query)
+		got, err := tt.policy.resolve(v, now)
+		if err != tt.err {
+			t.Errorf("%q: got error %v, want %v", tt.query, err, tt.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+
+		if tt.want == 0 {
+			if got != nil {
+				t.Errorf("%q: got %v, want no expiry", tt.query, got)
+			}
+		} else if got == nil || !got.Equal(now.Add(tt.want)) {
+			t.Errorf("%q: got %v, want %v", tt.query, got, now.Add(tt.want))
+		}
+	}
+}
+
+func TestExpiryPolicyOptions(t *testing.T) {
+	options := (&expiryPolicy{defaultExpiry: 3 * 24 * time.Hour, maxExpiry: 7 * 24 * time.Hour}).options()
+
+	var values []string
+	var selected string
+	for _, o := range options {
+		values = append(values, o.Value)
+		if o.Selected {
+			selected = o.Value
+		}
+	}
+	if len(values) != 5 || values[0] != "10m" || values[3] != "1w" || selected != "3d" {
+		t.Errorf("got %v with %s selected", values, selected)
+	}
+
+	options = (&expiryPolicy{allowInfinite: true}).options()
+	if options[0].Value != expiryNever || !options[0].Selected || len(options) != len(uiExpiryOptions)+1 {
+		t.Errorf("got %+v", options)
+	}
+}
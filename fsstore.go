@@ -0,0 +1,165 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// fsStore is a blob store that keeps the blobs as files in a flat directory.
+type fsStore struct {
+	dir string
+}
+
+// newFSStore returns a new blob store for the given directory.
+func newFSStore(dir string) (*fsStore, error) {
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return nil, &os.PathError{Op: "stat", Path: dir, Err: os.ErrInvalid}
+	}
+
+	return &fsStore{dir}, nil
+}
+
+// path returns the path to the file for the given blob name.
+func (s *fsStore) path(name string) string {
+	return filepath.Join(s.dir, filepath.Base(name))
+}
+
+// Put writes the contents of the reader to a temporary file which is renamed
+// into place when all data has been written.
+func (s *fsStore) Put(name string, r io.Reader) error {
+	w, err := s.Create()
+	if err != nil {
+		return err
+	}
+	defer w.Abort()
+
+	if _, err = io.Copy(w, r); err != nil {
+		return err
+	}
+
+	return w.Commit(name)
+}
+
+// Get opens the file for the given blob name.
+func (s *fsStore) Get(name string) (io.ReadCloser, error) {
+	f, err := os.Open(s.path(name))
+	if os.IsNotExist(err) {
+		return nil, errBlobNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// Stat returns information about the file for the given blob name.
+func (s *fsStore) Stat(name string) (*blobInfo, error) {
+	fi, err := os.Stat(s.path(name))
+	if os.IsNotExist(err) {
+		return nil, errBlobNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &blobInfo{
+		name:       name,
+		size:       fi.Size(),
+		modifiedAt: fi.ModTime(),
+	}, nil
+}
+
+// Delete removes the file for the given blob name.
+func (s *fsStore) Delete(name string) error {
+	err := os.Remove(s.path(name))
+	if os.IsNotExist(err) {
+		return errBlobNotFound
+	}
+
+	return err
+}
+
+// List returns the names of the files in the directory that starts with the
+// given prefix, temporary files are ignored.
+func (s *fsStore) List(prefix string) ([]string, error) {
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, f := range files {
+		if f.IsDir() || strings.HasPrefix(f.Name(), ".tmp-") {
+			continue
+		}
+		if strings.HasPrefix(f.Name(), prefix) {
+			names = append(names, f.Name())
+		}
+	}
+
+	return names, nil
+}
+
+// Create creates a new temporary file in the directory.
+func (s *fsStore) Create() (blobWriter, error) {
+	f, err := ioutil.TempFile(s.dir, ".tmp-")
+	if err != nil {
+		return nil, err
+	}
+
+	return &fsBlobWriter{s, f, false}, nil
+}
+
+// fsBlobWriter is a temporary file in the directory of a fsStore.
+type fsBlobWriter struct {
+	store *fsStore
+	f     *os.File
+	done  bool
+}
+
+// Write writes to the temporary file.
+func (w *fsBlobWriter) Write(p []byte) (int, error) {
+	return w.f.Write(p)
+}
+
+// Commit closes the temporary file and renames it to the given name.
+func (w *fsBlobWriter) Commit(name string) error {
+	if w.done {
+		return os.ErrClosed
+	}
+	w.done = true
+
+	if err := w.f.Close(); err != nil {
+		os.Remove(w.f.Name())
+		return err
+	}
+	if err := os.Chmod(w.f.Name(), 0440); err != nil {
+		os.Remove(w.f.Name())
+		return err
+	}
+	if err := os.Rename(w.f.Name(), w.store.path(name)); err != nil {
+		os.Remove(w.f.Name())
+		return err
+	}
+
+	return nil
+}
+
+// Abort closes and removes the temporary file.
+func (w *fsBlobWriter) Abort() error {
+	if w.done {
+		return nil
+	}
+	w.done = true
+
+	w.f.Close()
+	return os.Remove(w.f.Name())
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestFSStore(t *testing.T) {
+	dir := t.TempDir()
+	s, err := newFSStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = s.Put("a", strings.NewReader("foo")); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	rc, err := s.Get("a")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	body, _ := ioutil.ReadAll(rc)
+	rc.Close()
+	if string(body) != "foo" {
+		t.Errorf("get: got %q", body)
+	}
+
+	bi, err := s.Stat("a")
+	if err != nil || bi.size != 3 || bi.name != "a" {
+		t.Errorf("stat: got %+v, %v", bi, err)
+	}
+
+	// The name can't escape the directory.
+	if err = s.Put("../b", strings.NewReader("bar")); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	if _, err = os.Stat(filepath.Join(dir, "b")); err != nil {
+		t.Errorf("expected the blob in the directory: %v", err)
+	}
+
+	if err = s.Delete("a"); err != nil {
+		t.Errorf("delete: %v", err)
+	}
+	if _, err = s.Get("a"); err != errBlobNotFound {
+		t.Errorf("get deleted: got %v", err)
+	}
+	if _, err = s.Stat("a"); err != errBlobNotFound {
+		t.Errorf("stat deleted: got %v", err)
+	}
+	if err = s.Delete("a"); err != errBlobNotFound {
+		t.Errorf("delete deleted: got %v", err)
+	}
+
+	if _, err = newFSStore(filepath.Join(dir, "b")); err == nil {
+		t.Errorf("expected an error for a file")
+	}
+}
+
+func TestFSStoreCommitAndAbort(t *testing.T) {
+	dir := t.TempDir()
+	s, err := newFSStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := s.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(w, "aborted")
+	if err = w.Abort(); err != nil {
+		t.Errorf("abort: %v", err)
+	}
+
+	w, err = s.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(w, "committed")
+	if _, err = s.Get("c"); err != errBlobNotFound {
+		t.Errorf("the blob is visible before it is committed: %v", err)
+	}
+	if err = w.Commit("c"); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+	if err = w.Commit("d"); err == nil {
+		t.Errorf("expected an error when committing twice")
+	}
+	if err = w.Abort(); err != nil {
+		t.Errorf("abort after commit: %v", err)
+	}
+
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 1 || files[0].Name() != "c" {
+		t.Fatalf("got files %v", files)
+	}
+	body, _ := ioutil.ReadFile(filepath.Join(dir, "c"))
+	if string(body) != "committed" {
+		t.Errorf("got %q", body)
+	}
+}
+
+func TestFSStoreList(t *testing.T) {
+	dir := t.TempDir()
+	s, err := newFSStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"x1", "x2", "y1"} {
+		if err = s.Put(name, strings.NewReader(name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	os.Mkdir(filepath.Join(dir, "x3"), 0700)
+
+	// A blob that is being written shows up as a temporary file.
+	w, err := s.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Abort()
+
+	names, err := s.List("x")
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	sort.Strings(names)
+	if strings.Join(names, ",") != "x1,x2" {
+		t.Errorf("got %v", names)
+	}
+
+	if names, err = s.List(""); err != nil || len(names) != 3 {
+		t.Errorf("list all: got %v, %v", names, err)
+	}
+}
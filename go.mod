@@ -6,6 +6,7 @@ require (
 	filippo.io/age v1.1.1
 	github.com/creack/pty v1.1.18 // indirect
 	github.com/lib/pq v1.10.9
+	github.com/mattn/go-sqlite3 v1.14.17
 	github.com/osm/flen v1.0.0
 	github.com/osm/migrator v1.1.1
 	github.com/rogpeppe/go-internal v1.8.1 // indirect
@@ -1,55 +1,57 @@
 package main
 
 import (
-	"bytes"
-	"crypto/subtle"
-	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
-	"path/filepath"
+	"net/url"
+	"strconv"
 	"strings"
-	"time"
 )
 
-// router handles all incoming requests and forwards them to the correct
-// location.
-func (a *app) router(w http.ResponseWriter, r *http.Request) {
-	// Set content type to text/plain for all responses.
-	w.Header().Set("Content-Type", "text/plain")
+var (
+	// errDumpNotFound is returned when a dump doesn't exist or has been
+	// deleted.
+	errDumpNotFound = errors.New("dump not found")
 
-	// Route the request to the correct handler.
-	if r.Method == http.MethodGet && r.URL.Path == "/" {
-		if a.uiTpl == nil || strings.HasPrefix(r.Header.Get("User-Agent"), "curl") {
-			w.Write(a.getManText(r.Host))
-		} else {
-			a.routeUIMain(w, r)
-		}
-	} else if a.uiTpl != nil && r.Method == http.MethodGet && r.URL.Path == "/favicon.ico" {
-		w.Header().Set("Content-Type", "image/x-icon")
-		w.Write([]byte(favicon))
-	} else if a.uiTpl != nil && r.Method == http.MethodGet && r.URL.Path == "/text" {
-		a.routeUIText(w, r)
-	} else if a.uiTpl != nil && r.Method == http.MethodGet && r.URL.Path == "/file" {
-		a.routeUIFile(w, r)
-	} else if a.uiTpl != nil && r.Method == http.MethodGet && r.URL.Path == "/about" {
-		a.routeUIAbout(w, r)
-	} else if r.Method == http.MethodPost && r.URL.Path == "/" {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		a.routePost(w, r)
-	} else if r.Method == http.MethodOptions && r.URL.Path == "/" {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "POST")
-		w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, Content-Length")
-	} else if r.Method == http.MethodPost && r.URL.Path == "/dump" {
-		a.routePostUI(w, r)
+	// errInvalidDeleteToken is returned when the given delete token
+	// doesn't match the delete token of the dump.
+	errInvalidDeleteToken = errors.New("invalid delete token")
+)
+
+// routeIndex returns the manual for curl and clients without the UI, other
+// clients gets the main page of the UI.
+func (a *app) routeIndex(w http.ResponseWriter, r *http.Request) {
+	if a.uiTpl == nil || strings.HasPrefix(r.Header.Get("User-Agent"), "curl") {
+		w.Write(a.getManText(a.publicURL(r)))
 	} else {
-		a.routeGet(w, r)
+		a.routeUIMain(w, r)
 	}
 }
 
+// routeFavicon returns the favicon.
+func (a *app) routeFavicon(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "image/x-icon")
+	w.Write([]byte(favicon))
+}
+
+// routeDecryptJS returns the script of the decrypt page.
+func (a *app) routeDecryptJS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/javascript; charset=utf-8")
+	w.Write([]byte(decryptJS))
+}
+
+// routeOptions handles the CORS preflight request for POST /.
+func (a *app) routeOptions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST")
+	w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, Content-Length")
+}
+
 // notFound sets the status code to not found and writes a "not found" message
 // to the response writer.
 func notFound(w http.ResponseWriter) {
@@ -64,6 +66,28 @@ func internalServerError(w http.ResponseWriter) {
 	w.Write([]byte("internal server error occured, try again later\r\n"))
 }
 
+// forbidden sets the status code to forbidden and writes a "forbidden"
+// message to the response writer.
+func forbidden(w http.ResponseWriter) {
+	w.WriteHeader(http.StatusForbidden)
+	w.Write([]byte("forbidden\r\n"))
+}
+
+// acceptsJSON returns true if the client prefers a JSON response.
+func acceptsJSON(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+// writeJSON sets the status code and writes the value as JSON to the
+// response writer.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("unable to encode json response: %v\n", err)
+	}
+}
+
 // unauthorized sets the status code to unauthorized and writes an
 // "unauthorized" message to the response writer.
 func unauthorized(w http.ResponseWriter) {
@@ -75,188 +99,254 @@ func unauthorized(w http.ResponseWriter) {
 // routeUIMain renders the main page for the UI.
 func (a *app) routeUIMain(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	a.uiTpl.Execute(w, UI{IsMain: true, Host: fmt.Sprintf("%s://%s", a.urlScheme, r.Host)})
+	a.uiTpl.Execute(w, UI{IsMain: true, Host: a.publicURL(r)})
 }
 
 // routeUIText renders the text upload page UI.
 func (a *app) routeUIText(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	a.uiTpl.Execute(w, UI{IsText: true, Host: fmt.Sprintf("%s://%s", a.urlScheme, r.Host)})
+	a.uiTpl.Execute(w, UI{IsText: true, Host: a.publicURL(r), ExpiryOptions: a.expiry.options(), SlugPattern: a.slugs.htmlPattern()})
 }
 
 // routeUIFile renders the file upload page UI.
 func (a *app) routeUIFile(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	a.uiTpl.Execute(w, UI{IsFile: true, Host: fmt.Sprintf("%s://%s", a.urlScheme, r.Host)})
+	a.uiTpl.Execute(w, UI{IsFile: true, Host: a.publicURL(r), ExpiryOptions: a.expiry.options(), SlugPattern: a.slugs.htmlPattern()})
 }
 
 // routeUIAbout renders the about page UI.
 func (a *app) routeUIAbout(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	a.uiTpl.Execute(w, UI{IsAbout: true, Host: fmt.Sprintf("%s://%s", a.urlScheme, r.Host)})
+	a.uiTpl.Execute(w, UI{IsAbout: true, Host: a.publicURL(r)})
+}
+
+// routeUIDecrypt renders the page that decrypts a client encrypted dump in
+// the browser. The key is kept in the URL fragment, so the server never sees
+// it.
+func (a *app) routeUIDecrypt(w http.ResponseWriter, r *http.Request) {
+	publicID := r.URL.Query().Get("id")
+	if !a.ids.isValid(publicID) {
+		a.routeUIErr(w, r, http.StatusNotFound, "Dump not found")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	a.uiTpl.Execute(w, UI{IsDecrypt: true, PublicID: publicID, Host: a.publicURL(r)})
 }
 
 // routeUIErr renders the error page UI.
 func (a *app) routeUIErr(w http.ResponseWriter, r *http.Request, status int, text string) {
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	w.WriteHeader(status)
-	a.uiTpl.Execute(w, UI{IsError: true, ErrorText: text, Host: fmt.Sprintf("%s://%s", a.urlScheme, r.Host)})
+	a.uiTpl.Execute(w, UI{IsError: true, ErrorText: text, Host: a.publicURL(r)})
+}
+
+// uiExpiryError returns the text of the UI error page for an error that was
+// returned when the expiry was resolved.
+func uiExpiryError(err error) string {
+	switch err {
+	case errInvalidExpiresAt:
+		return "Invalid expiresAt timestamp"
+	case errExpiryNotAllowed:
+		return "The requested lifetime is not allowed"
+	case errInvalidExpireAfterIdle:
+		return "Invalid expireAfterIdle duration"
+	}
+
+	return "Invalid deleteAfter duration"
 }
 
 // routePostUI handles POST requests from the HTML UI.
 func (a *app) routePostUI(w http.ResponseWriter, r *http.Request) {
-	log.Printf("dump post request from ui %s\n", r.RemoteAddr)
+	log.Printf("dump post request from ui %s\n", a.clientIP(r))
 
-	// Set the max bytes reader for the request and parse the form.
+	// Set the max bytes reader for the request and read the form, a file
+	// upload is streamed to a temporary blob while the form is read.
 	r.Body = http.MaxBytesReader(w, r.Body, a.maxFileSize)
-
-	// Parse the form, if we don't have a file upload we can check for the
-	// payload to not be too big here.
-	var err error
-	err = r.ParseForm()
+	form, up, err := a.readUIForm(r)
+	if up != nil {
+		defer up.blob.Abort()
+	}
 	if err != nil {
-		if err.Error() == "http: request body too large" {
+		if isRequestTooLarge(err) {
 			log.Printf("dump rejected, payload too big\n")
 			a.routeUIErr(w, r, http.StatusBadRequest, "Dump rejected, request body too large")
 			return
 		}
-		log.Printf("internal server error r.ParseForm, %v\n", err)
+		log.Printf("internal server error when reading form, %v\n", err)
 		a.routeUIErr(w, r, http.StatusInternalServerError, "Internal server error")
 		return
 	}
 
-	// Let's try to determine which kind of data that was dumped to us and
-	// do some basic error checking.
-	var data []byte
-	if t := r.FormValue("text"); t != "" {
-		// If there's a form value for the text key we'll assume that we've
-		// got a plaintext upload and treat it as such.
-		data = []byte(t)
-	} else {
-		// It looks like we've got a file upload, try to parse the
-		// data.
-		file, _, err := r.FormFile("file")
-		if err != nil {
-			if strings.Contains(err.Error(), "http: request body too large") {
-				log.Printf("dump rejected, payload too big\n")
-				a.routeUIErr(w, r, http.StatusBadRequest, "Dump rejected, request body too large")
-				return
-			}
-			log.Printf("internal server error r.FormFile, %v\n", err)
-			a.routeUIErr(w, r, http.StatusInternalServerError, "Internal server error")
-			return
-		}
-		defer file.Close()
-
-		buf := bytes.NewBuffer(nil)
-		if _, err := io.Copy(buf, file); err != nil {
-			log.Printf("internal server error io.Copy, %v\n", err)
+	// If there's a form value for the text key and no file upload we'll
+	// assume that we've got a plaintext upload and treat it as such.
+	if t := form.Get("text"); up == nil && t != "" {
+		if up, err = a.newUpload(strings.NewReader(t), a.recipient); err != nil {
+			log.Printf("internal server error when storing text, %v\n", err)
 			a.routeUIErr(w, r, http.StatusInternalServerError, "Internal server error")
 			return
 		}
-
-		data = buf.Bytes()
+		defer up.blob.Abort()
 	}
 
 	// Don't accept empty uploads.
-	if len(data) == 0 {
+	if up == nil {
 		log.Printf("dump rejected, empty payload\n")
 		a.routeUIErr(w, r, http.StatusBadRequest, "Dump rejected, empty payload")
 		return
 	}
 
-	// Check if the user submitted a deleteAfter value and make sure that
-	// the value is valid.
-	var deleteAfter time.Time
-	if da := r.FormValue("deleteAfter"); da != "" {
-		d, err := time.ParseDuration(da)
-		if err != nil {
-			log.Printf("error when parsing delete after duration: %v\n", err)
-			a.routeUIErr(w, r, http.StatusBadRequest, "Invalid deleteAfter duration")
-			return
-		}
-		deleteAfter = time.Now().Local().Add(d)
+	// The form values are the same as the query parameters of a regular
+	// upload, and the username and password protects the dump.
+	o := &dumpOptions{
+		ipAddress:  a.clientIP(r),
+		encryption: encryptionServer,
+		recipient:  a.recipient,
+		username:   form.Get("username"),
+		password:   form.Get("password"),
 	}
-
-	// If the contentType value is set we'll use that, if not we'll try to
-	// autodetected the content type.
-	var contentType string
-	if c := r.FormValue("contentType"); c != "" {
-		contentType = c
-	} else {
-		contentType = http.DetectContentType([]byte(data))
-	}
-
-	// Generate the IDs.
-	filesystemID := newUUID()
-	publicID := newPublicFileID()
-
-	// If we've values for username and password in the form we'll use
-	// that to protect the dump.
-	var username, password []byte
-	u := r.FormValue("username")
-	p := r.FormValue("password")
-	if u != "" && p != "" {
-		if username, err = a.encrypt(u); err != nil {
-			log.Printf("error when encrypting username, %v\n", err)
-			a.routeUIErr(w, r, http.StatusInternalServerError, "Internal server error")
-			return
-		}
-		if password, err = a.encrypt(p); err != nil {
-			log.Printf("error when encrypting username, %v\n", err)
-			a.routeUIErr(w, r, http.StatusInternalServerError, "Internal server error")
-			return
+	if err = a.parseDumpValues(form, o); err != nil {
+		log.Printf("error when parsing dump options: %v\n", err)
+		switch err {
+		case errInvalidMaxDownloads:
+			a.routeUIErr(w, r, http.StatusBadRequest, "Invalid maxDownloads value")
+		case errInvalidSlug:
+			a.routeUIErr(w, r, http.StatusBadRequest, "Invalid slug, use lower case letters, digits and dashes")
+		default:
+			a.routeUIErr(w, r, http.StatusBadRequest, uiExpiryError(err))
 		}
+		return
 	}
 
-	// Insert the dump into the database.
-	err = a.db.insertDump(&dump{
-		contentType:  contentType,
-		deleteAfter:  deleteAfter,
-		filesystemID: filesystemID,
-		ipAddress:    r.RemoteAddr,
-		password:     &password,
-		publicID:     publicID,
-		username:     &username,
-	})
-	if err != nil {
-		log.Printf("insert dump error: %v\n", err)
-		a.routeUIErr(w, r, http.StatusInternalServerError, "Internal server error")
+	du, deleteToken, err := a.storeDump(up, o)
+	if err == errDuplicateSlug {
+		a.routeUIErr(w, r, http.StatusConflict, "The slug is already taken")
 		return
 	}
-
-	// Store the actual contents of the file in the database.
-	if err = ioutil.WriteFile(filepath.Join(a.dataDir, filesystemID), []byte(data), 0440); err != nil {
-		log.Printf("write file error: %v\n", err)
+	if err != nil {
+		log.Printf("store dump error: %v\n", err)
 		a.routeUIErr(w, r, http.StatusInternalServerError, "Internal server error")
-		a.db.deleteDumpByFilesystemID(filesystemID)
 		return
 	}
 
-	// Redirect the user to the dumped file.
+	// Show the user where the dump can be found and how it can be
+	// deleted.
+	publicID := du.publicID
 	log.Printf("dump stored with public id at %s\n", publicID)
 
-	contentURL := fmt.Sprintf("%s://%s/%s", a.urlScheme, r.Host, publicID)
+	contentURL := a.publicURL(r) + dumpPath(du)
+	ownerQuery := url.Values{"id": {publicID}, "token": {deleteToken}}.Encode()
+	w.Header().Set("X-Delete-Token", deleteToken)
 	// Just print the URL for zip files.
-	if contentType == "application/zip" {
+	if du.contentType == "application/zip" {
 		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 		w.Write([]byte(contentURL))
 	} else {
-		http.Redirect(w, r, fmt.Sprintf("%s://%s/%s", a.urlScheme, r.Host, publicID), 301)
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		a.uiTpl.Execute(w, UI{
+			IsCreated: true,
+			DumpURL:   contentURL,
+			DeleteURL: a.publicURL(r) + "/delete?" + ownerQuery,
+			UpdateURL: a.publicURL(r) + "/update?" + ownerQuery,
+			Host:      a.publicURL(r),
+		})
+	}
+}
+
+// readUIForm reads the form values of a HTML UI request. The contents of a
+// file upload is streamed to a temporary blob instead of being kept in
+// memory, it is up to the caller to commit or abort the blob.
+func (a *app) readUIForm(r *http.Request) (url.Values, *upload, error) {
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		if err := r.ParseForm(); err != nil {
+			return nil, nil, err
+		}
+		return r.Form, nil, nil
+	}
+
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	form := r.URL.Query()
+	var up *upload
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, up, err
+		}
+
+		// Only the first non-empty file is stored, all other parts are
+		// treated as regular form values.
+		if part.FormName() == "file" && part.FileName() != "" {
+			if up != nil {
+				continue
+			}
+			if up, err = a.newUpload(part, a.recipient); err != nil && err != errEmptyUpload {
+				return nil, nil, err
+			}
+			continue
+		}
+
+		v, err := ioutil.ReadAll(part)
+		if err != nil {
+			return nil, up, err
+		}
+		form.Add(part.FormName(), string(v))
 	}
+
+	return form, up, nil
+}
+
+// parseMaxDownloads returns the max number of downloads from the
+// maxDownloads and burnAfterRead values, burnAfterRead is a shorthand for a
+// single download. Nil is returned if the number of downloads is unlimited.
+func parseMaxDownloads(v url.Values) (*int, error) {
+	if b, ok := v["burnAfterRead"]; ok && b[0] != "0" && b[0] != "false" {
+		n := 1
+		return &n, nil
+	}
+
+	md := v.Get("maxDownloads")
+	if md == "" {
+		return nil, nil
+	}
+
+	n, err := strconv.Atoi(md)
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("invalid max downloads %q", md)
+	}
+
+	return &n, nil
 }
 
 // routePost handles the v1 dump POST request.
 func (a *app) routePost(w http.ResponseWriter, r *http.Request) {
-	log.Printf("dump post request from %s\n", r.RemoteAddr)
+	log.Printf("dump post request from %s\n", a.clientIP(r))
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Expose-Headers", "X-Delete-Token")
 
-	// Add a file size limit and read the contents and do some error
-	// checking.
+	// Make sure that the query parameters are valid before we start to
+	// read the body.
+	o, err := a.parseDumpOptions(r)
+	if err != nil {
+		log.Printf("error when parsing dump options: %v\n", err)
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "error: %v\r\n", err)
+		return
+	}
+
+	// Add a file size limit and stream the contents to a temporary blob
+	// and do some error checking.
 	r.Body = http.MaxBytesReader(w, r.Body, a.maxFileSize)
-	data, err := ioutil.ReadAll(r.Body)
+	up, err := a.newUpload(r.Body, o.recipient)
 
 	// When we get an empty body we'll return an error and return.
-	if len(data) == 0 {
+	if err == errEmptyUpload {
 		log.Printf("dump rejected, empty payload\n")
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte("empty request payload\r\n"))
@@ -264,7 +354,7 @@ func (a *app) routePost(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err != nil {
-		if int64(len(data)) >= a.maxFileSize {
+		if isRequestTooLarge(err) {
 			log.Printf("dump rejected, payload too big\n")
 			w.WriteHeader(http.StatusBadRequest)
 			w.Write([]byte("dump rejected, request body too large\r\n"))
@@ -274,210 +364,118 @@ func (a *app) routePost(w http.ResponseWriter, r *http.Request) {
 		internalServerError(w)
 		return
 	}
+	defer up.blob.Abort()
 
-	// Check if the user submitted a deleteAfter query parameter and that
-	// it was of a valid format.
-	var deleteAfter time.Time
-	if da, ok := r.URL.Query()["deleteAfter"]; ok {
-		d, err := time.ParseDuration(da[0])
-		if err != nil {
-			log.Printf("error when parsing delete after duration: %v\n", err)
-			w.WriteHeader(http.StatusBadRequest)
-			fmt.Fprintf(w, "error: invalid deleteAfter duration\r\n")
-			return
-		}
-		deleteAfter = time.Now().Local().Add(d)
-	}
-
-	// If the contentType query parameter is set we'll use that, if not
-	// we'll try to autodetected the content type.
-	var contentType string
-	if c, ok := r.URL.Query()["contentType"]; ok {
-		contentType = c[0]
-	} else {
-		contentType = http.DetectContentType(data)
-	}
-
-	// Generate the IDs.
-	filesystemID := newUUID()
-	publicID := newPublicFileID()
-
-	// If the request contains basic auth credentials we'll use them to
-	// protect the uploaded file.
-	var username, password []byte
-	if u, p, ok := r.BasicAuth(); ok {
-		if username, err = a.encrypt(u); err != nil {
-			log.Printf("error when encrypting username, %v\n", err)
-			internalServerError(w)
-			return
-		}
-		if password, err = a.encrypt(p); err != nil {
-			log.Printf("error when encrypting username, %v\n", err)
-			internalServerError(w)
-			return
-		}
+	du, deleteToken, err := a.storeDump(up, o)
+	if err == errDuplicateSlug {
+		w.WriteHeader(http.StatusConflict)
+		fmt.Fprintf(w, "error: %v\r\n", err)
+		return
 	}
-
-	// Insert the dump into the database.
-	err = a.db.insertDump(&dump{
-		contentType:  contentType,
-		deleteAfter:  deleteAfter,
-		filesystemID: filesystemID,
-		ipAddress:    r.RemoteAddr,
-		password:     &password,
-		publicID:     publicID,
-		username:     &username,
-	})
 	if err != nil {
-		log.Printf("insert dump error: %v\n", err)
+		log.Printf("store dump error: %v\n", err)
 		internalServerError(w)
 		return
 	}
 
-	// Store the actual contents of the file in the database.
-	if err = ioutil.WriteFile(filepath.Join(a.dataDir, filesystemID), data, 0440); err != nil {
-		log.Printf("write file error: %v\n", err)
-		internalServerError(w)
-		a.db.deleteDumpByFilesystemID(filesystemID)
+	// Set http status code to 201 and return the URL to the stored file,
+	// the delete token is returned in a header or in the JSON response.
+	log.Printf("dump stored with public id at %s\n", du.publicID)
+	contentURL := a.publicURL(r) + dumpPath(du)
+	w.Header().Set("X-Delete-Token", deleteToken)
+	if acceptsJSON(r) {
+		writeJSON(w, http.StatusCreated, map[string]string{
+			"id":          du.publicID,
+			"url":         contentURL,
+			"deleteToken": deleteToken,
+		})
 		return
 	}
-
-	// Set http status code to 201 and return the URL to the stored file.
-	log.Printf("dump stored with public id at %s\n", publicID)
 	w.WriteHeader(http.StatusCreated)
-	fmt.Fprintf(w, "%s://%s/%s\r\n", a.urlScheme, r.Host, publicID)
+	fmt.Fprintf(w, "%s\r\n", contentURL)
 }
 
 // routeGet handles the v1 dump GET request.
 func (a *app) routeGet(w http.ResponseWriter, r *http.Request) {
-	log.Printf("dump get request from %s, to %s\n", r.RemoteAddr, r.URL.Path)
+	log.Printf("dump get request from %s, to %s\n", a.clientIP(r), r.URL.Path)
 
 	// Discard the / in the beginning of the path.
-	publicID := r.URL.Path[1:]
-	if len(publicID) != 11 || !isValidPublicFileID(publicID) {
+	dump, err := a.getDumpForRequest(r, r.URL.Path[1:])
+	if err == errDumpNotFound {
 		notFound(w)
 		return
 	}
-
-	// Query the database for information about the requested file.
-	dump, err := a.db.getDumpByPublicID(publicID)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			notFound(w)
-			return
-		}
-
-		log.Printf("getting file from database error: %v\n", err)
-		internalServerError(w)
-		return
-	}
-
-	// The file has been deleted, which means not found is an approperiate
-	// error.
-	if dump.deletedAt != nil {
-		notFound(w)
-		return
-	}
-
-	// If we've got a bau and bap from the database we should treat the
-	// file as protected and apply basic auth.
-	isProtected := false
-	var username []byte
-	var password []byte
-	if len(*dump.username) > 0 && len(*dump.password) > 0 {
-		isProtected = true
-
-		if username, err = a.decrypt(*dump.username); err != nil {
-			log.Printf("error when decrypting username, %v\n", err)
-			internalServerError(w)
-			return
-		}
-		if password, err = a.decrypt(*dump.password); err != nil {
-			log.Printf("error when decrypting username, %v\n", err)
-			internalServerError(w)
-			return
-		}
-	}
-
-	// Fetch basic auth credentials from the request. If we've got a
-	// protected resource but no basic auth credentials were found we'll
-	// return a 401.
-	u, p, isBasicAuth := r.BasicAuth()
-	if isProtected && !isBasicAuth {
+	if err == errUnauthorized {
 		unauthorized(w)
 		return
 	}
-
-	// The resource is protected and we've got credentials that we can
-	// compare, if the credentials doesn't match we'll return a 401.
-	if isProtected && isBasicAuth && (subtle.ConstantTimeCompare([]byte(u), username) != 1 ||
-		subtle.ConstantTimeCompare([]byte(p), password) != 1) {
-		unauthorized(w)
+	if err != nil {
+		log.Printf("%v\n", err)
+		internalServerError(w)
 		return
 	}
 
 	// If there's a query parameter named "info" we'll return stats about
-	// the dump instead of the actual dump.
+	// the dump instead of the actual dump. JSON is returned if the client
+	// accepts it, the Content-Type check is kept for older clients. The
+	// JSON is kept exactly as it has always been for the clients that
+	// parse it, the typed representation is returned by the API.
 	if _, ok := r.URL.Query()["info"]; ok {
-		dumpInfo, err := a.db.getDumpInfoByPublicID(publicID)
+		dumpInfo, err := a.db.getDumpInfoByPublicID(dump.publicID)
 		if err != nil {
 			log.Printf("unable to get info for %s\n", dump.filesystemID)
 			notFound(w)
 			return
 		}
 
-		if r.Header.Get("Content-Type") == "application/json" {
+		// Dumps without an expiry are reported as never expiring.
+		expires := "never"
+		if dump.deleteAfter != nil {
+			expires = dump.deleteAfter.UTC().String()
+		}
+
+		if acceptsJSON(r) || r.Header.Get("Content-Type") == "application/json" {
 			w.Header().Set("Content-Type", "application/json")
 			w.Write([]byte(
 				fmt.Sprintf("{\"id\": \"%s\", \"createdAt\": \"%s\", \"count\": \"%d\"}\r\n",
-					publicID,
+					dump.publicID,
 					dumpInfo.createdAt.UTC(),
 					dumpInfo.count,
 				),
 			))
 		} else {
+			// New fields are appended after the count, so that
+			// scripts that reads the original lines keeps working.
 			w.Header().Set("Content-Type", "text/plain")
 			w.Write([]byte(
-				fmt.Sprintf("id:\t\t%s\ntimestamp:\t%s\ncount:\t\t%d\r\n",
-					publicID,
+				fmt.Sprintf("id:\t\t%s\ntimestamp:\t%s\ncount:\t\t%d\nexpires:\t%s\nencrypted:\t%s\r\n",
+					dump.publicID,
 					dumpInfo.createdAt.UTC(),
 					dumpInfo.count,
+					expires,
+					encryptionName(dump),
 				),
 			))
 		}
 		return
 	}
 
-	// Read the file from the filesystem.
-	data, err := ioutil.ReadFile(filepath.Join(a.dataDir, dump.filesystemID))
-	if err != nil {
-		log.Printf("unable to find %s in %s\n", dump.filesystemID, a.dataDir)
-		notFound(w)
+	// Browsers are sent to the decrypt page when the dump is client
+	// encrypted, the URL fragment with the key is kept by the browser when
+	// it follows the redirect.
+	if a.uiTpl != nil && dump.clientEncrypted && r.Method == http.MethodGet &&
+		strings.Contains(r.Header.Get("Accept"), "text/html") && r.URL.Query().Get("saveAs") == "" {
+		http.Redirect(w, r, a.pathPrefix()+"/decrypt?id="+url.QueryEscape(dump.publicID), http.StatusFound)
 		return
 	}
 
-	// Insert an entry to the access log.
-	if err = a.db.insertDumpAccessLog(&dumpAccessLog{
-		dumpID:    dump.id,
-		ipAddress: r.RemoteAddr,
-	}); err != nil {
-		log.Printf("unable to insert access log: %v\n", err)
-	}
-
-	// Serve the requested file.
-	w.Header().Set("Content-Type", dump.contentType)
-	w.Header().Set("Content-Length", fmt.Sprintf("%d", len(data)))
-
-	var saveAs string
-	if s, ok := r.URL.Query()["saveAs"]; ok {
-		saveAs = s[0]
+	err = a.serveDump(w, r, dump)
+	if err == errDumpNotFound {
+		notFound(w)
+		return
 	}
-	if saveAs == "" {
-		w.Header().Set("Content-Disposition", "inline")
-	} else {
-		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, saveAs))
+	if err != nil {
+		log.Printf("%v\n", err)
+		internalServerError(w)
 	}
-
-	w.WriteHeader(http.StatusOK)
-	w.Write(data)
 }
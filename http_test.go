@@ -0,0 +1,1234 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"filippo.io/age"
+)
+
+// newTestApp returns an app that uses an in-memory repository and a blob
+// store in a temporary directory.
+func newTestApp(t *testing.T, ui bool) (*app, *memRepository) {
+	dir, err := ioutil.TempDir("", "dumpinen-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	store, err := newFSStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo := newMemRepository()
+	a, err := newApp(repo, store, "0", identity.Recipient().String(), identity.String(), 1024, ui)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.urlScheme = "http"
+
+	return a, repo
+}
+
+// request sends a request to the router, the headers are given as name and
+// value pairs.
+func request(a *app, method, target string, body io.Reader, headers ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, body)
+	for i := 0; i+1 < len(headers); i += 2 {
+		r.Header.Set(headers[i], headers[i+1])
+	}
+
+	w := httptest.NewRecorder()
+	a.router(w, r)
+	return w
+}
+
+// basicAuth returns the value of a basic auth Authorization header.
+func basicAuth(username, password string) string {
+	r, _ := http.NewRequest(http.MethodGet, "/", nil)
+	r.SetBasicAuth(username, password)
+	return r.Header.Get("Authorization")
+}
+
+// uploadDump creates a dump and returns the path of it together with the delete
+// token.
+func uploadDump(t *testing.T, a *app, target, body string, headers ...string) (string, string) {
+	t.Helper()
+
+	w := request(a, http.MethodPost, target, strings.NewReader(body), headers...)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("uploadDump: got status %d: %s", w.Code, w.Body)
+	}
+
+	u := strings.TrimSpace(w.Body.String())
+	if !strings.HasPrefix(u, "http://example.com/") {
+		t.Fatalf("uploadDump: got url %q", u)
+	}
+
+	return strings.TrimPrefix(u, "http://example.com"), w.Header().Get("X-Delete-Token")
+}
+
+func TestUploadAndDownload(t *testing.T) {
+	a, _ := newTestApp(t, false)
+
+	path, token := uploadDump(t, a, "/", "foo\n")
+	if len(path) != 12 {
+		t.Errorf("got path %q", path)
+	}
+	if token == "" {
+		t.Errorf("no delete token")
+	}
+
+	w := request(a, http.MethodGet, path, nil)
+	if w.Code != http.StatusOK || w.Body.String() != "foo\n" {
+		t.Fatalf("got %d %q", w.Code, w.Body)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "text/plain; charset=utf-8" {
+		t.Errorf("got content type %q", ct)
+	}
+	if cd := w.Header().Get("Content-Disposition"); cd != "inline" {
+		t.Errorf("got content disposition %q", cd)
+	}
+	if w.Header().Get("ETag") == "" {
+		t.Errorf("no etag")
+	}
+
+	w = request(a, http.MethodHead, path, nil)
+	if w.Code != http.StatusOK || w.Body.Len() != 0 || w.Header().Get("Content-Length") != "4" {
+		t.Errorf("HEAD: got %d %q %v", w.Code, w.Body, w.Header())
+	}
+
+	w = request(a, http.MethodGet, path, nil, "Range", "bytes=1-2")
+	if w.Code != http.StatusPartialContent || w.Body.String() != "oo" {
+		t.Errorf("range: got %d %q", w.Code, w.Body)
+	}
+
+	w = request(a, http.MethodGet, path, nil, "If-None-Match", w.Header().Get("ETag"))
+	if w.Code != http.StatusNotModified {
+		t.Errorf("conditional: got %d", w.Code)
+	}
+}
+
+func TestUploadJSON(t *testing.T) {
+	a, _ := newTestApp(t, false)
+
+	w := request(a, http.MethodPost, "/", strings.NewReader("foo"), "Accept", "application/json")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("got %d", w.Code)
+	}
+
+	var res map[string]string
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if res["url"] != "http://example.com/"+res["id"] || res["deleteToken"] != w.Header().Get("X-Delete-Token") {
+		t.Errorf("got %v", res)
+	}
+}
+
+func TestUploadContentType(t *testing.T) {
+	a, _ := newTestApp(t, false)
+
+	path, _ := uploadDump(t, a, "/?contentType=application/json", `{"foo":1}`)
+	w := request(a, http.MethodGet, path, nil)
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("got content type %q", ct)
+	}
+}
+
+func TestUploadErrors(t *testing.T) {
+	a, _ := newTestApp(t, false)
+
+	tests := []struct {
+		name   string
+		target string
+		body   string
+	}{
+		{"empty", "/", ""},
+		{"too large", "/", strings.Repeat("a", 2048)},
+		{"invalid deleteAfter", "/?deleteAfter=foo", "foo"},
+		{"negative deleteAfter", "/?deleteAfter=-1h", "foo"},
+		{"past expiresAt", "/?expiresAt=2001-01-01T00:00:00Z", "foo"},
+		{"invalid maxDownloads", "/?maxDownloads=0", "foo"},
+	}
+
+	for _, tt := range tests {
+		w := request(a, http.MethodPost, tt.target, strings.NewReader(tt.body))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: got status %d", tt.name, w.Code)
+		}
+	}
+}
+
+func TestBasicAuth(t *testing.T) {
+	a, _ := newTestApp(t, false)
+
+	path, _ := uploadDump(t, a, "/", "secret", "Authorization", basicAuth("foo", "bar"))
+
+	w := request(a, http.MethodGet, path, nil)
+	if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") == "" {
+		t.Errorf("no credentials: got %d", w.Code)
+	}
+
+	for _, auth := range [][]string{{"foo", "baz"}, {"baz", "bar"}, {"", ""}} {
+		w = request(a, http.MethodGet, path, nil, "Authorization", basicAuth(auth[0], auth[1]))
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%v: got %d", auth, w.Code)
+		}
+	}
+
+	w = request(a, http.MethodGet, path, nil, "Authorization", basicAuth("foo", "bar"))
+	if w.Code != http.StatusOK || w.Body.String() != "secret" {
+		t.Errorf("valid credentials: got %d %q", w.Code, w.Body)
+	}
+
+	w = request(a, http.MethodGet, path+"?info", nil)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("info without credentials: got %d", w.Code)
+	}
+}
+
+func TestPasswordHash(t *testing.T) {
+	a, repo := newTestApp(t, false)
+
+	// New dumps only stores a hash of the credentials.
+	path, _ := uploadDump(t, a, "/", "secret", "Authorization", basicAuth("foo", "bar"))
+	du, _ := repo.getDumpByPublicID(path[1:])
+	if du.passwordHash == nil || du.username != nil || du.password != nil {
+		t.Errorf("got %v %v %v", du.passwordHash, du.username, du.password)
+	}
+
+	// Dumps with encrypted credentials are still accessible, and the
+	// credentials are replaced with a hash on the first successful access.
+	username, _ := a.encrypt("foo")
+	password, _ := a.encrypt("bar")
+	legacy, _ := uploadDump(t, a, "/", "legacy")
+	du, _ = repo.getDumpByPublicID(legacy[1:])
+	du.username = &username
+	du.password = &password
+	repo.updateDumpMetadata(du)
+
+	if w := request(a, http.MethodGet, legacy, nil, "Authorization", basicAuth("foo", "baz")); w.Code != http.StatusUnauthorized {
+		t.Errorf("wrong password: got %d", w.Code)
+	}
+	if du, _ = repo.getDumpByPublicID(legacy[1:]); du.passwordHash != nil {
+		t.Errorf("hash was stored after a failed access")
+	}
+
+	if w := request(a, http.MethodGet, legacy, nil, "Authorization", basicAuth("foo", "bar")); w.Code != http.StatusOK {
+		t.Errorf("valid credentials: got %d", w.Code)
+	}
+	du, _ = repo.getDumpByPublicID(legacy[1:])
+	if du.passwordHash == nil || du.username != nil || du.password != nil {
+		t.Fatalf("credentials were not rehashed: %v %v %v", du.passwordHash, du.username, du.password)
+	}
+	if valid, _ := verifyPassword(*du.passwordHash, "foo", "bar"); !valid {
+		t.Errorf("invalid hash %s", *du.passwordHash)
+	}
+
+	for _, auth := range [][]string{{"foo", "baz"}, {"baz", "bar"}} {
+		if w := request(a, http.MethodGet, legacy, nil, "Authorization", basicAuth(auth[0], auth[1])); w.Code != http.StatusUnauthorized {
+			t.Errorf("%v after rehash: got %d", auth, w.Code)
+		}
+	}
+	if w := request(a, http.MethodGet, legacy, nil, "Authorization", basicAuth("foo", "bar")); w.Code != http.StatusOK {
+		t.Errorf("valid credentials after rehash: got %d", w.Code)
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	a, repo := newTestApp(t, false)
+
+	expiring, _ := uploadDump(t, a, "/?deleteAfter=1ms", "foo")
+	permanent, _ := uploadDump(t, a, "/", "bar")
+	later, _ := uploadDump(t, a, "/?deleteAfter=1h", "baz")
+
+	time.Sleep(10 * time.Millisecond)
+	a.deleteExpiredDumps()
+
+	if w := request(a, http.MethodGet, expiring, nil); w.Code != http.StatusNotFound {
+		t.Errorf("expired dump: got %d", w.Code)
+	}
+	for _, path := range []string{permanent, later} {
+		if w := request(a, http.MethodGet, path, nil); w.Code != http.StatusOK {
+			t.Errorf("%s: got %d", path, w.Code)
+		}
+	}
+
+	// The file of the expired dump is removed from the blob store.
+	du, _ := repo.getDumpByPublicID(expiring[1:])
+	if _, err := a.store.Stat(du.filesystemID); err != errBlobNotFound {
+		t.Errorf("expired file: got %v", err)
+	}
+}
+
+func TestExpiryPolicy(t *testing.T) {
+	a, repo := newTestApp(t, true)
+	a.expiry = &expiryPolicy{defaultExpiry: 24 * time.Hour, maxExpiry: 90 * 24 * time.Hour}
+
+	for _, target := range []string{"/?deleteAfter=never", "/?deleteAfter=91d", "/api/v1/dumps?deleteAfter=never"} {
+		if w := request(a, http.MethodPost, target, strings.NewReader("foo")); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: got %d", target, w.Code)
+		}
+	}
+
+	path, _ := uploadDump(t, a, "/", "foo")
+	du, _ := repo.getDumpByPublicID(path[1:])
+	if du.deleteAfter == nil || du.deleteAfter.Sub(time.Now()) > 24*time.Hour || du.deleteAfter.Sub(time.Now()) < 23*time.Hour {
+		t.Errorf("default expiry: got %v", du.deleteAfter)
+	}
+
+	path, token := uploadDump(t, a, "/?deleteAfter=2w", "foo")
+	if du, _ = repo.getDumpByPublicID(path[1:]); du.deleteAfter.Sub(time.Now()) < 13*24*time.Hour {
+		t.Errorf("2w: got %v", du.deleteAfter)
+	}
+
+	// The expiry can't be removed or extended past the max expiry.
+	for _, target := range []string{path + "?deleteAfter=", path + "?deleteAfter=never", path + "?deleteAfter=13w"} {
+		if w := request(a, http.MethodPatch, target, nil, "X-Delete-Token", token); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: got %d", target, w.Code)
+		}
+	}
+	if w := request(a, http.MethodPatch, path+"?expiresAt="+time.Now().Add(time.Hour).Format(time.RFC3339), nil, "X-Delete-Token", token); w.Code != http.StatusOK {
+		t.Errorf("patch expiresAt: got %d", w.Code)
+	}
+
+	body := strings.NewReader("text=foo&deleteAfter=never")
+	w := request(a, http.MethodPost, "/dump", body, "Content-Type", "application/x-www-form-urlencoded")
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("ui: got %d", w.Code)
+	}
+
+	w = request(a, http.MethodGet, "/text", nil)
+	if strings.Contains(w.Body.String(), `value="never"`) || !strings.Contains(w.Body.String(), `<option value="24h" selected>`) {
+		t.Errorf("ui options: got %s", w.Body)
+	}
+}
+
+func TestIdleExpiry(t *testing.T) {
+	a, repo := newTestApp(t, false)
+
+	idle, _ := uploadDump(t, a, "/?expireAfterIdle=1h", "foo")
+	used, _ := uploadDump(t, a, "/?expireAfterIdle=1h", "bar")
+	permanent, _ := uploadDump(t, a, "/", "baz")
+
+	if w := request(a, http.MethodPost, "/?expireAfterIdle=foo", strings.NewReader("foo")); w.Code != http.StatusBadRequest {
+		t.Errorf("invalid expireAfterIdle: got %d", w.Code)
+	}
+
+	// Pretend that all dumps were uploaded two hours ago and that one of
+	// them was downloaded recently.
+	repo.mu.Lock()
+	for _, du := range repo.dumps {
+		du.insertedAt = du.insertedAt.Add(-2 * time.Hour)
+	}
+	repo.mu.Unlock()
+	request(a, http.MethodGet, used, nil)
+
+	a.deleteIdleDumps()
+	if w := request(a, http.MethodGet, idle, nil); w.Code != http.StatusNotFound {
+		t.Errorf("idle dump: got %d", w.Code)
+	}
+	for _, path := range []string{used, permanent} {
+		if w := request(a, http.MethodGet, path, nil); w.Code != http.StatusOK {
+			t.Errorf("%s: got %d", path, w.Code)
+		}
+	}
+
+	// The server idle expiry applies to all dumps.
+	a.idleExpiry = time.Hour
+	a.deleteIdleDumps()
+	if w := request(a, http.MethodGet, permanent, nil); w.Code != http.StatusOK {
+		t.Errorf("recently downloaded dump: got %d", w.Code)
+	}
+	a.idleExpiry = time.Nanosecond
+	a.deleteIdleDumps()
+	for _, path := range []string{used, permanent} {
+		if w := request(a, http.MethodGet, path, nil); w.Code != http.StatusNotFound {
+			t.Errorf("%s: got %d", path, w.Code)
+		}
+	}
+}
+
+func TestInfo(t *testing.T) {
+	a, repo := newTestApp(t, false)
+
+	path, _ := uploadDump(t, a, "/", "foo")
+	request(a, http.MethodGet, path, nil)
+	request(a, http.MethodHead, path, nil)
+
+	// The original lines comes first and the new ones are appended.
+	du, _ := repo.getDumpByPublicID(path[1:])
+	w := request(a, http.MethodGet, path+"?info", nil)
+	text := fmt.Sprintf("id:\t\t%s\ntimestamp:\t%s\ncount:\t\t1\nexpires:\tnever\nencrypted:\tserver\r\n", path[1:], du.insertedAt.UTC())
+	if w.Code != http.StatusOK || w.Body.String() != text {
+		t.Errorf("text: got %d %q, want %q", w.Code, w.Body, text)
+	}
+
+	// The legacy JSON is kept exactly as it has always been, with the
+	// count as a string.
+	want := fmt.Sprintf("{\"id\": \"%s\", \"createdAt\": \"%s\", \"count\": \"1\"}\r\n", path[1:], du.insertedAt.UTC())
+	for _, header := range []string{"Accept", "Content-Type"} {
+		w = request(a, http.MethodGet, path+"?info", nil, header, "application/json")
+		if w.Code != http.StatusOK || w.Body.String() != want || w.Header().Get("Content-Type") != "application/json" {
+			t.Errorf("%s: got %d %q, want %q", header, w.Code, w.Body, want)
+		}
+		var info map[string]string
+		if err := json.Unmarshal(w.Body.Bytes(), &info); err != nil {
+			t.Errorf("%s: %v: %s", header, err, w.Body)
+		}
+	}
+}
+
+func TestSaveAs(t *testing.T) {
+	a, _ := newTestApp(t, false)
+
+	path, _ := uploadDump(t, a, "/", "foo")
+	w := request(a, http.MethodGet, path+"?saveAs=foo.txt", nil)
+	if cd := w.Header().Get("Content-Disposition"); cd != `attachment; filename="foo.txt"` {
+		t.Errorf("got content disposition %q", cd)
+	}
+}
+
+func TestNotFound(t *testing.T) {
+	a, _ := newTestApp(t, false)
+
+	for _, path := range []string{"/foo", "/aaaaaaaaaaa", "/aaaaaaaaa!a", "/foo/bar"} {
+		if w := request(a, http.MethodGet, path, nil); w.Code != http.StatusNotFound {
+			t.Errorf("%s: got %d", path, w.Code)
+		}
+	}
+}
+
+// TestUIFormWithoutUI makes sure that the form of the UI can't be posted to a
+// server without the UI, it would have no template to render the response
+// with. The path is then only a dump path, which can't be posted to.
+func TestUIFormWithoutUI(t *testing.T) {
+	a, _ := newTestApp(t, false)
+
+	for _, body := range []string{"text=foo", "text=foo&deleteAfter=foo"} {
+		w := request(a, http.MethodPost, "/dump", strings.NewReader(body), "Content-Type", "application/x-www-form-urlencoded")
+		if w.Code != http.StatusMethodNotAllowed {
+			t.Errorf("%s: got %d", body, w.Code)
+		}
+	}
+}
+
+func TestMaxDownloads(t *testing.T) {
+	a, _ := newTestApp(t, false)
+
+	path, _ := uploadDump(t, a, "/?maxDownloads=2", "foo")
+	for i := 0; i < 2; i++ {
+		if w := request(a, http.MethodGet, path, nil, "Range", "bytes=0-0"); w.Code != http.StatusOK || w.Body.String() != "foo" {
+			t.Errorf("download %d: got %d %q", i, w.Code, w.Body)
+		}
+	}
+	if w := request(a, http.MethodGet, path, nil); w.Code != http.StatusNotFound {
+		t.Errorf("after last download: got %d", w.Code)
+	}
+
+	path, _ = uploadDump(t, a, "/?burnAfterRead=1", "foo")
+	request(a, http.MethodHead, path, nil)
+	request(a, http.MethodGet, path, nil)
+	if w := request(a, http.MethodGet, path, nil); w.Code != http.StatusNotFound {
+		t.Errorf("burn after read: got %d", w.Code)
+	}
+
+	// A conditional request counts as a download, so it must get the
+	// contents instead of a not modified response.
+	path, _ = uploadDump(t, a, "/?maxDownloads=2", "foo")
+	w := request(a, http.MethodGet, path, nil)
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || etag == "" {
+		t.Fatalf("first download: got %d %v", w.Code, w.Header())
+	}
+	conditional := []string{"If-None-Match", etag, "If-Modified-Since", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)}
+	if w = request(a, http.MethodGet, path, nil, conditional...); w.Code != http.StatusOK || w.Body.String() != "foo" {
+		t.Errorf("conditional download: got %d %q", w.Code, w.Body)
+	}
+	if w = request(a, http.MethodGet, path, nil); w.Code != http.StatusNotFound {
+		t.Errorf("after conditional download: got %d", w.Code)
+	}
+
+	path, _ = uploadDump(t, a, "/?burnAfterRead=1", "foo")
+	if w = request(a, http.MethodGet, path, nil, "If-Match", `"other"`); w.Code != http.StatusOK || w.Body.String() != "foo" {
+		t.Errorf("burn after read with If-Match: got %d %q", w.Code, w.Body)
+	}
+}
+
+func TestDeleteAndUpdate(t *testing.T) {
+	a, _ := newTestApp(t, false)
+
+	path, token := uploadDump(t, a, "/", "foo")
+
+	if w := request(a, http.MethodPatch, path+"?contentType=text/csv", nil, "X-Delete-Token", "wrong"); w.Code != http.StatusForbidden {
+		t.Errorf("patch with wrong token: got %d", w.Code)
+	}
+	if w := request(a, http.MethodPatch, path+"?deleteAfter=foo", nil, "X-Delete-Token", token); w.Code != http.StatusBadRequest {
+		t.Errorf("patch with invalid deleteAfter: got %d", w.Code)
+	}
+
+	body := strings.NewReader("contentType=text/csv&username=foo&password=bar")
+	w := request(a, http.MethodPatch, path, body, "X-Delete-Token", token, "Content-Type", "application/x-www-form-urlencoded")
+	if w.Code != http.StatusOK {
+		t.Fatalf("patch: got %d %q", w.Code, w.Body)
+	}
+	if w = request(a, http.MethodGet, path, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("protected after patch: got %d", w.Code)
+	}
+	w = request(a, http.MethodGet, path, nil, "Authorization", basicAuth("foo", "bar"))
+	if ct := w.Header().Get("Content-Type"); w.Code != http.StatusOK || ct != "text/csv" {
+		t.Errorf("after patch: got %d %q", w.Code, ct)
+	}
+
+	if w = request(a, http.MethodDelete, path, nil, "X-Delete-Token", "wrong"); w.Code != http.StatusForbidden {
+		t.Errorf("delete with wrong token: got %d", w.Code)
+	}
+	if w = request(a, http.MethodDelete, path+"?token="+token, nil); w.Code != http.StatusOK {
+		t.Errorf("delete: got %d", w.Code)
+	}
+	if w = request(a, http.MethodGet, path, nil, "Authorization", basicAuth("foo", "bar")); w.Code != http.StatusNotFound {
+		t.Errorf("after delete: got %d", w.Code)
+	}
+}
+
+func TestAPI(t *testing.T) {
+	a, _ := newTestApp(t, false)
+	ownerKey := "0123456789abcdef"
+
+	w := request(a, http.MethodPost, "/api/v1/dumps?maxDownloads=5", strings.NewReader("foo"), "X-Owner-Key", ownerKey)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create: got %d %q", w.Code, w.Body)
+	}
+	var created dumpResource
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+	if created.DeleteToken == "" || *created.RemainingDownloads != 5 || *created.Size != 3 {
+		t.Errorf("create: got %+v", created)
+	}
+	if loc := w.Header().Get("Location"); loc != "/api/v1/dumps/"+created.ID {
+		t.Errorf("create: got location %q", loc)
+	}
+
+	w = request(a, http.MethodGet, "/api/v1/dumps/"+created.ID+"/content", nil)
+	if w.Code != http.StatusOK || w.Body.String() != "foo" {
+		t.Errorf("content: got %d %q", w.Code, w.Body)
+	}
+
+	w = request(a, http.MethodGet, "/api/v1/dumps/"+created.ID, nil)
+	var got dumpResource
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != created.ID || got.Count == nil || *got.Count != 1 || got.DeleteToken != "" {
+		t.Errorf("get: got %+v", got)
+	}
+
+	w = request(a, http.MethodGet, "/api/v1/dumps", nil, "X-Owner-Key", ownerKey)
+	var list struct {
+		Dumps []dumpResource `json:"dumps"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Dumps) != 1 || list.Dumps[0].ID != created.ID {
+		t.Errorf("list: got %+v", list)
+	}
+
+	w = request(a, http.MethodDelete, "/api/v1/dumps/"+created.ID, nil, "X-Delete-Token", created.DeleteToken)
+	if w.Code != http.StatusNoContent {
+		t.Errorf("delete: got %d", w.Code)
+	}
+
+	errors := []struct {
+		method  string
+		target  string
+		headers []string
+		status  int
+		code    string
+	}{
+		{http.MethodGet, "/api/v1/dumps/" + created.ID, nil, http.StatusNotFound, "not_found"},
+		{http.MethodGet, "/api/v1/dumps", nil, http.StatusBadRequest, "owner_key_required"},
+		{http.MethodGet, "/api/v1/dumps", []string{"X-Owner-Key", "short"}, http.StatusBadRequest, "invalid_owner_key"},
+		{http.MethodGet, "/api/v1/dumps", []string{"Accept", "text/html"}, http.StatusNotAcceptable, "not_acceptable"},
+		{http.MethodPost, "/api/v1/dumps?deleteAfter=foo", nil, http.StatusBadRequest, "invalid_delete_after"},
+		{http.MethodPost, "/api/v1/dumps", nil, http.StatusBadRequest, "empty_payload"},
+	}
+	for _, tt := range errors {
+		w = request(a, tt.method, tt.target, nil, tt.headers...)
+		var res struct {
+			Error apiError `json:"error"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &res)
+		if w.Code != tt.status || res.Error.Code != tt.code {
+			t.Errorf("%s %s: got %d %q, want %d %q", tt.method, tt.target, w.Code, res.Error.Code, tt.status, tt.code)
+		}
+	}
+}
+
+func TestUI(t *testing.T) {
+	a, _ := newTestApp(t, true)
+
+	w := request(a, http.MethodGet, "/", nil, "User-Agent", "Mozilla/5.0")
+	if ct := w.Header().Get("Content-Type"); w.Code != http.StatusOK || ct != "text/html; charset=utf-8" {
+		t.Errorf("main page: got %d %q", w.Code, ct)
+	}
+
+	w = request(a, http.MethodGet, "/", nil, "User-Agent", "curl/7.0")
+	if !strings.Contains(w.Body.String(), "curl --data-binary") {
+		t.Errorf("man page: got %q", w.Body)
+	}
+
+	body := strings.NewReader("text=foo&deleteAfter=1h")
+	w = request(a, http.MethodPost, "/dump", body, "Content-Type", "application/x-www-form-urlencoded")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "http://example.com/") {
+		t.Errorf("text dump: got %d %q", w.Code, w.Body)
+	}
+}
+
+// uiPublicID returns the public id from the update link of the page that is
+// rendered when a dump has been created from the UI.
+func uiPublicID(w *httptest.ResponseRecorder) string {
+	body := w.Body.String()
+	i := strings.Index(body, "/update?id=")
+	if i < 0 {
+		return ""
+	}
+	id := body[i+len("/update?id="):]
+	return id[:strings.IndexAny(id, "&\"")]
+}
+
+// TestUIForm makes sure that the form of the UI creates the dump the same way
+// as a regular upload with the same values.
+func TestUIForm(t *testing.T) {
+	a, repo := newTestApp(t, true)
+
+	post := func(form string) *httptest.ResponseRecorder {
+		return request(a, http.MethodPost, "/dump", strings.NewReader(form), "Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	w := post("text=foo&username=foo&password=bar&maxDownloads=3&expireAfterIdle=7d&contentType=text/csv")
+	if w.Code != http.StatusOK || w.Header().Get("X-Delete-Token") == "" {
+		t.Fatalf("got %d %q", w.Code, w.Body)
+	}
+	publicID := uiPublicID(w)
+	du, _ := repo.getDumpByPublicID(publicID)
+	if du == nil || du.passwordHash == nil || du.remainingDownloads == nil || *du.remainingDownloads != 3 ||
+		du.idleExpiry == nil || du.contentType != "text/csv" || du.size == nil || *du.size != 3 || du.encryption != encryptionServer {
+		t.Fatalf("got dump %+v", du)
+	}
+	if w = request(a, http.MethodGet, "/"+publicID, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("get without auth: got %d", w.Code)
+	}
+
+	// A username without a password protects the dump just like basic
+	// auth credentials does for a regular upload.
+	w = post("text=foo&username=foo")
+	if du, _ = repo.getDumpByPublicID(uiPublicID(w)); du == nil || du.passwordHash == nil {
+		t.Errorf("username only: got dump %+v", du)
+	}
+
+	for _, tt := range []struct {
+		form string
+		text string
+	}{
+		{"text=", "empty payload"},
+		{"text=foo&deleteAfter=foo", "Invalid deleteAfter duration"},
+		{"text=foo&expireAfterIdle=foo", "Invalid expireAfterIdle duration"},
+		{"text=foo&maxDownloads=0", "Invalid maxDownloads value"},
+		{"text=foo&slug=a", "Invalid slug"},
+	} {
+		if w = post(tt.form); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), tt.text) {
+			t.Errorf("%s: got %d %q", tt.form, w.Code, w.Body)
+		}
+	}
+}
+
+func TestSlug(t *testing.T) {
+	a, repo := newTestApp(t, true)
+
+	path, token := uploadDump(t, a, "/?slug=oncall-checklist", "foo")
+	if path != "/s/oncall-checklist" {
+		t.Fatalf("got path %s", path)
+	}
+	if w := request(a, http.MethodGet, path, nil); w.Code != http.StatusOK || w.Body.String() != "foo" {
+		t.Errorf("get: got %d %q", w.Code, w.Body)
+	}
+	if w := request(a, http.MethodGet, "/oncall-checklist", nil); w.Code != http.StatusNotFound {
+		t.Errorf("get without the slug path: got %d", w.Code)
+	}
+
+	// The API addresses the dumps by their public ids.
+	var res dumpResource
+	w := request(a, http.MethodPost, "/api/v1/dumps?slug=runbook", strings.NewReader("foo"), "Accept", "application/json")
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil || w.Code != http.StatusCreated {
+		t.Fatalf("api create: got %d %q", w.Code, w.Body)
+	}
+	if res.Slug == nil || *res.Slug != "runbook" || res.URL != "http://example.com/s/runbook" || len(res.ID) != defaultIDLength {
+		t.Errorf("api create: got %+v", res)
+	}
+	if w = request(a, http.MethodGet, "/api/v1/dumps/"+res.ID, nil, "Accept", "application/json"); w.Code != http.StatusOK {
+		t.Errorf("api get: got %d %q", w.Code, w.Body)
+	}
+	if w = request(a, http.MethodGet, "/"+res.ID, nil); w.Code != http.StatusOK || w.Body.String() != "foo" {
+		t.Errorf("get by public id: got %d %q", w.Code, w.Body)
+	}
+	if w = request(a, http.MethodGet, "/api/v1/dumps/runbook", nil, "Accept", "application/json"); w.Code != http.StatusNotFound {
+		t.Errorf("api get by slug: got %d", w.Code)
+	}
+
+	w = request(a, http.MethodPost, "/?slug=oncall-checklist", strings.NewReader("bar"))
+	if w.Code != http.StatusConflict {
+		t.Errorf("taken slug: got %d %q", w.Code, w.Body)
+	}
+	w = request(a, http.MethodPost, "/api/v1/dumps?slug=oncall-checklist", strings.NewReader("bar"), "Accept", "application/json")
+	if w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), `"slug_taken"`) {
+		t.Errorf("api taken slug: got %d %q", w.Code, w.Body)
+	}
+
+	// Reserved slugs and slugs that doesn't match the pattern are
+	// refused.
+	for _, slug := range []string{"text", "about", "favicon.ico", "Oncall", "oncall%2Fchecklist"} {
+		if w = request(a, http.MethodPost, "/?slug="+slug, strings.NewReader("bar")); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: got %d %q", slug, w.Code, w.Body)
+		}
+	}
+
+	// A slug that is the public id of another dump doesn't shadow it.
+	repo.findDump(func(d *dump) bool { return d.publicID == res.ID }).publicID = "abcdefghijk"
+	if p, _ := uploadDump(t, a, "/?slug=abcdefghijk", "bar"); p != "/s/abcdefghijk" {
+		t.Errorf("id-like slug: got path %s", p)
+	}
+	if w = request(a, http.MethodGet, "/s/abcdefghijk", nil); w.Code != http.StatusOK || w.Body.String() != "bar" {
+		t.Errorf("id-like slug: got %d %q", w.Code, w.Body)
+	}
+	if w = request(a, http.MethodGet, "/abcdefghijk", nil); w.Code != http.StatusOK || w.Body.String() != "foo" {
+		t.Errorf("public id that is a slug: got %d %q", w.Code, w.Body)
+	}
+
+	// The slug can be used again when the dump has been deleted.
+	if w = request(a, http.MethodDelete, path, nil, "X-Delete-Token", token); w.Code != http.StatusOK {
+		t.Errorf("delete: got %d", w.Code)
+	}
+	if w = request(a, http.MethodGet, path, nil); w.Code != http.StatusNotFound {
+		t.Errorf("after delete: got %d", w.Code)
+	}
+	uploadDump(t, a, "/?slug=oncall-checklist", "bar")
+	if w = request(a, http.MethodGet, path, nil); w.Code != http.StatusOK || w.Body.String() != "bar" {
+		t.Errorf("reused slug: got %d %q", w.Code, w.Body)
+	}
+
+	body := strings.NewReader("text=foo&slug=postmortem")
+	w = request(a, http.MethodPost, "/dump", body, "Content-Type", "application/x-www-form-urlencoded")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "http://example.com/s/postmortem") {
+		t.Errorf("ui dump: got %d %q", w.Code, w.Body)
+	}
+	body = strings.NewReader("text=foo&slug=postmortem")
+	w = request(a, http.MethodPost, "/dump", body, "Content-Type", "application/x-www-form-urlencoded")
+	if w.Code != http.StatusConflict {
+		t.Errorf("ui taken slug: got %d", w.Code)
+	}
+
+	// The slug input of the UI follows the configured pattern, and it's
+	// left out when slugs are disabled.
+	for _, page := range []string{"/text", "/file"} {
+		w = request(a, http.MethodGet, page, nil)
+		if !strings.Contains(html.UnescapeString(w.Body.String()), `name="slug" pattern="[a-z0-9]+(-[a-z0-9]+)*"`) {
+			t.Errorf("%s: got %s", page, w.Body)
+		}
+	}
+	a.slugs, _ = newSlugPolicy(`^[a-z]{3,10}$`, "")
+	if w = request(a, http.MethodGet, "/text", nil); !strings.Contains(html.UnescapeString(w.Body.String()), `name="slug" pattern="[a-z]{3,10}"`) {
+		t.Errorf("custom pattern: got %s", w.Body)
+	}
+	a.slugs, _ = newSlugPolicy("", "")
+	if w = request(a, http.MethodGet, "/text", nil); strings.Contains(w.Body.String(), `name="slug"`) {
+		t.Errorf("slugs disabled: got %s", w.Body)
+	}
+}
+
+func TestPasswordEncrypted(t *testing.T) {
+	a, repo := newTestApp(t, false)
+
+	if w := request(a, http.MethodPost, "/?encrypt=1", strings.NewReader("foo")); w.Code != http.StatusBadRequest {
+		t.Errorf("encrypt without credentials: got %d", w.Code)
+	}
+
+	auth := basicAuth("foo", "bar")
+	path, token := uploadDump(t, a, "/?encrypt=1", "secret", "Authorization", auth)
+	du, _ := repo.getDumpByPublicID(path[1:])
+	if du.encryption != encryptionPassword || du.contentHash != nil {
+		t.Errorf("got encryption %q and content hash %v", du.encryption, du.contentHash)
+	}
+
+	// The server is unable to decrypt the contents with its own keys.
+	rc, err := a.store.Get(du.filesystemID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	if _, err = age.Decrypt(rc, a.identities...); err == nil {
+		t.Errorf("the contents were decrypted with the key of the server")
+	}
+
+	if w := request(a, http.MethodGet, path, nil, "Authorization", basicAuth("foo", "baz")); w.Code != http.StatusUnauthorized {
+		t.Errorf("wrong password: got %d", w.Code)
+	}
+	w := request(a, http.MethodGet, path, nil, "Authorization", auth)
+	if w.Code != http.StatusOK || w.Body.String() != "secret" || w.Header().Get("ETag") != "" {
+		t.Errorf("got %d %q %v", w.Code, w.Body, w.Header())
+	}
+	w = request(a, http.MethodGet, path, nil, "Authorization", auth, "Range", "bytes=3-")
+	if w.Code != http.StatusPartialContent || w.Body.String() != "ret" {
+		t.Errorf("range: got %d %q", w.Code, w.Body)
+	}
+	w = request(a, http.MethodGet, "/api/v1/dumps"+path, nil, "Authorization", auth)
+	if !strings.Contains(w.Body.String(), `"passwordEncrypted":true`) {
+		t.Errorf("api: got %q", w.Body)
+	}
+
+	// The protection can't be changed since the contents are encrypted
+	// with the password.
+	if w = request(a, http.MethodPatch, path+"?unprotect=1", nil, "X-Delete-Token", token); w.Code != http.StatusBadRequest {
+		t.Errorf("unprotect: got %d", w.Code)
+	}
+	w = request(a, http.MethodPatch, "/api/v1/dumps"+path+"?username=foo&password=baz", nil, "X-Delete-Token", token)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "protection_locked") {
+		t.Errorf("api update: got %d %q", w.Code, w.Body)
+	}
+	if w = request(a, http.MethodPatch, path+"?contentType=text/csv", nil, "X-Delete-Token", token); w.Code != http.StatusOK {
+		t.Errorf("content type: got %d", w.Code)
+	}
+}
+
+func TestClientEncrypted(t *testing.T) {
+	a, repo := newTestApp(t, true)
+
+	identity, _ := age.GenerateX25519Identity()
+	buf := &bytes.Buffer{}
+	ew, _ := age.Encrypt(buf, identity.Recipient())
+	io.WriteString(ew, "secret\n")
+	ew.Close()
+	encrypted := buf.String()
+
+	path, _ := uploadDump(t, a, "/", encrypted)
+	if du, _ := repo.getDumpByPublicID(path[1:]); !du.clientEncrypted {
+		t.Errorf("the dump isn't marked as client encrypted")
+	}
+	plain, _ := uploadDump(t, a, "/", "foo")
+	if du, _ := repo.getDumpByPublicID(plain[1:]); du.clientEncrypted {
+		t.Errorf("the plaintext dump is marked as client encrypted")
+	}
+
+	// The contents are stored opaquely and are returned as uploaded.
+	w := request(a, http.MethodGet, path, nil, "User-Agent", "curl/7.0")
+	if w.Code != http.StatusOK || w.Body.String() != encrypted {
+		t.Fatalf("got %d %q", w.Code, w.Body)
+	}
+	r, err := age.Decrypt(w.Body, identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadAll(r); string(b) != "secret\n" {
+		t.Errorf("got %q", b)
+	}
+
+	// Browsers are sent to the decrypt page.
+	w = request(a, http.MethodGet, path, nil, "Accept", "text/html,*/*")
+	if loc := w.Header().Get("Location"); w.Code != http.StatusFound || loc != "/decrypt?id="+path[1:] {
+		t.Errorf("got %d %q", w.Code, loc)
+	}
+	if w = request(a, http.MethodGet, plain, nil, "Accept", "text/html,*/*"); w.Code != http.StatusOK {
+		t.Errorf("plaintext dump: got %d", w.Code)
+	}
+
+	w = request(a, http.MethodGet, "/decrypt?id="+path[1:], nil)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `data-id="`+path[1:]+`"`) {
+		t.Errorf("decrypt page: got %d %q", w.Code, w.Body)
+	}
+	if w = request(a, http.MethodGet, "/decrypt?id=foo!", nil); w.Code != http.StatusNotFound {
+		t.Errorf("decrypt page with invalid id: got %d", w.Code)
+	}
+	w = request(a, http.MethodGet, "/decrypt.js", nil)
+	if w.Code != http.StatusOK || w.Body.String() != decryptJS {
+		t.Errorf("decrypt.js: got %d", w.Code)
+	}
+
+	w = request(a, http.MethodGet, path+"?info", nil)
+	if !strings.Contains(w.Body.String(), "encrypted:\tclient") {
+		t.Errorf("info: got %q", w.Body)
+	}
+	w = request(a, http.MethodGet, "/api/v1/dumps"+path, nil, "Accept", "application/json")
+	if !strings.Contains(w.Body.String(), `"clientEncrypted":true`) {
+		t.Errorf("api: got %q", w.Body)
+	}
+}
+
+// collidingRepository is a repository where the first inserts of a dump
+// fails as if the public id was already taken.
+type collidingRepository struct {
+	*memRepository
+	collisions int
+	publicIDs  []string
+}
+
+func (c *collidingRepository) insertDump(du *dump) error {
+	c.publicIDs = append(c.publicIDs, du.publicID)
+	if len(c.publicIDs) <= c.collisions {
+		return errDuplicatePublicID
+	}
+
+	return c.memRepository.insertDump(du)
+}
+
+func TestPublicIDCollision(t *testing.T) {
+	a, repo := newTestApp(t, false)
+	a.ids = &idPolicy{length: 20, alphabet: "xyz"}
+	cr := &collidingRepository{memRepository: repo, collisions: 2}
+	a.db = cr
+
+	path, _ := uploadDump(t, a, "/", "foo")
+	if len(cr.publicIDs) != 3 || path != "/"+cr.publicIDs[2] {
+		t.Fatalf("got %s after trying %v", path, cr.publicIDs)
+	}
+	if len(path) != 21 || strings.Trim(path[1:], "xyz") != "" {
+		t.Errorf("got path %s", path)
+	}
+	if w := request(a, http.MethodGet, path, nil); w.Code != http.StatusOK || w.Body.String() != "foo" {
+		t.Errorf("got %d %q", w.Code, w.Body)
+	}
+
+	// The upload fails when all attempts collides.
+	cr.publicIDs, cr.collisions = nil, maxPublicIDAttempts
+	w := request(a, http.MethodPost, "/", strings.NewReader("foo"))
+	if w.Code != http.StatusInternalServerError || len(cr.publicIDs) != maxPublicIDAttempts {
+		t.Errorf("got %d after %d attempts", w.Code, len(cr.publicIDs))
+	}
+}
+
+// tusCreate creates a tus upload and returns the path of it.
+func tusCreate(t *testing.T, a *app, length string, headers ...string) string {
+	t.Helper()
+
+	headers = append([]string{"Tus-Resumable", tusVersion, "Upload-Length", length}, headers...)
+	w := request(a, http.MethodPost, tusPath, nil, headers...)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("tusCreate: got status %d: %s", w.Code, w.Body)
+	}
+
+	loc := w.Header().Get("Location")
+	if !strings.HasPrefix(loc, "http://example.com"+tusPath) {
+		t.Fatalf("tusCreate: got location %q", loc)
+	}
+
+	return strings.TrimPrefix(loc, "http://example.com")
+}
+
+// tusPatch sends a chunk of a tus upload.
+func tusPatch(a *app, path string, offset int, chunk string) *httptest.ResponseRecorder {
+	return request(a, http.MethodPatch, path, strings.NewReader(chunk),
+		"Tus-Resumable", tusVersion,
+		"Upload-Offset", strconv.Itoa(offset),
+		"Content-Type", "application/offset+octet-stream")
+}
+
+func TestTusUpload(t *testing.T) {
+	a, _ := newTestApp(t, false)
+
+	path := tusCreate(t, a, "6",
+		"Upload-Metadata", "contentType "+base64.StdEncoding.EncodeToString([]byte("text/x-foo")),
+		"Authorization", basicAuth("foo", "bar"))
+
+	w := tusPatch(a, path, 0, "foo")
+	if w.Code != http.StatusNoContent || w.Header().Get("Upload-Offset") != "3" || w.Header().Get("X-Dump-Url") != "" {
+		t.Fatalf("first chunk: got %d %v", w.Code, w.Header())
+	}
+
+	// The client finds the offset to resume from with a HEAD request.
+	w = request(a, http.MethodHead, path, nil, "Tus-Resumable", tusVersion)
+	if w.Code != http.StatusOK || w.Header().Get("Upload-Offset") != "3" || w.Header().Get("Upload-Length") != "6" {
+		t.Fatalf("head: got %d %v", w.Code, w.Header())
+	}
+
+	// A chunk must start at the current offset and can't be larger than
+	// what remains of the upload.
+	if w = tusPatch(a, path, 0, "foo"); w.Code != http.StatusConflict {
+		t.Errorf("wrong offset: got %d", w.Code)
+	}
+	if w = tusPatch(a, path, 3, "barbaz"); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("too large chunk: got %d", w.Code)
+	}
+	if w = request(a, http.MethodPatch, path, strings.NewReader("bar"), "Tus-Resumable", tusVersion, "Upload-Offset", "3"); w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("wrong content type: got %d", w.Code)
+	}
+
+	w = tusPatch(a, path, 3, "bar")
+	if w.Code != http.StatusNoContent || w.Header().Get("Upload-Offset") != "6" || w.Header().Get("X-Delete-Token") == "" {
+		t.Fatalf("last chunk: got %d %v", w.Code, w.Header())
+	}
+	dumpURL := w.Header().Get("X-Dump-Url")
+	if !strings.HasPrefix(dumpURL, "http://example.com/") {
+		t.Fatalf("got dump url %q", dumpURL)
+	}
+	dumpPath := strings.TrimPrefix(dumpURL, "http://example.com")
+
+	// The chunks are removed and the url is still returned for the
+	// finished upload.
+	if names, _ := a.store.List(tusChunkPrefix(path[len(tusPath):])); len(names) != 0 {
+		t.Errorf("got chunks %v", names)
+	}
+	w = request(a, http.MethodHead, path, nil, "Tus-Resumable", tusVersion)
+	if w.Code != http.StatusOK || w.Header().Get("X-Dump-Url") != dumpURL {
+		t.Errorf("head after completion: got %d %v", w.Code, w.Header())
+	}
+
+	// The basic auth credentials protect the finished dump.
+	if w = request(a, http.MethodGet, dumpPath, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("get without auth: got %d", w.Code)
+	}
+	w = request(a, http.MethodGet, dumpPath, nil, "Authorization", basicAuth("foo", "bar"))
+	if w.Code != http.StatusOK || w.Body.String() != "foobar" || w.Header().Get("Content-Type") != "text/x-foo" {
+		t.Errorf("get: got %d %q %v", w.Code, w.Body, w.Header())
+	}
+}
+
+func TestTusRequests(t *testing.T) {
+	a, _ := newTestApp(t, false)
+
+	tests := []struct {
+		name    string
+		headers []string
+		status  int
+	}{
+		{"no version", []string{"Upload-Length", "3"}, http.StatusPreconditionFailed},
+		{"invalid length", []string{"Tus-Resumable", tusVersion, "Upload-Length", "x"}, http.StatusBadRequest},
+		{"empty", []string{"Tus-Resumable", tusVersion, "Upload-Length", "0"}, http.StatusBadRequest},
+		{"too large", []string{"Tus-Resumable", tusVersion, "Upload-Length", "1025"}, http.StatusRequestEntityTooLarge},
+		{"invalid metadata", []string{"Tus-Resumable", tusVersion, "Upload-Length", "3", "Upload-Metadata", "deleteAfter !"}, http.StatusBadRequest},
+		{"invalid expiry", []string{"Tus-Resumable", tusVersion, "Upload-Length", "3", "Upload-Metadata", "deleteAfter " + base64.StdEncoding.EncodeToString([]byte("soon"))}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if w := request(a, http.MethodPost, tusPath, nil, tt.headers...); w.Code != tt.status {
+			t.Errorf("%s: got %d", tt.name, w.Code)
+		}
+	}
+
+	if w := request(a, http.MethodHead, tusPath+newUUID(), nil, "Tus-Resumable", tusVersion); w.Code != http.StatusNotFound {
+		t.Errorf("unknown upload: got %d", w.Code)
+	}
+	w := request(a, http.MethodOptions, tusPath, nil)
+	if w.Code != http.StatusNoContent || w.Header().Get("Tus-Extension") != tusExtensions || w.Header().Get("Tus-Max-Size") != "1024" {
+		t.Errorf("options: got %d %v", w.Code, w.Header())
+	}
+}
+
+func TestTusTermination(t *testing.T) {
+	a, repo := newTestApp(t, false)
+
+	path := tusCreate(t, a, "6")
+	if w := tusPatch(a, path, 0, "foo"); w.Code != http.StatusNoContent {
+		t.Fatalf("chunk: got %d", w.Code)
+	}
+
+	if w := request(a, http.MethodDelete, path, nil, "Tus-Resumable", tusVersion); w.Code != http.StatusNoContent {
+		t.Fatalf("delete: got %d", w.Code)
+	}
+	if w := request(a, http.MethodHead, path, nil, "Tus-Resumable", tusVersion); w.Code != http.StatusNotFound {
+		t.Errorf("head after delete: got %d", w.Code)
+	}
+	if w := tusPatch(a, path, 3, "bar"); w.Code != http.StatusNotFound {
+		t.Errorf("chunk after delete: got %d", w.Code)
+	}
+
+	id := path[len(tusPath):]
+	if names, _ := a.store.List(tusChunkPrefix(id)); len(names) != 0 {
+		t.Errorf("got chunks %v", names)
+	}
+	if _, err := repo.getTusUploadByID(id); err != sql.ErrNoRows {
+		t.Errorf("got %v, want sql.ErrNoRows", err)
+	}
+}
+
+// TestTusConcurrentPatch sends two chunks at the same offset at the same
+// time, only one of them may be stored and the chunk of the other one must
+// not replace it.
+func TestTusConcurrentPatch(t *testing.T) {
+	a, _ := newTestApp(t, false)
+	path := tusCreate(t, a, "9")
+
+	bodies := []string{"foofoo", "barbar"}
+	writers := make([]*io.PipeWriter, len(bodies))
+	results := make([]*httptest.ResponseRecorder, len(bodies))
+	var wg sync.WaitGroup
+	for i := range bodies {
+		pr, pw := io.Pipe()
+		writers[i] = pw
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = request(a, http.MethodPatch, path, pr,
+				"Tus-Resumable", tusVersion,
+				"Upload-Offset", "0",
+				"Content-Type", "application/offset+octet-stream")
+		}(i)
+	}
+
+	// Both requests has passed the offset check once they have started
+	// to read their chunks.
+	for i, pw := range writers {
+		io.WriteString(pw, bodies[i][:3])
+	}
+	for i, pw := range writers {
+		io.WriteString(pw, bodies[i][3:])
+		pw.Close()
+	}
+	wg.Wait()
+
+	winner := -1
+	for i, w := range results {
+		switch w.Code {
+		case http.StatusNoContent:
+			if winner != -1 {
+				t.Fatalf("both chunks were stored")
+			}
+			winner = i
+		case http.StatusConflict:
+		default:
+			t.Fatalf("%s: got %d %q", bodies[i], w.Code, w.Body)
+		}
+	}
+	if winner == -1 {
+		t.Fatalf("none of the chunks were stored")
+	}
+
+	w := tusPatch(a, path, 6, "baz")
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("last chunk: got %d %q", w.Code, w.Body)
+	}
+	dumpPath := strings.TrimPrefix(w.Header().Get("X-Dump-Url"), "http://example.com")
+	if w = request(a, http.MethodGet, dumpPath, nil); w.Code != http.StatusOK || w.Body.String() != bodies[winner]+"baz" {
+		t.Errorf("got %d %q, expected %q", w.Code, w.Body, bodies[winner]+"baz")
+	}
+}
+
+// tusMetadataHeader encodes the key value pairs as an Upload-Metadata header.
+func tusMetadataHeader(kv ...string) string {
+	var pairs []string
+	for i := 0; i < len(kv); i += 2 {
+		pairs = append(pairs, kv[i]+" "+base64.StdEncoding.EncodeToString([]byte(kv[i+1])))
+	}
+	return strings.Join(pairs, ",")
+}
+
+func TestTusOptions(t *testing.T) {
+	a, repo := newTestApp(t, false)
+
+	ownerKey := "my-very-secret-owner-key"
+	path := tusCreate(t, a, "3",
+		"Upload-Metadata", tusMetadataHeader("maxDownloads", "2", "expireAfterIdle", "1h", "slug", "big-file"),
+		"X-Owner-Key", ownerKey)
+
+	// The url of the dump is built the same way as for a regular upload.
+	a.baseURL, _ = parseBaseURL("https://example.com/dumps")
+	w := tusPatch(a, path, 0, "foo")
+	if w.Code != http.StatusNoContent || w.Header().Get("X-Dump-Url") != "https://example.com/dumps/s/big-file" {
+		t.Fatalf("last chunk: got %d %v", w.Code, w.Header())
+	}
+
+	du, err := repo.getDumpBySlug("big-file")
+	if err != nil || du.remainingDownloads == nil || *du.remainingDownloads != 2 ||
+		du.idleExpiry == nil || *du.idleExpiry != 3600 ||
+		du.ownerKeyHash == nil || *du.ownerKeyHash != hashToken(ownerKey) {
+		t.Fatalf("got dump %+v, %v", du, err)
+	}
+
+	tests := []struct {
+		name    string
+		headers []string
+		status  int
+	}{
+		{"encrypt", []string{"Upload-Metadata", tusMetadataHeader("encrypt", "1"), "Authorization", basicAuth("foo", "bar")}, http.StatusBadRequest},
+		{"invalid max downloads", []string{"Upload-Metadata", tusMetadataHeader("maxDownloads", "0")}, http.StatusBadRequest},
+		{"invalid idle expiry", []string{"Upload-Metadata", tusMetadataHeader("expireAfterIdle", "foo")}, http.StatusBadRequest},
+		{"invalid slug", []string{"Upload-Metadata", tusMetadataHeader("slug", "Big File")}, http.StatusBadRequest},
+		{"taken slug", []string{"Upload-Metadata", tusMetadataHeader("slug", "big-file")}, http.StatusConflict},
+		{"short owner key", []string{"X-Owner-Key", "short"}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		headers := append([]string{"Tus-Resumable", tusVersion, "Upload-Length", "3"}, tt.headers...)
+		if w := request(a, http.MethodPost, tusPath, nil, headers...); w.Code != tt.status {
+			t.Errorf("%s: got %d %q", tt.name, w.Code, w.Body)
+		}
+	}
+
+	// A slug that is taken while the chunks are received is refused when
+	// the upload is finished.
+	a.baseURL = nil
+	first := tusCreate(t, a, "3", "Upload-Metadata", tusMetadataHeader("slug", "runbook"))
+	second := tusCreate(t, a, "3", "Upload-Metadata", tusMetadataHeader("slug", "runbook"))
+	if w = tusPatch(a, first, 0, "foo"); w.Code != http.StatusNoContent {
+		t.Fatalf("first: got %d", w.Code)
+	}
+	if w = tusPatch(a, second, 0, "bar"); w.Code != http.StatusConflict || w.Header().Get("X-Dump-Url") != "" {
+		t.Errorf("second: got %d %v", w.Code, w.Header())
+	}
+}
+
+// TestTusExpiresAt makes sure that the expiry is resolved when the upload is
+// created, an expiresAt timestamp that passes before the last chunk arrives
+// must not make the upload impossible to finish.
+func TestTusExpiresAt(t *testing.T) {
+	a, repo := newTestApp(t, false)
+
+	expiresAt := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	path := tusCreate(t, a, "3", "Upload-Metadata", "expiresAt "+base64.StdEncoding.EncodeToString([]byte(expiresAt.Format(time.RFC3339))))
+
+	id := path[len(tusPath):]
+	tu, err := repo.getTusUploadByID(id)
+	if err != nil || tu.deleteAfter == nil || *tu.deleteAfter != expiresAt.Format(time.RFC3339Nano) {
+		t.Fatalf("got %+v, %v", tu, err)
+	}
+
+	// Pretend that the timestamp has passed.
+	past := time.Now().Add(-time.Minute).UTC()
+	pastValue := past.Format(time.RFC3339Nano)
+	repo.tusUploads[id].deleteAfter = &pastValue
+
+	w := tusPatch(a, path, 0, "foo")
+	if w.Code != http.StatusNoContent || w.Header().Get("X-Dump-Url") == "" {
+		t.Fatalf("last chunk: got %d %v", w.Code, w.Header())
+	}
+	du, _ := repo.getDumpByPublicID(strings.TrimPrefix(w.Header().Get("X-Dump-Url"), "http://example.com/"))
+	if du == nil || du.deleteAfter == nil || !du.deleteAfter.Equal(past) {
+		t.Errorf("got dump %+v", du)
+	}
+
+	// A deleteAfter duration is resolved from when the upload was created.
+	path = tusCreate(t, a, "3", "Upload-Metadata", "deleteAfter "+base64.StdEncoding.EncodeToString([]byte("10m")))
+	tu, _ = repo.getTusUploadByID(path[len(tusPath):])
+	da, err := time.Parse(time.RFC3339Nano, *tu.deleteAfter)
+	if err != nil || da.Before(time.Now().Add(9*time.Minute)) || da.After(time.Now().Add(10*time.Minute)) {
+		t.Errorf("got %q, %v", *tu.deleteAfter, err)
+	}
+}
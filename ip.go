@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// parseCIDRs parses a list of CIDRs that are separated by commas, a bare IP
+// address is treated as a network with a single address.
+func parseCIDRs(s string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, c := range strings.Split(s, ",") {
+		c = strings.TrimSpace(c)
+		if c == "" {
+			continue
+		}
+
+		if ip := net.ParseIP(c); ip != nil {
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q", c)
+		}
+		nets = append(nets, n)
+	}
+
+	return nets, nil
+}
+
+// containsIP returns true if any of the networks contains the IP address.
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// peerIP returns the IP address of the immediate peer without the port.
+func peerIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// isTrustedPeer returns true if the immediate peer is one of the trusted
+// proxies, which means that its forwarding headers can be trusted.
+func (a *app) isTrustedPeer(r *http.Request) bool {
+	ip := net.ParseIP(peerIP(r))
+	return ip != nil && containsIP(a.trustedProxies, ip)
+}
+
+// forwardedElements returns the elements of the Forwarded headers in the
+// order that the proxies added them, the parameter names are lower case and
+// the quotes around the values are removed.
+func forwardedElements(h http.Header) []map[string]string {
+	var elems []map[string]string
+	for _, v := range h.Values("Forwarded") {
+		for _, elem := range strings.Split(v, ",") {
+			params := map[string]string{}
+			for _, pair := range strings.Split(elem, ";") {
+				kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+				if len(kv) == 2 {
+					params[strings.ToLower(kv[0])] = strings.Trim(kv[1], `"`)
+				}
+			}
+			elems = append(elems, params)
+		}
+	}
+
+	return elems
+}
+
+// forwardedAddr returns the address without the port and the brackets
+// around IPv6 addresses.
+func forwardedAddr(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+
+	return strings.Trim(addr, "[]")
+}
+
+// forwardedFor returns the addresses of the for parameters of the Forwarded
+// headers, or of the X-Forwarded-For headers if there is no Forwarded
+// header. The addresses are in the order that the proxies added them, the
+// ports and the brackets around IPv6 addresses are removed.
+func forwardedFor(h http.Header) []string {
+	var addrs []string
+	for _, elem := range forwardedElements(h) {
+		if addr, ok := elem["for"]; ok {
+			addrs = append(addrs, forwardedAddr(addr))
+		}
+	}
+	if len(addrs) == 0 {
+		for _, v := range h.Values("X-Forwarded-For") {
+			for _, addr := range strings.Split(v, ",") {
+				addrs = append(addrs, forwardedAddr(strings.TrimSpace(addr)))
+			}
+		}
+	}
+
+	return addrs
+}
+
+// clientIP returns the IP address of the client without the port. The
+// address is taken from the forwarding headers if the immediate peer is a
+// trusted proxy, where the addresses are walked from the closest proxy and
+// the first address that isn't a trusted proxy is the client. A value that
+// isn't an IP address, e.g. an obfuscated identifier, stops the walk and the
+// proxy that added it is used.
+func (a *app) clientIP(r *http.Request) string {
+	ip := peerIP(r)
+	if !a.isTrustedPeer(r) {
+		return ip
+	}
+
+	addrs := forwardedFor(r.Header)
+	for i := len(addrs) - 1; i >= 0; i-- {
+		fip := net.ParseIP(addrs[i])
+		if fip == nil {
+			break
+		}
+		ip = fip.String()
+		if !containsIP(a.trustedProxies, fip) {
+			break
+		}
+	}
+
+	return ip
+}
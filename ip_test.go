@@ -0,0 +1,105 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseCIDRs(t *testing.T) {
+	nets, err := parseCIDRs("10.0.0.0/8, 192.0.2.1,2001:db8::/32,::1")
+	if err != nil {
+		t.Fatalf("parseCIDRs: %v", err)
+	}
+	if len(nets) != 4 {
+		t.Fatalf("got %v", nets)
+	}
+
+	for _, ip := range []string{"10.1.2.3", "192.0.2.1", "2001:db8::1", "::1"} {
+		if !containsIP(nets, net.ParseIP(ip)) {
+			t.Errorf("%s: expected it to be contained", ip)
+		}
+	}
+	for _, ip := range []string{"11.0.0.1", "192.0.2.2", "2001:db9::1", "::2"} {
+		if containsIP(nets, net.ParseIP(ip)) {
+			t.Errorf("%s: expected it not to be contained", ip)
+		}
+	}
+
+	if nets, err = parseCIDRs(""); err != nil || len(nets) != 0 {
+		t.Errorf("empty: got %v, %v", nets, err)
+	}
+	for _, s := range []string{"foo", "10.0.0.0/33", "10.0.0.0/8,bar"} {
+		if _, err = parseCIDRs(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	a := &app{}
+	a.trustedProxies, _ = parseCIDRs("127.0.0.1,10.0.0.0/8")
+
+	tests := []struct {
+		remoteAddr string
+		headers    []string
+		want       string
+	}{
+		{"203.0.113.1:1234", nil, "203.0.113.1"},
+		{"[2001:db8::1]:1234", nil, "2001:db8::1"},
+		{"203.0.113.1:1234", []string{"X-Forwarded-For", "198.51.100.1"}, "203.0.113.1"},
+		{"127.0.0.1:1234", nil, "127.0.0.1"},
+		{"127.0.0.1:1234", []string{"X-Forwarded-For", "198.51.100.1"}, "198.51.100.1"},
+		{"127.0.0.1:1234", []string{"X-Forwarded-For", "192.0.2.1, 198.51.100.1, 10.0.0.2"}, "198.51.100.1"},
+		{"127.0.0.1:1234", []string{"X-Forwarded-For", "10.0.0.3, 10.0.0.2"}, "10.0.0.3"},
+		{"127.0.0.1:1234", []string{"X-Forwarded-For", "garbage"}, "127.0.0.1"},
+		{"127.0.0.1:1234", []string{"Forwarded", `for="[2001:db8::2]:4711";proto=https`}, "2001:db8::2"},
+		{"127.0.0.1:1234", []string{"Forwarded", "for=192.0.2.1, for=198.51.100.2"}, "198.51.100.2"},
+		{"127.0.0.1:1234", []string{"Forwarded", "for=198.51.100.3", "X-Forwarded-For", "198.51.100.4"}, "198.51.100.3"},
+		{"127.0.0.1:1234", []string{"Forwarded", "for=_hidden"}, "127.0.0.1"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = tt.remoteAddr
+		for i := 0; i+1 < len(tt.headers); i += 2 {
+			r.Header.Set(tt.headers[i], tt.headers[i+1])
+		}
+		if ip := a.clientIP(r); ip != tt.want {
+			t.Errorf("%s %v: got %s, want %s", tt.remoteAddr, tt.headers, ip, tt.want)
+		}
+	}
+}
+
+func TestTrustedProxies(t *testing.T) {
+	a, repo := newTestApp(t, false)
+	a.trustedProxies, _ = parseCIDRs("192.0.2.1")
+	a.limits, _ = newRateLimits("1/1h", "", "", "")
+
+	upload := func(xff string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("foo"))
+		r.RemoteAddr = "192.0.2.1:1234"
+		r.Header.Set("X-Forwarded-For", xff)
+		w := httptest.NewRecorder()
+		a.router(w, r)
+		return w
+	}
+
+	w := upload("198.51.100.1")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("upload: got %d", w.Code)
+	}
+	path := strings.TrimPrefix(strings.TrimSpace(w.Body.String()), "http://example.com")
+	if du, _ := repo.getDumpByPublicID(path[1:]); du == nil || du.ipAddress != "198.51.100.1" {
+		t.Errorf("got dump %+v", du)
+	}
+
+	// The clients behind the proxy are limited separately.
+	if w = upload("198.51.100.1"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("second upload: got %d", w.Code)
+	}
+	if w = upload("198.51.100.2"); w.Code != http.StatusCreated {
+		t.Errorf("upload from another client: got %d", w.Code)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+// encryptLegacyDumps encrypts the contents of the dumps that were stored in
+// plaintext before the contents were encrypted at rest. The encrypted
+// contents are written under a new filesystem id before the dump is updated,
+// that way a dump is never marked as encrypted while the plaintext is still
+// in place, and the command can be run again if it is interrupted.
+func (a *app) encryptLegacyDumps() error {
+	afterID := nilUUID
+	var count, failed int
+	for {
+		dumps, err := a.db.getDumpsByEncryption(encryptionNone, afterID, rekeyBatchSize)
+		if err != nil {
+			return fmt.Errorf("failed to fetch plaintext dumps: %v", err)
+		}
+		if len(dumps) == 0 {
+			break
+		}
+
+		for _, du := range dumps {
+			if err := a.rewriteContents(du); err != nil {
+				log.Printf("failed to encrypt %s: %v\n", du.publicID, err)
+				failed++
+				continue
+			}
+			log.Printf("encrypted %s\n", du.publicID)
+			count++
+		}
+
+		afterID = dumps[len(dumps)-1].id
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("failed to encrypt %d of %d dumps", failed, count+failed)
+	}
+
+	return nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEncryptLegacyDumps(t *testing.T) {
+	a, repo := newTestApp(t, false)
+
+	// A dump that was stored in plaintext before the contents were
+	// encrypted at rest.
+	du := &dump{
+		contentType:  "text/plain",
+		encryption:   encryptionNone,
+		filesystemID: newUUID(),
+		insertedAt:   time.Now(),
+		publicID:     "legacydump1",
+	}
+	if err := a.store.Put(du.filesystemID, strings.NewReader("foo\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.insertDump(du); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := a.encryptLegacyDumps(); err != nil {
+		t.Fatalf("encryptLegacyDumps: %v", err)
+	}
+
+	enc, _ := repo.getDumpByPublicID(du.publicID)
+	if enc.encryption != encryptionServer || enc.filesystemID == du.filesystemID {
+		t.Fatalf("got %+v", enc)
+	}
+	if enc.size == nil || *enc.size != 4 || enc.contentHash == nil || *enc.contentHash != sha256Hex("foo\n") {
+		t.Errorf("expected the size and hash to be stored, got %+v", enc)
+	}
+	if _, err := a.store.Stat(du.filesystemID); err != errBlobNotFound {
+		t.Errorf("the plaintext file wasn't removed: %v", err)
+	}
+
+	w := request(a, http.MethodGet, "/"+du.publicID, nil)
+	if w.Code != http.StatusOK || w.Body.String() != "foo\n" {
+		t.Fatalf("got %d %q", w.Code, w.Body)
+	}
+	if w.Header().Get("ETag") != `"`+sha256Hex("foo\n")+`"` || w.Header().Get("Content-Length") != "4" {
+		t.Errorf("got headers %v", w.Header())
+	}
+
+	// There is nothing left to encrypt the second time.
+	if err := a.encryptLegacyDumps(); err != nil {
+		t.Errorf("encryptLegacyDumps again: %v", err)
+	}
+}
+
+// sha256Hex returns the hex encoded SHA-256 hash of the string.
+func sha256Hex(s string) string {
+	h := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(h[:])
+}
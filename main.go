@@ -6,58 +6,74 @@ import (
 	"fmt"
 	"html/template"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
-	"regexp"
+	"strings"
+	"time"
 
 	"filippo.io/age"
 	"github.com/osm/flen"
 	"golang.org/x/crypto/acme/autocert"
 )
 
-// isValidPublicFileID is a regular expression that checks that the given data
-// is a valid public file id.
-var isValidPublicFileID = regexp.MustCompile(`^[a-zA-Z0-9\-]+$`).MatchString
-
-// newPublicFileID generates a new public file id.
-func newPublicFileID() string {
-	return rndStr(11)
-}
-
 // app holds the main structure of this application.
 type app struct {
-	db          *db
-	dataDir     string
+	db          dumpRepository
+	store       blobStore
 	port        string
 	maxFileSize int64
 	recipient   *age.X25519Recipient
-	identity    *age.X25519Identity
+	identities  []age.Identity
 	uiTpl       *template.Template
 	urlScheme   string
+	expiry      *expiryPolicy
+	idleExpiry  time.Duration
+	ids         *idPolicy
+	slugs       *slugPolicy
+	limits      *rateLimits
+
+	// trustedProxies are the networks of the proxies whose forwarding
+	// headers are trusted.
+	trustedProxies []*net.IPNet
+
+	// baseURL is the public URL of the server, nil means that the URL is
+	// built from the request. Its path is the prefix that the server is
+	// mounted under.
+	baseURL *url.URL
 }
 
 // newApp returns a new app.
-func newApp(db *db, dataDir, port, pubKey, privKey string, maxFileSize int64, ui bool) (*app, error) {
+func newApp(db dumpRepository, store blobStore, port, pubKey, privKey string, maxFileSize int64, ui bool) (*app, error) {
 	app := &app{
 		db:          db,
-		dataDir:     dataDir,
+		store:       store,
 		port:        port,
 		maxFileSize: maxFileSize,
+		expiry:      &expiryPolicy{allowInfinite: true},
+		ids:         &idPolicy{length: defaultIDLength, alphabet: charset},
 	}
 
 	// Make sure the provided age public and private keys are possible to
-	// parse before we procced.
+	// parse before we procced. The private keys is a key ring where one of
+	// the keys belongs to the public key and the others are kept to be
+	// able to decrypt what was encrypted before the keys were rotated.
 	recipient, err := age.ParseX25519Recipient(pubKey)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse public key: %v", err)
 	}
 	app.recipient = recipient
 
-	identity, err := age.ParseX25519Identity(privKey)
+	identities, err := parseKeyRing(pubKey, privKey)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse private key: %v", err)
 	}
-	app.identity = identity
+	app.identities = identities
+
+	if app.slugs, err = newSlugPolicy(defaultSlugPattern, defaultReservedSlugs); err != nil {
+		return nil, err
+	}
 
 	if ui {
 		app.uiTpl, err = template.New("ui").Parse(uiHTML)
@@ -75,17 +91,59 @@ func getCert(certManager *autocert.Manager) func(hello *tls.ClientHelloInfo) (*t
 	}
 }
 
+// commands contains the commands that can be given as the first argument
+// instead of starting the server.
+var commands = map[string]func(a *app) error{
+	"encrypt-legacy": (*app).encryptLegacyDumps,
+	"rekey":          (*app).rekey,
+}
+
 func main() {
+	// The first argument is treated as a command if it isn't a flag, the
+	// remaining arguments are parsed as flags.
+	var command string
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		command = os.Args[1]
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+		if _, ok := commands[command]; !ok {
+			fmt.Fprintf(os.Stderr, "error: unknown command %s\n", command)
+			return
+		}
+	}
+
 	// Add command flags and parse them.
-	cs := flag.String("cs", "", "database connection string")
+	cs := flag.String("cs", "", "database connection string, a postgres connection string or sqlite:///path/to/db.sqlite")
 	dataDir := flag.String("data-dir", "", "data directory for uploaded files")
 	letsEncryptDomain := flag.String("lets-encrypt-domain", "", "let's encrypt domain name")
 	letsEncryptCertDir := flag.String("lets-encrypt-cert-dir", "certs", "let's encrypt cert dir")
 	maxFileSize := flag.Int64("max-file-size", 100000000, "max file size, defaults to 100 MB.")
 	port := flag.String("port", "80", "port to listen on, the port is only used if domain is localhost")
-	privKey := flag.String("priv-key", "", "private age enryption key")
+	privKey := flag.String("priv-key", "", "private age enryption keys separated by commas, one of them must belong to the public key")
+	privKeyFile := flag.String("priv-key-file", "", "age-keygen file with one or more private age encryption keys, the public key defaults to the last key of the file")
 	pubKey := flag.String("pub-key", "", "public age enryption key")
+	pubKeyFile := flag.String("pub-key-file", "", "age-keygen or recipients file with the public age encryption key")
+	s3Endpoint := flag.String("s3-endpoint", "", "s3 compatible endpoint url, files are stored in s3 instead of the data dir when set")
+	s3Bucket := flag.String("s3-bucket", "", "s3 bucket name")
+	s3Region := flag.String("s3-region", "us-east-1", "s3 region")
+	s3AccessKey := flag.String("s3-access-key", "", "s3 access key")
+	s3SecretKey := flag.String("s3-secret-key", "", "s3 secret key")
+	s3Prefix := flag.String("s3-prefix", "", "s3 object key prefix")
 	ui := flag.Bool("ui", false, "enable html ui")
+	defaultExpiry := flag.String("default-expiry", "", "expiry of dumps that are uploaded without one, e.g. 24h, 7d or 2w, defaults to never")
+	maxExpiry := flag.String("max-expiry", "", "longest allowed expiry, e.g. 90d, infinite retention is not allowed when set")
+	allowInfinite := flag.Bool("allow-infinite", true, "allow dumps that never expire")
+	idleExpiry := flag.String("idle-expiry", "", "delete dumps that hasn't been downloaded for the duration, e.g. 90d, disabled by default")
+	idLength := flag.Int("id-length", defaultIDLength, "length of the public ids of new dumps")
+	idAlphabet := flag.String("id-alphabet", charset, "characters of the public ids of new dumps, letters, digits, - and _ are allowed")
+	slugPattern := flag.String("slug-pattern", defaultSlugPattern, "regular expression that the slugs of dumps must match, slugs are disabled if it's empty")
+	reservedSlugs := flag.String("reserved-slugs", defaultReservedSlugs, "slugs that can't be used separated by commas, the paths of the server are always reserved")
+	uploadRateLimit := flag.String("upload-rate-limit", "", "dumps that a client address can create as n/window, e.g. 30/1h, disabled by default")
+	uploadBytesRateLimit := flag.String("upload-bytes-rate-limit", "", "bytes that a client address can upload as n/window, e.g. 1000000000/24h, disabled by default")
+	downloadRateLimit := flag.String("download-rate-limit", "", "downloads that a client address can make as n/window, e.g. 600/1m, disabled by default")
+	rateLimitAllowlist := flag.String("rate-limit-allowlist", "", "CIDRs separated by commas that are exempt from the rate limits")
+	trustedProxies := flag.String("trusted-proxies", "", "CIDRs separated by commas of the proxies whose Forwarded and X-Forwarded-For headers are trusted")
+	baseURL := flag.String("base-url", "", "public URL of the server that links are built from, e.g. https://example.com/paste, defaults to the host of the request")
+	rateLimitShared := flag.Bool("rate-limit-shared", false, "keep the rate limit counters in the database so that all instances share them")
 	flen.SetEnvPrefix("DUMPINEN")
 	flen.Parse()
 
@@ -97,37 +155,143 @@ func main() {
 	}
 	db, err := newDB(*cs)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "error: unable to initialize a database connection, %w\n", err)
+		fmt.Fprintf(os.Stderr, "error: unable to initialize a database connection, %v\n", err)
 		return
 	}
 
-	// Verify that the user has submitted a data dir and that the dir
-	// exists.
-	if *dataDir == "" {
-		fmt.Fprintf(os.Stderr, "-data-dir is required\n")
-		return
+	// Set up the blob store, the files are stored in S3 if an endpoint
+	// is given, otherwise we verify that the user has submitted a data
+	// dir and that the dir exists.
+	var store blobStore
+	if *s3Endpoint != "" {
+		if store, err = newS3Store(*s3Endpoint, *s3Bucket, *s3Region, *s3AccessKey, *s3SecretKey, *s3Prefix); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			return
+		}
+	} else {
+		if *dataDir == "" {
+			fmt.Fprintf(os.Stderr, "-data-dir or -s3-endpoint is required\n")
+			return
+		}
+		if store, err = newFSStore(*dataDir); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s is not a valid directory\n", *dataDir)
+			return
+		}
 	}
-	if _, err := os.Stat(*dataDir); os.IsNotExist(err) {
-		fmt.Fprintf(os.Stderr, "error: %s is not a valid directory\n", *dataDir)
-		return
+
+	// Read the keys from the key files, the public key of the private key
+	// file is used when no public key is given. Keys that are passed as
+	// flags are visible to other users of the host, so the files are
+	// preferred.
+	if *pubKeyFile != "" {
+		if *pubKey != "" {
+			fmt.Fprintf(os.Stderr, "-pub-key and -pub-key-file can't be combined\n")
+			return
+		}
+		if *pubKey, err = readPubKeyFile(*pubKeyFile); err != nil {
+			fmt.Fprintf(os.Stderr, "error: unable to read the public key file, %v\n", err)
+			return
+		}
+	}
+	if *privKeyFile != "" {
+		if *privKey != "" {
+			fmt.Fprintf(os.Stderr, "-priv-key and -priv-key-file can't be combined\n")
+			return
+		}
+		var filePubKey string
+		if *privKey, filePubKey, err = readPrivKeyFile(*privKeyFile); err != nil {
+			fmt.Fprintf(os.Stderr, "error: unable to read the private key file, %v\n", err)
+			return
+		}
+		if *pubKey == "" {
+			*pubKey = filePubKey
+		}
 	}
 
 	// Make sure that the public and private keys are set
 	if *pubKey == "" {
-		fmt.Fprintf(os.Stderr, "-pub-key is required and needs to be a valid age public key\n")
+		fmt.Fprintf(os.Stderr, "-pub-key or -pub-key-file is required and needs to be a valid age public key\n")
 		return
 	}
 	if *privKey == "" {
-		fmt.Fprintf(os.Stderr, "-priv-key is required and needs to be a valid age private key\n")
+		fmt.Fprintf(os.Stderr, "-priv-key or -priv-key-file is required and needs to be one or more valid age private keys\n")
+		return
+	}
+
+	// Make sure that the expiry flags are valid.
+	expiry, err := newExpiryPolicy(*defaultExpiry, *maxExpiry, *allowInfinite)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return
+	}
+	var idle time.Duration
+	if *idleExpiry != "" {
+		if idle, err = parseDuration(*idleExpiry); err != nil {
+			fmt.Fprintf(os.Stderr, "error: invalid idle expiry: %v\n", err)
+			return
+		}
+	}
+
+	// Make sure that the id flags are valid.
+	ids, err := newIDPolicy(*idLength, *idAlphabet)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return
+	}
+
+	// Make sure that the slug flags are valid.
+	slugs, err := newSlugPolicy(*slugPattern, *reservedSlugs)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return
+	}
+
+	// Make sure that the base URL is valid.
+	base, err := parseBaseURL(*baseURL)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return
+	}
+
+	// Make sure that the trusted proxies are valid.
+	proxies, err := parseCIDRs(*trustedProxies)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: trusted proxies: %v\n", err)
+		return
+	}
+
+	// Make sure that the rate limit flags are valid, the counters are
+	// kept in the database when they are shared between instances.
+	limits, err := newRateLimits(*uploadRateLimit, *uploadBytesRateLimit, *downloadRateLimit, *rateLimitAllowlist)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
 		return
 	}
+	if limits != nil && *rateLimitShared {
+		limits.limiter = &dbRateLimiter{db}
+	}
 
 	// Create a new app structure and launch the app.
-	app, err := newApp(db, *dataDir, *port, *pubKey, *privKey, *maxFileSize, *ui)
+	app, err := newApp(db, store, *port, *pubKey, *privKey, *maxFileSize, *ui)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "new app error: %v\n", err)
 		return
 	}
+	app.expiry = expiry
+	app.idleExpiry = idle
+	app.ids = ids
+	app.slugs = slugs
+	app.limits = limits
+	app.trustedProxies = proxies
+	app.baseURL = base
+
+	// Run the command instead of the server if we've got one.
+	if command != "" {
+		if err := commands[command](app); err != nil {
+			fmt.Fprintf(os.Stderr, "%s error: %v\n", command, err)
+		}
+		return
+	}
 
 	// Start the cleaner in a goroutine.
 	go app.cleaner()
@@ -4,8 +4,7 @@ import (
 	"fmt"
 )
 
-func (a *app) getManText(host string) []byte {
-	h := fmt.Sprintf("%s://%s", a.urlScheme, host)
+func (a *app) getManText(h string) []byte {
 	man := fmt.Sprintf(`Dumpinen is a free text and file dumping service.
 
 You are not allowed to store illegal content on dumpinen.
@@ -30,11 +29,19 @@ echo "foo" | curl --data-binary @- --user foo:bar %s
 curl --user foo:bar %s/NbbMcLcGcA9
 foo
 
+# Dump "foo" encrypted with a passphrase that the server never sees:
+echo "foo" | age -p | curl --data-binary @- %s
+%s/Xq3dF0cXrKp
+
+# Get and decrypt the dump, browsers decrypts it on the page they are sent to:
+curl %s/Xq3dF0cXrKp | age -d
+foo
+
 # Library/CLI code:
 https://github.com/osm/dumpinen
 
 # Server code:
-https://github.com/osm/dumpinen-server`, h, h, h, h, h, h, h, h)
+https://github.com/osm/dumpinen-server`, h, h, h, h, h, h, h, h, h, h, h)
 
 	return []byte(man + "\r\n")
 }
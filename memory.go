@@ -0,0 +1,444 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// memRepository is a dump repository that keeps everything in memory. It
+// behaves like the database implementation and is used by the tests.
+type memRepository struct {
+	mu         sync.Mutex
+	dumps      []*dump
+	accessLogs []*dumpAccessLog
+	tusUploads map[string]*tusUpload
+}
+
+// newMemRepository returns a new empty in-memory repository.
+func newMemRepository() *memRepository {
+	return &memRepository{tusUploads: map[string]*tusUpload{}}
+}
+
+// findDump returns the dump that matches, the lock must be held.
+func (m *memRepository) findDump(match func(du *dump) bool) *dump {
+	for _, du := range m.dumps {
+		if match(du) {
+			return du
+		}
+	}
+
+	return nil
+}
+
+// insertDump inserts a new dump, the public id and filesystem id must be
+// unique.
+func (m *memRepository) insertDump(du *dump) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.findDump(func(d *dump) bool { return d.publicID == du.publicID }) != nil {
+		return errDuplicatePublicID
+	}
+	if m.findDump(func(d *dump) bool { return d.filesystemID == du.filesystemID }) != nil {
+		return fmt.Errorf("duplicate filesystem id")
+	}
+	if du.slug != nil && m.findDump(func(d *dump) bool {
+		return d.deletedAt == nil && d.slug != nil && *d.slug == *du.slug
+	}) != nil {
+		return errDuplicateSlug
+	}
+
+	c := *du
+	c.id = newUUID()
+	c.insertedAt = time.Now()
+	c.deletedAt = nil
+	m.dumps = append(m.dumps, &c)
+
+	return nil
+}
+
+// getDumpByPublicID returns a copy of the dump with the given public id.
+func (m *memRepository) getDumpByPublicID(publicID string) (*dump, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	du := m.findDump(func(d *dump) bool { return d.publicID == publicID })
+	if du == nil {
+		return nil, sql.ErrNoRows
+	}
+
+	c := *du
+	return &c, nil
+}
+
+// getDumpBySlug returns a copy of the dump with the given slug that hasn't
+// been deleted.
+func (m *memRepository) getDumpBySlug(slug string) (*dump, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	du := m.findDump(func(d *dump) bool {
+		return d.deletedAt == nil && d.slug != nil && *d.slug == slug
+	})
+	if du == nil {
+		return nil, sql.ErrNoRows
+	}
+
+	c := *du
+	return &c, nil
+}
+
+// getDumpsByOwnerKeyHash returns copies of the dumps of the owner, the
+// newest dump first.
+func (m *memRepository) getDumpsByOwnerKeyHash(ownerKeyHash string) ([]*dump, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var dumps []*dump
+	for _, du := range m.dumps {
+		if du.deletedAt == nil && du.ownerKeyHash != nil && *du.ownerKeyHash == ownerKeyHash {
+			c := *du
+			dumps = append(dumps, &c)
+		}
+	}
+	sort.SliceStable(dumps, func(i, j int) bool {
+		return dumps[i].insertedAt.After(dumps[j].insertedAt)
+	})
+
+	return dumps, nil
+}
+
+// updateDumpMetadata updates the content type, expiry, idle expiry and basic
+// auth credentials of the dump.
+func (m *memRepository) updateDumpMetadata(du *dump) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if d := m.findDump(func(d *dump) bool { return d.id == du.id }); d != nil {
+		d.contentType = du.contentType
+		d.deleteAfter = du.deleteAfter
+		d.username = du.username
+		d.password = du.password
+		d.passwordHash = du.passwordHash
+		d.idleExpiry = du.idleExpiry
+	}
+
+	return nil
+}
+
+// updateDumpPasswordHash replaces the encrypted basic auth credentials of the
+// dump with the password hash.
+func (m *memRepository) updateDumpPasswordHash(id, passwordHash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if du := m.findDump(func(d *dump) bool { return d.id == id }); du != nil {
+		du.username = nil
+		du.password = nil
+		du.passwordHash = &passwordHash
+	}
+
+	return nil
+}
+
+// getFilesystemIDsToDelete returns the filesystem ids of the dumps that has
+// expired.
+func (m *memRepository) getFilesystemIDsToDelete() ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var filesystemIDs []string
+	now := time.Now()
+	for _, du := range m.dumps {
+		if du.deletedAt == nil && du.deleteAfter != nil && now.After(*du.deleteAfter) {
+			filesystemIDs = append(filesystemIDs, du.filesystemID)
+		}
+	}
+
+	return filesystemIDs, nil
+}
+
+// getIdleFilesystemIDs returns the filesystem ids of the dumps that hasn't
+// been deleted and that hasn't been accessed since the cutoff.
+func (m *memRepository) getIdleFilesystemIDs(cutoff time.Time) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var filesystemIDs []string
+	for _, du := range m.dumps {
+		if du.deletedAt != nil || !du.insertedAt.Before(cutoff) {
+			continue
+		}
+
+		idle := true
+		for _, dal := range m.accessLogs {
+			t, _ := time.Parse(time.RFC3339Nano, dal.insertedAt)
+			if dal.dumpID == du.id && !t.Before(cutoff) {
+				idle = false
+				break
+			}
+		}
+		if idle {
+			filesystemIDs = append(filesystemIDs, du.filesystemID)
+		}
+	}
+
+	return filesystemIDs, nil
+}
+
+// getDumpActivity returns the last access of the dumps that has an idle
+// expiry and that hasn't been deleted.
+func (m *memRepository) getDumpActivity() ([]*dumpActivity, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var activity []*dumpActivity
+	for _, du := range m.dumps {
+		if du.deletedAt != nil || du.idleExpiry == nil {
+			continue
+		}
+
+		da := dumpActivity{
+			filesystemID: du.filesystemID,
+			idleExpiry:   du.idleExpiry,
+			insertedAt:   du.insertedAt,
+		}
+		for _, dal := range m.accessLogs {
+			if dal.dumpID != du.id {
+				continue
+			}
+			t, _ := time.Parse(time.RFC3339Nano, dal.insertedAt)
+			if da.lastAccessAt == nil || t.After(*da.lastAccessAt) {
+				da.lastAccessAt = &t
+			}
+		}
+		activity = append(activity, &da)
+	}
+
+	return activity, nil
+}
+
+// deleteDumpByFilesystemID marks the dump as deleted.
+func (m *memRepository) deleteDumpByFilesystemID(filesystemID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if du := m.findDump(func(d *dump) bool { return d.filesystemID == filesystemID }); du != nil {
+		deletedAt := time.Now().Format(time.RFC3339Nano)
+		du.deletedAt = &deletedAt
+	}
+
+	return nil
+}
+
+// decrementRemainingDownloads decrements the number of remaining downloads.
+func (m *memRepository) decrementRemainingDownloads(id string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	du := m.findDump(func(d *dump) bool { return d.id == id })
+	if du == nil || du.deletedAt != nil || du.remainingDownloads == nil || *du.remainingDownloads <= 0 {
+		return 0, sql.ErrNoRows
+	}
+
+	remaining := *du.remainingDownloads - 1
+	du.remainingDownloads = &remaining
+	return remaining, nil
+}
+
+// insertDumpAccessLog inserts a new entry to the access log.
+func (m *memRepository) insertDumpAccessLog(dal *dumpAccessLog) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c := *dal
+	c.id = newUUID()
+	c.insertedAt = time.Now().Format(time.RFC3339Nano)
+	m.accessLogs = append(m.accessLogs, &c)
+
+	return nil
+}
+
+// getDumpInfoByPublicID returns the creation time and the number of
+// downloads of the dump.
+func (m *memRepository) getDumpInfoByPublicID(publicID string) (*dumpInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	du := m.findDump(func(d *dump) bool { return d.publicID == publicID })
+	if du == nil {
+		return nil, sql.ErrNoRows
+	}
+
+	di := dumpInfo{createdAt: du.insertedAt}
+	for _, dal := range m.accessLogs {
+		if dal.dumpID == du.id {
+			di.count++
+		}
+	}
+
+	return &di, nil
+}
+
+// getDumpsByEncryption returns copies of at most limit dumps that hasn't been
+// deleted and that are stored with the given encryption, ordered by id and
+// starting after the given id.
+func (m *memRepository) getDumpsByEncryption(encryption, afterID string, limit int) ([]*dump, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var dumps []*dump
+	for _, du := range m.dumps {
+		if du.deletedAt == nil && du.encryption == encryption && du.id > afterID {
+			c := *du
+			dumps = append(dumps, &c)
+		}
+	}
+	sort.Slice(dumps, func(i, j int) bool { return dumps[i].id < dumps[j].id })
+	if len(dumps) > limit {
+		dumps = dumps[:limit]
+	}
+
+	return dumps, nil
+}
+
+// updateDumpStorage updates the filesystem id, encryption, size and content
+// hash of the dump.
+func (m *memRepository) updateDumpStorage(id, filesystemID, encryption string, size int64, contentHash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if du := m.findDump(func(d *dump) bool { return d.id == id }); du != nil {
+		du.filesystemID = filesystemID
+		du.encryption = encryption
+		du.size = &size
+		du.contentHash = &contentHash
+	}
+
+	return nil
+}
+
+// getDumpsWithEncryptedCredentials returns copies of at most limit dumps that
+// hasn't been deleted and that has encrypted basic auth credentials, ordered
+// by id and starting after the given id.
+func (m *memRepository) getDumpsWithEncryptedCredentials(afterID string, limit int) ([]*dump, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var dumps []*dump
+	for _, du := range m.dumps {
+		if du.deletedAt == nil && du.id > afterID &&
+			du.username != nil && len(*du.username) > 0 &&
+			du.password != nil && len(*du.password) > 0 {
+			c := *du
+			dumps = append(dumps, &c)
+		}
+	}
+	sort.Slice(dumps, func(i, j int) bool { return dumps[i].id < dumps[j].id })
+	if len(dumps) > limit {
+		dumps = dumps[:limit]
+	}
+
+	return dumps, nil
+}
+
+// updateDumpCredentials updates the encrypted basic auth credentials.
+func (m *memRepository) updateDumpCredentials(id string, username, password []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if du := m.findDump(func(d *dump) bool { return d.id == id }); du != nil {
+		du.username = &username
+		du.password = &password
+	}
+
+	return nil
+}
+
+// insertTusUpload inserts a new tus upload.
+func (m *memRepository) insertTusUpload(tu *tusUpload) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.tusUploads[tu.id]; ok {
+		return fmt.Errorf("duplicate tus upload id")
+	}
+
+	c := *tu
+	c.offset = 0
+	c.publicID = nil
+	m.tusUploads[tu.id] = &c
+
+	return nil
+}
+
+// getTusUploadByID returns a copy of the tus upload.
+func (m *memRepository) getTusUploadByID(id string) (*tusUpload, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tu, ok := m.tusUploads[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+
+	c := *tu
+	return &c, nil
+}
+
+// updateTusUploadOffset moves the offset of the tus upload if it hasn't
+// changed since it was read.
+func (m *memRepository) updateTusUploadOffset(id string, from, to int64, expiresAt time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tu, ok := m.tusUploads[id]
+	if !ok || tu.offset != from {
+		return false, nil
+	}
+
+	tu.offset = to
+	tu.expiresAt = expiresAt
+	return true, nil
+}
+
+// setTusUploadPublicID sets the public id of the assembled dump.
+func (m *memRepository) setTusUploadPublicID(id, publicID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if tu, ok := m.tusUploads[id]; ok {
+		tu.publicID = &publicID
+	}
+
+	return nil
+}
+
+// deleteTusUpload deletes the tus upload.
+func (m *memRepository) deleteTusUpload(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.tusUploads, id)
+	return nil
+}
+
+// getExpiredTusUploadIDs returns the ids of the expired tus uploads.
+func (m *memRepository) getExpiredTusUploadIDs() ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var ids []string
+	now := time.Now()
+	for id, tu := range m.tusUploads {
+		if now.After(tu.expiresAt) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	return ids, nil
+}
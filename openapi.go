@@ -0,0 +1,272 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// apiErrors is the list of errors that can be returned by the API, the
+// codes are listed in the OpenAPI document.
+var apiErrors = []*apiError{
+	apiErrNotFound,
+	apiErrMethodNotAllowed,
+	apiErrNotAcceptable,
+	apiErrUnauthorized,
+	apiErrInvalidDeleteToken,
+	apiErrInvalidDeleteAfter,
+	apiErrInvalidExpiresAt,
+	apiErrExpiryNotAllowed,
+	apiErrInvalidExpireAfterIdle,
+	apiErrInvalidMaxDownloads,
+	apiErrEncryptRequiresPassword,
+	apiErrProtectionLocked,
+	apiErrInvalidSlug,
+	apiErrSlugTaken,
+	apiErrInvalidOwnerKey,
+	apiErrOwnerKeyRequired,
+	apiErrInvalidForm,
+	apiErrEmptyPayload,
+	apiErrPayloadTooLarge,
+	apiErrRateLimited,
+	apiErrInternalServerError,
+}
+
+// openAPISchemas holds the schemas that are referenced by the responses of
+// the routes.
+var openAPISchemas = map[string]interface{}{
+	"Dump": map[string]interface{}{
+		"type":     "object",
+		"required": []string{"id", "url", "contentType", "createdAt", "expiresAt", "expireAfterIdle", "remainingDownloads", "protected", "clientEncrypted", "passwordEncrypted"},
+		"properties": map[string]interface{}{
+			"id":                 map[string]interface{}{"type": "string"},
+			"url":                map[string]interface{}{"type": "string"},
+			"slug":               map[string]interface{}{"type": "string", "description": "The human readable id that the dump is available at, if it has one."},
+			"contentType":        map[string]interface{}{"type": "string"},
+			"size":               map[string]interface{}{"type": "integer", "format": "int64"},
+			"createdAt":          map[string]interface{}{"type": "string", "format": "date-time"},
+			"expiresAt":          map[string]interface{}{"type": "string", "format": "date-time", "nullable": true},
+			"expireAfterIdle":    map[string]interface{}{"type": "string", "nullable": true},
+			"remainingDownloads": map[string]interface{}{"type": "integer", "nullable": true},
+			"protected":          map[string]interface{}{"type": "boolean"},
+			"clientEncrypted":    map[string]interface{}{"type": "boolean", "description": "True if the contents were age encrypted by the client."},
+			"passwordEncrypted":  map[string]interface{}{"type": "boolean", "description": "True if the contents are encrypted with the basic auth password."},
+			"count":              map[string]interface{}{"type": "integer", "description": "Number of downloads, only returned for a single dump."},
+			"deleteToken":        map[string]interface{}{"type": "string", "description": "Only returned when the dump is created."},
+		},
+	},
+	"DumpList": map[string]interface{}{
+		"type":     "object",
+		"required": []string{"dumps"},
+		"properties": map[string]interface{}{
+			"dumps": map[string]interface{}{
+				"type":  "array",
+				"items": map[string]interface{}{"$ref": "#/components/schemas/Dump"},
+			},
+		},
+	},
+	"Info": map[string]interface{}{
+		"type":     "object",
+		"required": []string{"id", "createdAt", "expiresAt", "clientEncrypted", "passwordEncrypted", "count"},
+		"properties": map[string]interface{}{
+			"id":                map[string]interface{}{"type": "string"},
+			"createdAt":         map[string]interface{}{"type": "string", "format": "date-time"},
+			"expiresAt":         map[string]interface{}{"type": "string", "format": "date-time", "nullable": true},
+			"clientEncrypted":   map[string]interface{}{"type": "boolean"},
+			"passwordEncrypted": map[string]interface{}{"type": "boolean"},
+			"count":             map[string]interface{}{"type": "integer"},
+		},
+	},
+}
+
+// openAPIErrorSchema returns the schema of the API errors.
+func openAPIErrorSchema() map[string]interface{} {
+	var codes []string
+	for _, e := range apiErrors {
+		codes = append(codes, e.Code)
+	}
+
+	return map[string]interface{}{
+		"type":     "object",
+		"required": []string{"error"},
+		"properties": map[string]interface{}{
+			"error": map[string]interface{}{
+				"type":     "object",
+				"required": []string{"code", "message"},
+				"properties": map[string]interface{}{
+					"code":    map[string]interface{}{"type": "string", "enum": codes},
+					"message": map[string]interface{}{"type": "string"},
+				},
+			},
+		},
+	}
+}
+
+// openAPIOperationID returns an operation id for the route, e.g.
+// getApiV1DumpsIdContent for GET /api/v1/dumps/{id}/content.
+func openAPIOperationID(rt *route) string {
+	id := strings.ToLower(rt.method)
+	words := strings.FieldsFunc(rt.path, func(c rune) bool {
+		return !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9')
+	})
+	if len(words) == 0 {
+		words = []string{"index"}
+	}
+	for _, w := range words {
+		id += strings.ToUpper(w[:1]) + w[1:]
+	}
+
+	return id
+}
+
+// openAPIContentSchema returns the schema of a request or response body.
+func openAPIContentSchema(contentType, schema string) map[string]interface{} {
+	if schema != "" {
+		return map[string]interface{}{"$ref": "#/components/schemas/" + schema}
+	}
+	if contentType == "application/json" {
+		return map[string]interface{}{"type": "object"}
+	}
+	if strings.HasPrefix(contentType, "text/") {
+		return map[string]interface{}{"type": "string"}
+	}
+
+	return map[string]interface{}{"type": "string", "format": "binary"}
+}
+
+// openAPIOperation returns the OpenAPI operation of the route.
+func openAPIOperation(rt *route) map[string]interface{} {
+	var params []interface{}
+	if strings.Contains(rt.path, "{id}") {
+		params = append(params, map[string]interface{}{
+			"name":        "id",
+			"in":          "path",
+			"required":    true,
+			"description": "The id of the dump or upload.",
+			"schema":      map[string]interface{}{"type": "string"},
+		})
+	}
+	if strings.Contains(rt.path, "{slug}") {
+		params = append(params, map[string]interface{}{
+			"name":        "slug",
+			"in":          "path",
+			"required":    true,
+			"description": "The slug of the dump.",
+			"schema":      map[string]interface{}{"type": "string"},
+		})
+	}
+	for _, p := range rt.params {
+		params = append(params, map[string]interface{}{
+			"name":        p.name,
+			"in":          p.in,
+			"description": p.description,
+			"schema":      map[string]interface{}{"type": p.typ},
+		})
+	}
+
+	// Responses with the same status code are merged, e.g. GET /{id}
+	// returns either the contents or the info.
+	responses := map[string]interface{}{}
+	for _, res := range rt.responses {
+		status := strconv.Itoa(res.status)
+		o, ok := responses[status].(map[string]interface{})
+		if !ok {
+			o = map[string]interface{}{"description": res.description}
+			responses[status] = o
+		} else {
+			o["description"] = fmt.Sprintf("%s %s", o["description"], res.description)
+		}
+		if res.contentType == "" {
+			continue
+		}
+
+		content, ok := o["content"].(map[string]interface{})
+		if !ok {
+			content = map[string]interface{}{}
+			o["content"] = content
+		}
+		content[res.contentType] = map[string]interface{}{
+			"schema": openAPIContentSchema(res.contentType, res.schema),
+		}
+	}
+
+	op := map[string]interface{}{
+		"operationId": openAPIOperationID(rt),
+		"summary":     rt.summary,
+		"responses":   responses,
+	}
+	if len(params) > 0 {
+		op["parameters"] = params
+	}
+	if rt.requestBody != "" {
+		op["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content": map[string]interface{}{
+				rt.requestBody: map[string]interface{}{
+					"schema": openAPIContentSchema(rt.requestBody, ""),
+				},
+			},
+		}
+	}
+	if strings.HasPrefix(rt.path, apiPath) {
+		op["tags"] = []string{"api"}
+	} else if strings.HasPrefix(rt.path, tusPath) {
+		op["tags"] = []string{"tus"}
+	} else if rt.ui {
+		op["tags"] = []string{"ui"}
+	} else {
+		op["tags"] = []string{"legacy"}
+	}
+
+	return op
+}
+
+// openAPIDocument generates the OpenAPI document from the route table, the
+// UI routes are only included if the UI is enabled.
+func (a *app) openAPIDocument(serverURL string) map[string]interface{} {
+	paths := map[string]interface{}{}
+	for i := range routes {
+		rt := &routes[i]
+		if rt.ui && a.uiTpl == nil {
+			continue
+		}
+
+		item, ok := paths[rt.path].(map[string]interface{})
+		if !ok {
+			item = map[string]interface{}{}
+			paths[rt.path] = item
+		}
+		item[strings.ToLower(rt.method)] = openAPIOperation(rt)
+	}
+
+	schemas := map[string]interface{}{"Error": openAPIErrorSchema()}
+	for name, schema := range openAPISchemas {
+		schemas[name] = schema
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       "dumpinen",
+			"description": "Dumpinen is a free text and file dumping service.",
+			"version":     "1.0.0",
+		},
+		"servers": []interface{}{
+			map[string]interface{}{"url": serverURL},
+		},
+		"components": map[string]interface{}{
+			"schemas": schemas,
+			"securitySchemes": map[string]interface{}{
+				"basicAuth": map[string]interface{}{"type": "http", "scheme": "basic"},
+			},
+		},
+		"paths": paths,
+	}
+}
+
+// routeOpenAPI returns the OpenAPI document of the server.
+func (a *app) routeOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	writeJSON(w, http.StatusOK, a.openAPIDocument(a.publicURL(r)))
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// ownerToken returns the delete token from the X-Delete-Token header or from
+// the token query parameter.
+func ownerToken(r *http.Request) string {
+	if token := r.Header.Get("X-Delete-Token"); token != "" {
+		return token
+	}
+
+	return r.URL.Query().Get("token")
+}
+
+// routeDelete handles the DELETE request, the delete token can be given in
+// the X-Delete-Token header or in the token query parameter.
+func (a *app) routeDelete(w http.ResponseWriter, r *http.Request) {
+	log.Printf("dump delete request from %s, to %s\n", a.clientIP(r), r.URL.Path)
+
+	err := a.deleteDumpWithToken(r.URL.Path[1:], ownerToken(r))
+	if err == errDumpNotFound {
+		notFound(w)
+		return
+	}
+	if err == errInvalidDeleteToken {
+		forbidden(w)
+		return
+	}
+	if err != nil {
+		log.Printf("delete dump error: %v\n", err)
+		internalServerError(w)
+		return
+	}
+
+	w.Write([]byte("deleted\r\n"))
+}
+
+// routePatch handles the PATCH request which updates the expiry, content
+// type and protection of a dump. The values are read from the query or from
+// a form encoded body, and the delete token is given the same way as for the
+// DELETE request.
+func (a *app) routePatch(w http.ResponseWriter, r *http.Request) {
+	log.Printf("dump patch request from %s, to %s\n", a.clientIP(r), r.URL.Path)
+
+	if err := r.ParseForm(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "error: invalid form\r\n")
+		return
+	}
+
+	_, err := a.updateDumpWithToken(r.URL.Path[1:], ownerToken(r), r.Form)
+	if err == errDumpNotFound {
+		notFound(w)
+		return
+	}
+	if err == errInvalidDeleteToken {
+		forbidden(w)
+		return
+	}
+	if err == errInvalidDeleteAfter || err == errInvalidExpiresAt || err == errExpiryNotAllowed || err == errInvalidExpireAfterIdle || err == errProtectionLocked {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "error: %v\r\n", err)
+		return
+	}
+	if err != nil {
+		log.Printf("update dump error: %v\n", err)
+		internalServerError(w)
+		return
+	}
+
+	w.Write([]byte("updated\r\n"))
+}
+
+// getDumpWithToken fetches the dump with the given public id or slug and
+// makes sure that the token matches the delete token of the dump.
+func (a *app) getDumpWithToken(publicID, token string) (*dump, error) {
+	du, err := a.getDump(publicID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isValidToken(token, du.deleteTokenHash) {
+		return nil, errInvalidDeleteToken
+	}
+
+	return du, nil
+}
+
+// deleteDumpWithToken deletes the dump with the given public id if the
+// token matches the delete token of the dump. The dump is marked as deleted
+// and the file is removed immediately.
+func (a *app) deleteDumpWithToken(publicID, token string) error {
+	du, err := a.getDumpWithToken(publicID, token)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("deleting %s\n", du.filesystemID)
+	if err = a.db.deleteDumpByFilesystemID(du.filesystemID); err != nil {
+		return err
+	}
+	if err = a.store.Delete(du.filesystemID); err != nil {
+		log.Printf("failed to delete file from blob store: %v\n", err)
+	}
+
+	return nil
+}
+
+// updateDumpWithToken updates the dump with the given public id if the token
+// matches the delete token of the dump and returns the updated dump. Only the
+// values that are present are updated:
+//
+// deleteAfter sets the expiry to the duration from now and expiresAt sets it
+// to the given time, an empty deleteAfter or never removes the expiry. The
+// expiry must be allowed by the server.
+//
+// expireAfterIdle sets the idle expiry, an empty value removes it.
+//
+// contentType replaces the content type.
+//
+// username and password adds or replaces the basic auth protection, and
+// unprotect removes it. The protection of password encrypted dumps can't be
+// changed.
+func (a *app) updateDumpWithToken(publicID, token string, v url.Values) (*dump, error) {
+	du, err := a.getDumpWithToken(publicID, token)
+	if err != nil {
+		return nil, err
+	}
+
+	_, hasDeleteAfter := v["deleteAfter"]
+	if hasDeleteAfter || v.Get("expiresAt") != "" {
+		if v.Get("deleteAfter") == "" && v.Get("expiresAt") == "" {
+			v.Set("deleteAfter", expiryNever)
+		}
+		if du.deleteAfter, err = a.expiry.resolve(v, time.Now()); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, ok := v["expireAfterIdle"]; ok {
+		if du.idleExpiry, err = parseIdleExpiry(v); err != nil {
+			return nil, err
+		}
+	}
+
+	if c := v.Get("contentType"); c != "" {
+		du.contentType = c
+	}
+
+	// The encrypted credentials of older dumps are removed when the
+	// protection is changed.
+	_, unprotect := v["unprotect"]
+	u, p := v.Get("username"), v.Get("password")
+	if du.encryption == encryptionPassword && (unprotect || (u != "" && p != "")) {
+		return nil, errProtectionLocked
+	}
+	if unprotect {
+		du.username = nil
+		du.password = nil
+		du.passwordHash = nil
+	} else if u != "" && p != "" {
+		hash, err := hashPassword(u, p)
+		if err != nil {
+			return nil, fmt.Errorf("error when hashing password, %v", err)
+		}
+		du.username = nil
+		du.password = nil
+		du.passwordHash = &hash
+	}
+
+	if err = a.db.updateDumpMetadata(du); err != nil {
+		return nil, err
+	}
+
+	return du, nil
+}
+
+// routeUIDelete renders the page that asks the user to confirm the deletion
+// of a dump.
+func (a *app) routeUIDelete(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	a.uiTpl.Execute(w, UI{
+		IsDelete:    true,
+		PublicID:    r.URL.Query().Get("id"),
+		DeleteToken: r.URL.Query().Get("token"),
+		Host:        a.publicURL(r),
+	})
+}
+
+// routePostUIDelete deletes the dump from the HTML UI.
+func (a *app) routePostUIDelete(w http.ResponseWriter, r *http.Request) {
+	log.Printf("dump delete request from ui %s\n", a.clientIP(r))
+
+	err := a.deleteDumpWithToken(r.FormValue("id"), r.FormValue("token"))
+	if err == errDumpNotFound {
+		a.routeUIErr(w, r, http.StatusNotFound, "Dump not found")
+		return
+	}
+	if err == errInvalidDeleteToken {
+		a.routeUIErr(w, r, http.StatusForbidden, "Invalid delete token")
+		return
+	}
+	if err != nil {
+		log.Printf("delete dump error: %v\n", err)
+		a.routeUIErr(w, r, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	a.uiTpl.Execute(w, UI{IsDeleted: true, Host: a.publicURL(r)})
+}
+
+// routeUIUpdate renders the page where the user can update a dump.
+func (a *app) routeUIUpdate(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	a.uiTpl.Execute(w, UI{
+		IsUpdate:    true,
+		PublicID:    r.URL.Query().Get("id"),
+		DeleteToken: r.URL.Query().Get("token"),
+		Host:        a.publicURL(r),
+
+		ExpiryOptions: a.expiry.options(),
+	})
+}
+
+// routePostUIUpdate updates the dump from the HTML UI. The lifetime is only
+// changed if the user picked something else than the unchanged option.
+func (a *app) routePostUIUpdate(w http.ResponseWriter, r *http.Request) {
+	log.Printf("dump update request from ui %s\n", a.clientIP(r))
+
+	if err := r.ParseForm(); err != nil {
+		a.routeUIErr(w, r, http.StatusBadRequest, "Invalid form")
+		return
+	}
+	if r.PostForm.Get("deleteAfter") == "unchanged" {
+		r.PostForm.Del("deleteAfter")
+	}
+	if r.PostForm.Get("unprotect") == "" {
+		r.PostForm.Del("unprotect")
+	}
+
+	du, err := a.updateDumpWithToken(r.PostForm.Get("id"), r.PostForm.Get("token"), r.PostForm)
+	if err == errDumpNotFound {
+		a.routeUIErr(w, r, http.StatusNotFound, "Dump not found")
+		return
+	}
+	if err == errInvalidDeleteToken {
+		a.routeUIErr(w, r, http.StatusForbidden, "Invalid delete token")
+		return
+	}
+	if err == errInvalidDeleteAfter || err == errInvalidExpiresAt || err == errExpiryNotAllowed || err == errInvalidExpireAfterIdle {
+		a.routeUIErr(w, r, http.StatusBadRequest, uiExpiryError(err))
+		return
+	}
+	if err == errProtectionLocked {
+		a.routeUIErr(w, r, http.StatusBadRequest, "The protection of a password encrypted dump can't be changed")
+		return
+	}
+	if err != nil {
+		log.Printf("update dump error: %v\n", err)
+		a.routeUIErr(w, r, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	a.uiTpl.Execute(w, UI{
+		IsUpdated: true,
+		DumpURL:   a.publicURL(r) + dumpPath(du),
+		Host:      a.publicURL(r),
+	})
+}
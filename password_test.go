@@ -0,0 +1,58 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHashPassword(t *testing.T) {
+	hash, err := hashPassword("foo", "bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=19456,t=2,p=1$") {
+		t.Errorf("got %s", hash)
+	}
+
+	// The salt is random, so the same credentials gets different hashes.
+	if other, _ := hashPassword("foo", "bar"); other == hash {
+		t.Errorf("got the same hash twice")
+	}
+
+	tests := []struct {
+		username string
+		password string
+		valid    bool
+	}{
+		{"foo", "bar", true},
+		{"foo", "baz", false},
+		{"fo", "o:bar", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		valid, err := verifyPassword(hash, tt.username, tt.password)
+		if err != nil || valid != tt.valid {
+			t.Errorf("%s:%s: got %v, %v", tt.username, tt.password, valid, err)
+		}
+	}
+
+	// The parameters are read from the hash.
+	cheap := "$argon2id$v=19$m=8,t=1,p=1$c2FsdHNhbHQ$xbUaNoEeUOjeo4l3A7EzAojK4ib1Mam3WumN/vxE23A"
+	if _, err := verifyPassword(cheap, "foo", "bar"); err != nil {
+		t.Errorf("got %v", err)
+	}
+
+	for _, invalid := range []string{
+		"",
+		"bar",
+		"$argon2i$v=19$m=8,t=1,p=1$c2FsdHNhbHQ$aGFzaA",
+		"$argon2id$v=18$m=8,t=1,p=1$c2FsdHNhbHQ$aGFzaA",
+		"$argon2id$v=19$m=8$c2FsdHNhbHQ$aGFzaA",
+		"$argon2id$v=19$m=8,t=1,p=1$!$aGFzaA",
+		"$argon2id$v=19$m=8,t=1,p=1$c2FsdHNhbHQ$",
+	} {
+		if _, err := verifyPassword(invalid, "foo", "bar"); err != errInvalidPasswordHash {
+			t.Errorf("%q: got %v", invalid, err)
+		}
+	}
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rateLimitKind tells which rate limits that apply to a route.
+type rateLimitKind int
+
+const (
+	// limitNone is used for routes without rate limits.
+	limitNone rateLimitKind = iota
+
+	// limitUpload is used for routes that creates dumps, they count
+	// towards the upload and the upload bytes limits.
+	limitUpload
+
+	// limitUploadChunk is used for routes that uploads a part of a dump,
+	// they only count towards the upload bytes limit.
+	limitUploadChunk
+
+	// limitDownload is used for routes that serves the contents of dumps.
+	limitDownload
+)
+
+// rateLimit is a token bucket that holds n tokens and is refilled with n
+// tokens per window.
+type rateLimit struct {
+	n      int64
+	window time.Duration
+}
+
+// parseRateLimit parses a rate limit of the form n/window, e.g. 60/1m or
+// 1000000000/24h. Nil is returned if it's empty.
+func parseRateLimit(s string) (*rateLimit, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	parts := strings.SplitN(s, "/", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid rate limit %q, it must be of the form n/window", s)
+	}
+
+	n, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("invalid rate limit %q, n must be a positive number", s)
+	}
+	window, err := parseDuration(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid rate limit %q, %v", s, err)
+	}
+
+	return &rateLimit{n: n, window: window}, nil
+}
+
+// rateLimitBucket is the state of a token bucket, a bucket that doesn't
+// exist is full.
+type rateLimitBucket struct {
+	tokens    float64
+	version   int64
+	updatedAt time.Time
+	fullAt    time.Time
+}
+
+// take refills the bucket for the time that has passed since it was updated
+// and removes cost tokens from it. The tokens are only removed if the bucket
+// holds them, or if force is set which lets the bucket go into debt for costs
+// that are known afterwards. A full bucket allows any cost, so a cost that is
+// larger than the limit isn't refused forever, and a negative cost refunds
+// tokens. The time until the bucket holds enough tokens is returned if the
+// tokens weren't removed, it is at least a second since that is what
+// Retry-After can express.
+func (l *rateLimit) take(b rateLimitBucket, cost int64, force bool, now time.Time) (rateLimitBucket, time.Duration) {
+	n := float64(l.n)
+	tokens := n
+	if !b.updatedAt.IsZero() {
+		tokens = math.Min(n, b.tokens+float64(now.Sub(b.updatedAt))/float64(l.window)*n)
+	}
+
+	need := math.Min(float64(cost), n)
+	if tokens < need && !force {
+		wait := time.Duration((need - tokens) / n * float64(l.window))
+		if wait < time.Second {
+			wait = time.Second
+		}
+		return b, wait
+	}
+
+	tokens = math.Min(n, tokens-float64(cost))
+	return rateLimitBucket{
+		tokens:    tokens,
+		version:   b.version + 1,
+		updatedAt: now,
+		fullAt:    now.Add(time.Duration((n - tokens) / n * float64(l.window))),
+	}, 0
+}
+
+// rateLimiter keeps the token buckets of the rate limits.
+type rateLimiter interface {
+	// take removes cost tokens from the bucket with the given id, see
+	// rateLimit.take. The time until the bucket holds enough tokens is
+	// returned if the request has to wait.
+	take(id string, l *rateLimit, cost int64, force bool, now time.Time) (time.Duration, error)
+
+	// prune removes the buckets that are full.
+	prune(now time.Time) error
+}
+
+// memRateLimiter keeps the buckets in memory, the limits are only enforced
+// per instance.
+type memRateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]rateLimitBucket
+}
+
+// newMemRateLimiter returns a new in-memory rate limiter.
+func newMemRateLimiter() *memRateLimiter {
+	return &memRateLimiter{buckets: map[string]rateLimitBucket{}}
+}
+
+// take removes cost tokens from the bucket with the given id.
+func (m *memRateLimiter) take(id string, l *rateLimit, cost int64, force bool, now time.Time) (time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b, wait := l.take(m.buckets[id], cost, force, now)
+	if wait == 0 {
+		m.buckets[id] = b
+	}
+
+	return wait, nil
+}
+
+// prune removes the buckets that are full.
+func (m *memRateLimiter) prune(now time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, b := range m.buckets {
+		if !b.fullAt.After(now) {
+			delete(m.buckets, id)
+		}
+	}
+
+	return nil
+}
+
+// maxRateLimitAttempts is the number of times a bucket in the database is
+// read and updated before the update is given up, an attempt fails when
+// another instance updates the bucket at the same time.
+const maxRateLimitAttempts = 10
+
+// dbRateLimiter keeps the buckets in the database, which makes all instances
+// that share the database agree on the limits.
+type dbRateLimiter struct {
+	d *db
+}
+
+// take removes cost tokens from the bucket with the given id. The bucket is
+// only updated if it hasn't been changed by another instance since it was
+// read, otherwise it's read again.
+func (l *dbRateLimiter) take(id string, rl *rateLimit, cost int64, force bool, now time.Time) (time.Duration, error) {
+	for i := 0; i < maxRateLimitAttempts; i++ {
+		b, err := l.d.getRateLimitBucket(id)
+		if err == sql.ErrNoRows {
+			nb, wait := rl.take(rateLimitBucket{}, cost, force, now)
+			ok, err := l.d.insertRateLimitBucket(id, &nb)
+			if err != nil {
+				return 0, err
+			}
+			if ok {
+				return wait, nil
+			}
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		nb, wait := rl.take(*b, cost, force, now)
+		if wait > 0 {
+			return wait, nil
+		}
+		ok, err := l.d.updateRateLimitBucket(id, &nb, b.version)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			return 0, nil
+		}
+	}
+
+	return 0, fmt.Errorf("the rate limit bucket %s is updated too often", id)
+}
+
+// prune removes the buckets that are full.
+func (l *dbRateLimiter) prune(now time.Time) error {
+	return l.d.deleteFullRateLimitBuckets(now)
+}
+
+// rateLimits holds the rate limits of the clients, a nil limit means that
+// there is no limit.
+type rateLimits struct {
+	// uploads is the number of dumps a client can create.
+	uploads *rateLimit
+
+	// uploadBytes is the number of bytes a client can upload.
+	uploadBytes *rateLimit
+
+	// downloads is the number of downloads of a client.
+	downloads *rateLimit
+
+	// allowlist contains the networks that are exempt from the limits.
+	allowlist []*net.IPNet
+
+	limiter rateLimiter
+}
+
+// newRateLimits returns the rate limits for the given flag values, nil is
+// returned if none of the limits are set. The buckets are kept in memory.
+func newRateLimits(uploads, uploadBytes, downloads, allowlist string) (*rateLimits, error) {
+	rl := &rateLimits{limiter: newMemRateLimiter()}
+
+	var err error
+	if rl.uploads, err = parseRateLimit(uploads); err != nil {
+		return nil, fmt.Errorf("upload rate limit: %v", err)
+	}
+	if rl.uploadBytes, err = parseRateLimit(uploadBytes); err != nil {
+		return nil, fmt.Errorf("upload bytes rate limit: %v", err)
+	}
+	if rl.downloads, err = parseRateLimit(downloads); err != nil {
+		return nil, fmt.Errorf("download rate limit: %v", err)
+	}
+	if rl.allowlist, err = parseCIDRs(allowlist); err != nil {
+		return nil, fmt.Errorf("rate limit allowlist: %v", err)
+	}
+
+	if rl.uploads == nil && rl.uploadBytes == nil && rl.downloads == nil {
+		return nil, nil
+	}
+
+	return rl, nil
+}
+
+// take removes cost tokens from the bucket of the client for the limit. The
+// request is let through if the limiter fails, a broken limiter shouldn't
+// take the service down.
+func (rl *rateLimits) take(name, client string, l *rateLimit, cost int64, force bool) time.Duration {
+	if l == nil {
+		return 0
+	}
+
+	wait, err := rl.limiter.take(name+":"+client, l, cost, force, time.Now())
+	if err != nil {
+		log.Printf("rate limit error: %v\n", err)
+		return 0
+	}
+
+	return wait
+}
+
+// rateLimitClient returns the id of the client in the buckets, IPv6 clients
+// are limited per /64 since that is what a single host often is given.
+func rateLimitClient(ip net.IP) string {
+	if ip.To4() == nil {
+		return ip.Mask(net.CIDRMask(64, 128)).String() + "/64"
+	}
+
+	return ip.String()
+}
+
+// countingReader counts the bytes that are read from the body.
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+// Read reads from the body and counts the bytes.
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.ReadCloser.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+// serveRateLimited serves the request if the client is within the rate
+// limits of the route. The uploaded bytes are reserved from the
+// Content-Length header before the request is served, the difference to the
+// bytes that were read is settled afterwards. The reservation is refunded if
+// the request is refused by another limit.
+func (a *app) serveRateLimited(rt *route, w http.ResponseWriter, r *http.Request) {
+	rl := a.limits
+	ip := net.ParseIP(a.clientIP(r))
+	if ip == nil || containsIP(rl.allowlist, ip) {
+		rt.handler(a, w, r)
+		return
+	}
+	client := rateLimitClient(ip)
+
+	var reserved int64
+	limitBytes := rl.uploadBytes != nil && (rt.limit == limitUpload || rt.limit == limitUploadChunk)
+	if limitBytes {
+		if r.ContentLength > 0 {
+			reserved = r.ContentLength
+		}
+		if wait := rl.take("upload-bytes", client, rl.uploadBytes, reserved, false); wait > 0 {
+			a.tooManyRequests(w, r, rt, wait)
+			return
+		}
+	}
+
+	var wait time.Duration
+	switch rt.limit {
+	case limitUpload:
+		wait = rl.take("upload", client, rl.uploads, 1, false)
+	case limitDownload:
+		wait = rl.take("download", client, rl.downloads, 1, false)
+	}
+	if wait > 0 {
+		if limitBytes && reserved > 0 {
+			rl.take("upload-bytes", client, rl.uploadBytes, -reserved, true)
+		}
+		a.tooManyRequests(w, r, rt, wait)
+		return
+	}
+
+	if !limitBytes {
+		rt.handler(a, w, r)
+		return
+	}
+
+	cr := &countingReader{ReadCloser: r.Body}
+	r.Body = cr
+	rt.handler(a, w, r)
+
+	if d := cr.n - reserved; d != 0 {
+		rl.take("upload-bytes", client, rl.uploadBytes, d, true)
+	}
+}
+
+// tooManyRequests tells the client that it has hit a rate limit and when it
+// can try again, in the format of the route.
+func (a *app) tooManyRequests(w http.ResponseWriter, r *http.Request, rt *route, wait time.Duration) {
+	log.Printf("rate limit hit by %s on %s %s\n", a.clientIP(r), r.Method, r.URL.Path)
+	w.Header().Set("Retry-After", strconv.FormatInt(int64(math.Ceil(wait.Seconds())), 10))
+
+	switch {
+	case strings.HasPrefix(r.URL.Path, apiPath):
+		writeAPIError(w, apiErrRateLimited)
+	case rt.path == "/dump" && a.uiTpl != nil:
+		a.routeUIErr(w, r, http.StatusTooManyRequests, "Too many requests, try again later")
+	default:
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte("too many requests, try again later\r\n"))
+	}
+}
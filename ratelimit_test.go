@@ -0,0 +1,143 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRateLimit(t *testing.T) {
+	if l, err := parseRateLimit("60/1m"); err != nil || *l != (rateLimit{60, time.Minute}) {
+		t.Errorf("got %+v, %v", l, err)
+	}
+	if l, err := parseRateLimit("1000/7d"); err != nil || *l != (rateLimit{1000, 7 * 24 * time.Hour}) {
+		t.Errorf("got %+v, %v", l, err)
+	}
+	if l, err := parseRateLimit(""); err != nil || l != nil {
+		t.Errorf("got %+v, %v", l, err)
+	}
+
+	for _, s := range []string{"60", "0/1m", "-1/1m", "x/1m", "60/", "60/x", "60/-1m"} {
+		if _, err := parseRateLimit(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
+
+func TestRateLimitTake(t *testing.T) {
+	l := &rateLimit{n: 10, window: 10 * time.Second}
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// A new bucket is full.
+	b, wait := l.take(rateLimitBucket{}, 10, false, now)
+	if wait != 0 || b.tokens != 0 || !b.fullAt.Equal(now.Add(10*time.Second)) {
+		t.Fatalf("got %+v, %v", b, wait)
+	}
+
+	// One token is refilled per second.
+	if _, wait = l.take(b, 3, false, now.Add(time.Second)); wait != 2*time.Second {
+		t.Errorf("got wait %v, want 2s", wait)
+	}
+	if b, wait = l.take(b, 3, false, now.Add(3*time.Second)); wait != 0 || b.tokens != 0 {
+		t.Errorf("got %+v, %v", b, wait)
+	}
+
+	// The wait is at least a second.
+	if _, wait = l.take(b, 1, false, now.Add(3*time.Second+time.Millisecond)); wait != time.Second {
+		t.Errorf("got wait %v, want 1s", wait)
+	}
+
+	// Forced costs puts the bucket in debt and refunds never overfills
+	// it.
+	if b, wait = l.take(b, 5, true, now.Add(3*time.Second)); wait != 0 || b.tokens != -5 {
+		t.Errorf("got %+v, %v", b, wait)
+	}
+	if b, _ = l.take(b, -100, true, now.Add(3*time.Second)); b.tokens != 10 {
+		t.Errorf("got %v tokens after refund, want 10", b.tokens)
+	}
+
+	// A full bucket allows a cost that is larger than the limit.
+	if b, wait = l.take(b, 15, false, now.Add(3*time.Second)); wait != 0 || b.tokens != -5 {
+		t.Errorf("got %+v, %v", b, wait)
+	}
+}
+
+// testRateLimiter checks that the limiter enforces the limit and that full
+// buckets are pruned.
+func testRateLimiter(t *testing.T, rl rateLimiter) {
+	l := &rateLimit{n: 2, window: time.Minute}
+	now := time.Now().UTC().Truncate(time.Second)
+
+	for i := 0; i < 2; i++ {
+		if wait, err := rl.take("a", l, 1, false, now); err != nil || wait != 0 {
+			t.Fatalf("take %d: got %v, %v", i, wait, err)
+		}
+	}
+	if wait, err := rl.take("a", l, 1, false, now); err != nil || wait != 30*time.Second {
+		t.Errorf("got %v, %v, want 30s", wait, err)
+	}
+	if wait, err := rl.take("b", l, 1, false, now); err != nil || wait != 0 {
+		t.Errorf("other bucket: got %v, %v", wait, err)
+	}
+
+	if err := rl.prune(now.Add(time.Minute)); err != nil {
+		t.Fatalf("prune: %v", err)
+	}
+	if wait, err := rl.take("a", l, 2, false, now); err != nil || wait != 0 {
+		t.Errorf("after prune: got %v, %v", wait, err)
+	}
+}
+
+func TestMemRateLimiter(t *testing.T) {
+	testRateLimiter(t, newMemRateLimiter())
+}
+
+func TestDBRateLimiter(t *testing.T) {
+	testRateLimiter(t, &dbRateLimiter{newTestSQLiteDB(t)})
+}
+
+func TestRateLimits(t *testing.T) {
+	a, _ := newTestApp(t, false)
+
+	var err error
+	if a.limits, err = newRateLimits("2/1h", "10/1h", "1/1h", "198.51.100.0/24"); err != nil {
+		t.Fatal(err)
+	}
+
+	path, _ := uploadDump(t, a, "/", "foo")
+	w := request(a, http.MethodGet, path, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("download: got %d", w.Code)
+	}
+	w = request(a, http.MethodGet, path, nil)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "3600" {
+		t.Errorf("second download: got %d, Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	w = request(a, http.MethodGet, "/api/v1/dumps"+path+"/content", nil)
+	if w.Code != http.StatusTooManyRequests || !strings.Contains(w.Body.String(), `"rate_limited"`) {
+		t.Errorf("api download: got %d %q", w.Code, w.Body)
+	}
+
+	// Seven of the ten bytes are left after the first upload, an upload
+	// with a larger Content-Length is refused before it's read.
+	w = request(a, http.MethodPost, "/", strings.NewReader("foobarbaz"))
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "720" {
+		t.Errorf("upload bytes: got %d, Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	uploadDump(t, a, "/", "foobar")
+	w = request(a, http.MethodPost, "/", strings.NewReader("foo"))
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("third upload: got %d", w.Code)
+	}
+
+	// The allowlist is exempt from the limits.
+	r := httptest.NewRequest(http.MethodGet, path, nil)
+	r.RemoteAddr = "198.51.100.7:1234"
+	w = httptest.NewRecorder()
+	a.router(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("allowlisted download: got %d", w.Code)
+	}
+}
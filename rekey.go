@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"filippo.io/age"
+)
+
+// rekeyBatchSize is the number of dumps that are fetched at a time by rekey.
+const rekeyBatchSize = 100
+
+// rekey re-encrypts the contents of the server encrypted dumps and the basic
+// auth credentials of the dumps with the current public key, which makes it
+// possible to remove the old private keys from the key ring after a key
+// rotation. The contents of password encrypted dumps aren't encrypted with
+// the key of the server, and the credentials of dumps that has been protected
+// since the credentials were hashed aren't encrypted.
+func (a *app) rekey() error {
+	if err := a.rekeyContents(); err != nil {
+		return err
+	}
+
+	afterID := nilUUID
+	var count, failed int
+	for {
+		dumps, err := a.db.getDumpsWithEncryptedCredentials(afterID, rekeyBatchSize)
+		if err != nil {
+			return fmt.Errorf("failed to fetch dumps with encrypted credentials: %v", err)
+		}
+		if len(dumps) == 0 {
+			break
+		}
+
+		for _, du := range dumps {
+			if err := a.rekeyDump(du); err != nil {
+				log.Printf("failed to rekey %s: %v\n", du.publicID, err)
+				failed++
+				continue
+			}
+			count++
+		}
+
+		afterID = dumps[len(dumps)-1].id
+		log.Printf("rekeyed %d dumps\n", count)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("failed to rekey %d of %d dumps", failed, count+failed)
+	}
+
+	return nil
+}
+
+// rekeyContents re-encrypts the contents of the server encrypted dumps that
+// aren't encrypted with the current public key. The contents are written
+// under a new filesystem id before the dump is updated, the same way as the
+// plaintext dumps are encrypted.
+func (a *app) rekeyContents() error {
+	current := a.currentIdentity()
+	if current == nil {
+		return fmt.Errorf("none of the private keys belongs to the public key")
+	}
+
+	afterID := nilUUID
+	var count, failed int
+	for {
+		dumps, err := a.db.getDumpsByEncryption(encryptionServer, afterID, rekeyBatchSize)
+		if err != nil {
+			return fmt.Errorf("failed to fetch encrypted dumps: %v", err)
+		}
+		if len(dumps) == 0 {
+			break
+		}
+
+		for _, du := range dumps {
+			ok, err := a.encryptedWith(du, current)
+			if err == nil && !ok {
+				err = a.rewriteContents(du)
+			}
+			if err != nil {
+				log.Printf("failed to re-encrypt %s: %v\n", du.publicID, err)
+				failed++
+				continue
+			}
+			if !ok {
+				count++
+			}
+		}
+
+		afterID = dumps[len(dumps)-1].id
+		log.Printf("re-encrypted the contents of %d dumps\n", count)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("failed to re-encrypt %d of %d dumps", failed, count+failed)
+	}
+
+	return nil
+}
+
+// encryptedWith reports whether the contents of the dump can be decrypted
+// with the given identity, only the header of the file is read.
+func (a *app) encryptedWith(du *dump, identity age.Identity) (bool, error) {
+	rc, err := a.store.Get(du.filesystemID)
+	if err != nil {
+		return false, err
+	}
+	defer rc.Close()
+
+	if _, err = age.Decrypt(rc, identity); err != nil {
+		if _, ok := err.(*age.NoIdentityMatchError); ok {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+// rekeyDump re-encrypts the basic auth credentials of a single dump.
+func (a *app) rekeyDump(du *dump) error {
+	username, err := a.decrypt(*du.username)
+	if err != nil {
+		return fmt.Errorf("error when decrypting username, %v", err)
+	}
+	password, err := a.decrypt(*du.password)
+	if err != nil {
+		return fmt.Errorf("error when decrypting password, %v", err)
+	}
+
+	encryptedUsername, err := a.encrypt(string(username))
+	if err != nil {
+		return fmt.Errorf("error when encrypting username, %v", err)
+	}
+	encryptedPassword, err := a.encrypt(string(password))
+	if err != nil {
+		return fmt.Errorf("error when encrypting password, %v", err)
+	}
+
+	return a.db.updateDumpCredentials(du.id, encryptedUsername, encryptedPassword)
+}
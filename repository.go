@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	// errDuplicatePublicID is returned by insertDump when the public id
+	// of the dump is already taken.
+	errDuplicatePublicID = errors.New("duplicate public id")
+
+	// errDuplicateSlug is returned by insertDump when the slug of the dump
+	// is used by a dump that hasn't been deleted.
+	errDuplicateSlug = errors.New("the slug is already taken")
+)
+
+// dumpRepository is the storage of the dumps, the access log and the tus
+// uploads. It is implemented by db for PostgreSQL and SQLite, and by
+// memRepository which keeps everything in memory.
+type dumpRepository interface {
+	// insertDump inserts a new dump, errDuplicatePublicID or
+	// errDuplicateSlug is returned if the public id or the slug is
+	// already taken.
+	insertDump(du *dump) error
+
+	// getDumpByPublicID returns the dump with the given public id,
+	// sql.ErrNoRows is returned if it doesn't exist.
+	getDumpByPublicID(publicID string) (*dump, error)
+
+	// getDumpBySlug returns the dump with the given slug that hasn't been
+	// deleted, sql.ErrNoRows is returned if it doesn't exist.
+	getDumpBySlug(slug string) (*dump, error)
+
+	// getDumpsByOwnerKeyHash returns the dumps that hasn't been deleted
+	// and that were created with the owner key, the newest dump first.
+	getDumpsByOwnerKeyHash(ownerKeyHash string) ([]*dump, error)
+
+	// updateDumpMetadata updates the content type, expiry, idle expiry
+	// and basic auth credentials of the dump.
+	updateDumpMetadata(du *dump) error
+
+	// updateDumpPasswordHash replaces the encrypted basic auth
+	// credentials of the dump with the password hash.
+	updateDumpPasswordHash(id, passwordHash string) error
+
+	// getFilesystemIDsToDelete returns the filesystem ids of the dumps
+	// that has expired.
+	getFilesystemIDsToDelete() ([]string, error)
+
+	// getIdleFilesystemIDs returns the filesystem ids of the dumps that
+	// hasn't been deleted and that hasn't been accessed since the cutoff,
+	// the dumps that never has been accessed are idle since they were
+	// uploaded.
+	getIdleFilesystemIDs(cutoff time.Time) ([]string, error)
+
+	// getDumpActivity returns the last access of the dumps that has an
+	// idle expiry and that hasn't been deleted.
+	getDumpActivity() ([]*dumpActivity, error)
+
+	// deleteDumpByFilesystemID marks the dump as deleted.
+	deleteDumpByFilesystemID(filesystemID string) error
+
+	// decrementRemainingDownloads decrements the number of remaining
+	// downloads, sql.ErrNoRows is returned if there are none left.
+	decrementRemainingDownloads(id string) (int, error)
+
+	// insertDumpAccessLog inserts a new entry to the access log.
+	insertDumpAccessLog(dal *dumpAccessLog) error
+
+	// getDumpInfoByPublicID returns the creation time and the number of
+	// downloads of the dump.
+	getDumpInfoByPublicID(publicID string) (*dumpInfo, error)
+
+	// getDumpsByEncryption returns at most limit dumps that hasn't been
+	// deleted and that are stored with the given encryption, ordered by id
+	// and starting after the given id. The first page starts after nilUUID,
+	// since an empty string isn't a valid uuid in PostgreSQL.
+	getDumpsByEncryption(encryption, afterID string, limit int) ([]*dump, error)
+
+	// updateDumpStorage updates the filesystem id, encryption, size and
+	// content hash.
+	updateDumpStorage(id, filesystemID, encryption string, size int64, contentHash string) error
+
+	// getDumpsWithEncryptedCredentials returns at most limit dumps that
+	// hasn't been deleted and that has encrypted basic auth credentials,
+	// ordered by id and starting after the given id, which is nilUUID for
+	// the first page.
+	getDumpsWithEncryptedCredentials(afterID string, limit int) ([]*dump, error)
+
+	// updateDumpCredentials updates the encrypted basic auth credentials.
+	updateDumpCredentials(id string, username, password []byte) error
+
+	// insertTusUpload inserts a new tus upload.
+	insertTusUpload(tu *tusUpload) error
+
+	// getTusUploadByID returns the tus upload, sql.ErrNoRows is returned
+	// if it doesn't exist.
+	getTusUploadByID(id string) (*tusUpload, error)
+
+	// updateTusUploadOffset moves the offset of the tus upload from the
+	// given offset, false is returned if the offset has changed.
+	updateTusUploadOffset(id string, from, to int64, expiresAt time.Time) (bool, error)
+
+	// setTusUploadPublicID sets the public id of the assembled dump.
+	setTusUploadPublicID(id, publicID string) error
+
+	// deleteTusUpload deletes the tus upload.
+	deleteTusUpload(id string) error
+
+	// getExpiredTusUploadIDs returns the ids of the expired tus uploads.
+	getExpiredTusUploadIDs() ([]string, error)
+}
+
+// Make sure that the implementations satisfies the interface.
+var (
+	_ dumpRepository = (*db)(nil)
+	_ dumpRepository = (*memRepository)(nil)
+)
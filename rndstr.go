@@ -1,22 +1,100 @@
 package main
 
 import (
-	"math/rand"
-	"time"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"strings"
 )
 
 // charset contains the valid characters that we want to use when we generate
 // a random string.
 const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-"
 
-// rndStr returns a random string with the given length.
-func rndStr(l int) string {
-	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+// urlSafeChars are the characters that can be used in the alphabet of the
+// public ids, they don't need to be escaped in an URL path.
+const urlSafeChars = charset + "_"
 
-	b := make([]byte, l)
-	for i := range b {
-		b[i] = charset[rnd.Intn(len(charset))]
+// rndStr returns a random string with the given length where the characters
+// are drawn from the alphabet with crypto/rand. Bytes that would make some
+// characters more likely than others are discarded.
+func rndStr(alphabet string, l int) string {
+	limit := 256 - 256%len(alphabet)
+
+	b := make([]byte, 0, l)
+	buf := make([]byte, l)
+	for len(b) < l {
+		if _, err := io.ReadFull(rand.Reader, buf); err != nil {
+			panic(fmt.Sprintf("failed to read random bytes: %v", err))
+		}
+		for _, c := range buf {
+			if int(c) < limit && len(b) < l {
+				b = append(b, alphabet[int(c)%len(alphabet)])
+			}
+		}
 	}
 
 	return string(b)
 }
+
+const (
+	// defaultIDLength is the length of the public ids, which also was
+	// the length of all ids before it was configurable.
+	defaultIDLength = 11
+
+	// minIDLength and maxIDLength are the bounds of the id length.
+	minIDLength = 6
+	maxIDLength = 64
+)
+
+// idPolicy holds the rules for the public ids of the dumps.
+type idPolicy struct {
+	// length is the length of new ids.
+	length int
+
+	// alphabet contains the characters of new ids.
+	alphabet string
+}
+
+// newIDPolicy returns the policy for the given flag values. The alphabet
+// needs at least two distinct URL safe characters.
+func newIDPolicy(length int, alphabet string) (*idPolicy, error) {
+	if length < minIDLength || length > maxIDLength {
+		return nil, fmt.Errorf("the id length needs to be between %d and %d", minIDLength, maxIDLength)
+	}
+	if len(alphabet) < 2 {
+		return nil, fmt.Errorf("the id alphabet needs at least two characters")
+	}
+	for i, c := range alphabet {
+		if !strings.ContainsRune(urlSafeChars, c) {
+			return nil, fmt.Errorf("the id alphabet can only contain letters, digits, - and _")
+		}
+		if strings.IndexRune(alphabet, c) != i {
+			return nil, fmt.Errorf("the id alphabet contains %q more than once", c)
+		}
+	}
+
+	return &idPolicy{length: length, alphabet: alphabet}, nil
+}
+
+// newID generates a new public id.
+func (p *idPolicy) newID() string {
+	return rndStr(p.alphabet, p.length)
+}
+
+// isValid returns true if the given id can be a public id. Ids of the
+// default length are always accepted, so the dumps that were created before
+// the length was changed are still reachable.
+func (p *idPolicy) isValid(id string) bool {
+	if len(id) != p.length && len(id) != defaultIDLength {
+		return false
+	}
+
+	for _, c := range id {
+		if !strings.ContainsRune(urlSafeChars, c) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRndStr(t *testing.T) {
+	seen := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		s := rndStr("ab", 32)
+		if len(s) != 32 || strings.Trim(s, "ab") != "" {
+			t.Fatalf("got %q", s)
+		}
+		if seen[s] {
+			t.Fatalf("got %q twice", s)
+		}
+		seen[s] = true
+	}
+}
+
+func TestNewIDPolicy(t *testing.T) {
+	p, err := newIDPolicy(8, "0123456789")
+	if err != nil {
+		t.Fatalf("newIDPolicy: %v", err)
+	}
+	id := p.newID()
+	if len(id) != 8 || strings.Trim(id, "0123456789") != "" {
+		t.Errorf("got id %q", id)
+	}
+
+	for _, tt := range []struct {
+		length   int
+		alphabet string
+	}{
+		{5, charset},
+		{65, charset},
+		{11, "a"},
+		{11, "abca"},
+		{11, "ab/"},
+		{11, "abcä"},
+	} {
+		if _, err := newIDPolicy(tt.length, tt.alphabet); err == nil {
+			t.Errorf("%d %q: expected an error", tt.length, tt.alphabet)
+		}
+	}
+}
+
+func TestIDPolicyIsValid(t *testing.T) {
+	p := &idPolicy{length: 16, alphabet: "abc"}
+	for _, id := range []string{"aaaaaaaaaaaaaaaa", "abcdefghijk", "WGBtm-RLJkE", "a_b-c_d-e_f-g_h-"} {
+		if !p.isValid(id) {
+			t.Errorf("%q: expected it to be valid", id)
+		}
+	}
+	for _, id := range []string{"", "aaaaaaaaaaaaaaa", "aaaaaaaaaaaaaaaaa", "aaaaaaaaaa/", "aaaaa.aaaaa", "../aaaaaaaa"} {
+		if p.isValid(id) {
+			t.Errorf("%q: expected it to be invalid", id)
+		}
+	}
+}
@@ -0,0 +1,489 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// route describes a route that is handled by the router. The description of
+// the parameters and responses is used to generate the OpenAPI document, so
+// make sure to keep them up to date when a handler is changed.
+type route struct {
+	method      string
+	path        string
+	ui          bool
+	handler     func(a *app, w http.ResponseWriter, r *http.Request)
+	limit       rateLimitKind
+	summary     string
+	params      []routeParam
+	requestBody string
+	responses   []routeResponse
+}
+
+// routeParam describes a query or header parameter of a route. Path
+// parameters are written as {name} in the path of the route.
+type routeParam struct {
+	name        string
+	in          string
+	typ         string
+	description string
+}
+
+// routeResponse describes a response of a route. The schema refers to one of
+// the schemas in the OpenAPI document and is only used for JSON responses.
+type routeResponse struct {
+	status      int
+	description string
+	contentType string
+	schema      string
+}
+
+var (
+	paramDeleteAfter   = routeParam{"deleteAfter", "query", "string", "Delete the dump when the duration has passed, e.g. 10m, 24h, 7d or 2w, or never."}
+	paramExpiresAt     = routeParam{"expiresAt", "query", "string", "Delete the dump at the RFC 3339 timestamp, can't be combined with deleteAfter."}
+	paramIdleExpiry    = routeParam{"expireAfterIdle", "query", "string", "Delete the dump when it hasn't been downloaded for the duration, e.g. 30d."}
+	paramContentType   = routeParam{"contentType", "query", "string", "Content type of the dump, it is detected from the contents if it is omitted."}
+	paramMaxDownloads  = routeParam{"maxDownloads", "query", "integer", "Delete the dump after this number of downloads."}
+	paramBurnAfterRead = routeParam{"burnAfterRead", "query", "boolean", "Delete the dump after the first download."}
+	paramEncrypt       = routeParam{"encrypt", "query", "boolean", "Encrypt the dump with the basic auth password instead of the key of the server, requires basic auth credentials."}
+	paramSlug          = routeParam{"slug", "query", "string", "Human readable id that the dump is available at instead of a random id, e.g. oncall-checklist."}
+	paramSaveAs        = routeParam{"saveAs", "query", "string", "Serve the dump as an attachment with the given file name."}
+	paramInfo          = routeParam{"info", "query", "boolean", "Return information about the dump instead of the contents."}
+	paramToken         = routeParam{"token", "query", "string", "The delete token that was returned when the dump was created."}
+	paramUsername      = routeParam{"username", "query", "string", "Protect the dump with basic auth, requires password."}
+	paramPassword      = routeParam{"password", "query", "string", "Protect the dump with basic auth, requires username."}
+	paramUnprotect     = routeParam{"unprotect", "query", "boolean", "Remove the basic auth protection."}
+	paramDeleteToken   = routeParam{"X-Delete-Token", "header", "string", "The delete token that was returned when the dump was created."}
+	paramOwnerKey      = routeParam{"X-Owner-Key", "header", "string", "Secret of at least 16 characters that identifies the owner of the dumps."}
+	paramTusResumable  = routeParam{"Tus-Resumable", "header", "string", "The tus protocol version, must be 1.0.0."}
+	paramUploadLength  = routeParam{"Upload-Length", "header", "integer", "The total size of the upload."}
+	paramUploadOffset  = routeParam{"Upload-Offset", "header", "integer", "The offset of the chunk."}
+	paramUploadMeta    = routeParam{"Upload-Metadata", "header", "string", "The tus metadata, deleteAfter, expiresAt, expireAfterIdle, contentType, maxDownloads, burnAfterRead and slug are supported."}
+)
+
+var (
+	respTextNotFound       = routeResponse{http.StatusNotFound, "The dump was not found.", "text/plain", ""}
+	respTextForbidden      = routeResponse{http.StatusForbidden, "The delete token is invalid.", "text/plain", ""}
+	respTextUnauthorized   = routeResponse{http.StatusUnauthorized, "The dump is protected with basic auth.", "text/plain", ""}
+	respTextBadRequest     = routeResponse{http.StatusBadRequest, "The request contains invalid parameters.", "text/plain", ""}
+	respTextInternalError  = routeResponse{http.StatusInternalServerError, "Internal server error.", "text/plain", ""}
+	respHTML               = routeResponse{http.StatusOK, "HTML page.", "text/html", ""}
+	respAPINotFound        = routeResponse{http.StatusNotFound, "The dump was not found.", "application/json", "Error"}
+	respAPIUnauthorized    = routeResponse{http.StatusUnauthorized, "The dump is protected with basic auth.", "application/json", "Error"}
+	respAPIForbidden       = routeResponse{http.StatusForbidden, "The delete token is invalid.", "application/json", "Error"}
+	respAPIBadRequest      = routeResponse{http.StatusBadRequest, "The request contains invalid parameters.", "application/json", "Error"}
+	respAPINotAcceptable   = routeResponse{http.StatusNotAcceptable, "The client doesn't accept JSON.", "application/json", "Error"}
+	respAPITooLarge        = routeResponse{http.StatusRequestEntityTooLarge, "The request body is too large.", "application/json", "Error"}
+	respAPIInternalError   = routeResponse{http.StatusInternalServerError, "Internal server error.", "application/json", "Error"}
+	respTusPrecondition    = routeResponse{http.StatusPreconditionFailed, "Unsupported tus version.", "text/plain", ""}
+	respTusNotFound        = routeResponse{http.StatusNotFound, "The upload was not found or has expired.", "text/plain", ""}
+	respTusInternalError   = routeResponse{http.StatusInternalServerError, "Internal server error.", "text/plain", ""}
+	respContentOK          = routeResponse{http.StatusOK, "The contents of the dump.", "application/octet-stream", ""}
+	respContentPartial     = routeResponse{http.StatusPartialContent, "A range of the contents of the dump.", "application/octet-stream", ""}
+	respContentNotModified = routeResponse{http.StatusNotModified, "The dump has not been modified.", "", ""}
+	respTextTooMany        = routeResponse{http.StatusTooManyRequests, "The client has hit a rate limit, the Retry-After header tells when it can try again.", "text/plain", ""}
+	respAPITooMany         = routeResponse{http.StatusTooManyRequests, "The client has hit a rate limit, the Retry-After header tells when it can try again.", "application/json", "Error"}
+)
+
+// routes is the route table of the router. The routes are matched in order
+// and the first matching route is used. The table is populated in init since
+// the OpenAPI handler refers to the table itself.
+var routes []route
+
+func init() {
+	routes = []route{
+		{
+			method:    http.MethodGet,
+			path:      "/",
+			handler:   (*app).routeIndex,
+			summary:   "Returns the manual, or the HTML UI for browsers if it is enabled.",
+			responses: []routeResponse{{http.StatusOK, "The manual.", "text/plain", ""}},
+		},
+		{
+			method:      http.MethodPost,
+			path:        "/",
+			handler:     (*app).routePost,
+			limit:       limitUpload,
+			summary:     "Creates a dump from the request body, basic auth credentials protects the dump.",
+			params:      []routeParam{paramDeleteAfter, paramExpiresAt, paramIdleExpiry, paramContentType, paramMaxDownloads, paramBurnAfterRead, paramEncrypt, paramSlug},
+			requestBody: "application/octet-stream",
+			responses:   []routeResponse{{http.StatusCreated, "The URL of the dump, or the dump as JSON if the client accepts JSON. The delete token is returned in the X-Delete-Token header.", "text/plain", ""}, respTextBadRequest, {http.StatusConflict, "The slug is already taken.", "text/plain", ""}, respTextInternalError, respTextTooMany},
+		},
+		{
+			method:    http.MethodOptions,
+			path:      "/",
+			handler:   (*app).routeOptions,
+			summary:   "CORS preflight for creating dumps.",
+			responses: []routeResponse{{http.StatusOK, "The CORS headers.", "", ""}},
+		},
+		{
+			method:    http.MethodGet,
+			path:      "/openapi.json",
+			handler:   (*app).routeOpenAPI,
+			summary:   "Returns this OpenAPI document.",
+			responses: []routeResponse{{http.StatusOK, "The OpenAPI document.", "application/json", ""}},
+		},
+		{
+			method:    http.MethodGet,
+			path:      "/favicon.ico",
+			ui:        true,
+			handler:   (*app).routeFavicon,
+			summary:   "Returns the favicon.",
+			responses: []routeResponse{{http.StatusOK, "The favicon.", "image/x-icon", ""}},
+		},
+		{
+			method:    http.MethodGet,
+			path:      "/decrypt.js",
+			ui:        true,
+			handler:   (*app).routeDecryptJS,
+			summary:   "Returns the script that decrypts client encrypted dumps in the browser.",
+			responses: []routeResponse{{http.StatusOK, "The script.", "application/javascript", ""}},
+		},
+		{
+			method:    http.MethodGet,
+			path:      "/text",
+			ui:        true,
+			handler:   (*app).routeUIText,
+			summary:   "Renders the text upload page.",
+			responses: []routeResponse{respHTML},
+		},
+		{
+			method:    http.MethodGet,
+			path:      "/file",
+			ui:        true,
+			handler:   (*app).routeUIFile,
+			summary:   "Renders the file upload page.",
+			responses: []routeResponse{respHTML},
+		},
+		{
+			method:    http.MethodGet,
+			path:      "/about",
+			ui:        true,
+			handler:   (*app).routeUIAbout,
+			summary:   "Renders the about page.",
+			responses: []routeResponse{respHTML},
+		},
+		{
+			method:    http.MethodGet,
+			path:      "/decrypt",
+			ui:        true,
+			handler:   (*app).routeUIDecrypt,
+			summary:   "Renders the page that decrypts a client encrypted dump in the browser, the age identity or passphrase is given in the URL fragment.",
+			params:    []routeParam{{"id", "query", "string", "The id of the dump."}},
+			responses: []routeResponse{respHTML, {http.StatusNotFound, "The id is invalid.", "text/html", ""}},
+		},
+		{
+			method:      http.MethodPost,
+			path:        "/dump",
+			ui:          true,
+			handler:     (*app).routePostUI,
+			limit:       limitUpload,
+			summary:     "Creates a dump from the HTML UI form.",
+			requestBody: "multipart/form-data",
+			responses:   []routeResponse{respHTML, {http.StatusBadRequest, "The form contains invalid values.", "text/html", ""}, respTextTooMany},
+		},
+		{
+			method:    http.MethodGet,
+			path:      "/delete",
+			ui:        true,
+			handler:   (*app).routeUIDelete,
+			summary:   "Renders the page that confirms the deletion of a dump.",
+			params:    []routeParam{{"id", "query", "string", "The id of the dump."}, paramToken},
+			responses: []routeResponse{respHTML},
+		},
+		{
+			method:      http.MethodPost,
+			path:        "/delete",
+			ui:          true,
+			handler:     (*app).routePostUIDelete,
+			summary:     "Deletes a dump from the HTML UI.",
+			requestBody: "application/x-www-form-urlencoded",
+			responses:   []routeResponse{respHTML, {http.StatusForbidden, "The delete token is invalid.", "text/html", ""}, {http.StatusNotFound, "The dump was not found.", "text/html", ""}},
+		},
+		{
+			method:    http.MethodGet,
+			path:      "/update",
+			ui:        true,
+			handler:   (*app).routeUIUpdate,
+			summary:   "Renders the page where a dump can be updated.",
+			params:    []routeParam{{"id", "query", "string", "The id of the dump."}, paramToken},
+			responses: []routeResponse{respHTML},
+		},
+		{
+			method:      http.MethodPost,
+			path:        "/update",
+			ui:          true,
+			handler:     (*app).routePostUIUpdate,
+			summary:     "Updates a dump from the HTML UI.",
+			requestBody: "application/x-www-form-urlencoded",
+			responses:   []routeResponse{respHTML, {http.StatusForbidden, "The delete token is invalid.", "text/html", ""}, {http.StatusNotFound, "The dump was not found.", "text/html", ""}},
+		},
+		{
+			method:    http.MethodOptions,
+			path:      "/tus/",
+			handler:   (*app).routeTus,
+			summary:   "tus discovery, returns the supported tus version and extensions.",
+			responses: []routeResponse{{http.StatusNoContent, "The tus headers.", "", ""}},
+		},
+		{
+			method:    http.MethodPost,
+			path:      "/tus/",
+			handler:   (*app).routeTus,
+			limit:     limitUpload,
+			summary:   "Creates a resumable tus upload.",
+			params:    []routeParam{paramTusResumable, paramUploadLength, paramUploadMeta, paramOwnerKey},
+			responses: []routeResponse{{http.StatusCreated, "The upload was created, the URL is returned in the Location header.", "", ""}, {http.StatusBadRequest, "The upload length or metadata is invalid.", "text/plain", ""}, {http.StatusConflict, "The slug is already taken.", "text/plain", ""}, {http.StatusRequestEntityTooLarge, "The upload is too large.", "text/plain", ""}, respTusPrecondition, respTusInternalError, respTextTooMany},
+		},
+		{
+			method:    http.MethodHead,
+			path:      "/tus/{id}",
+			handler:   (*app).routeTus,
+			summary:   "Returns the offset of a tus upload.",
+			params:    []routeParam{paramTusResumable},
+			responses: []routeResponse{{http.StatusOK, "The offset is returned in the Upload-Offset header.", "", ""}, respTusNotFound, respTusPrecondition},
+		},
+		{
+			method:      http.MethodPatch,
+			path:        "/tus/{id}",
+			handler:     (*app).routeTus,
+			limit:       limitUploadChunk,
+			summary:     "Uploads a chunk of a tus upload, the dump is created when the last chunk has been received.",
+			params:      []routeParam{paramTusResumable, paramUploadOffset},
+			requestBody: "application/offset+octet-stream",
+			responses:   []routeResponse{{http.StatusNoContent, "The chunk was stored, the dump URL and delete token are returned in the X-Dump-Url and X-Delete-Token headers when the upload is complete.", "", ""}, respTusNotFound, {http.StatusConflict, "The offset doesn't match the offset of the upload, or the slug was taken before the upload was complete.", "text/plain", ""}, {http.StatusUnsupportedMediaType, "Invalid content type.", "text/plain", ""}, respTusPrecondition, respTusInternalError, respTextTooMany},
+		},
+		{
+			method:    http.MethodDelete,
+			path:      "/tus/{id}",
+			handler:   (*app).routeTus,
+			summary:   "Terminates a tus upload.",
+			params:    []routeParam{paramTusResumable},
+			responses: []routeResponse{{http.StatusNoContent, "The upload was terminated.", "", ""}, respTusNotFound, respTusPrecondition},
+		},
+		{
+			method:      http.MethodPost,
+			path:        "/api/v1/dumps",
+			handler:     (*app).routeAPICreate,
+			limit:       limitUpload,
+			summary:     "Creates a dump from the request body, basic auth credentials protects the dump.",
+			params:      []routeParam{paramDeleteAfter, paramExpiresAt, paramIdleExpiry, paramContentType, paramMaxDownloads, paramBurnAfterRead, paramEncrypt, paramSlug, paramOwnerKey},
+			requestBody: "application/octet-stream",
+			responses:   []routeResponse{{http.StatusCreated, "The created dump together with the delete token.", "application/json", "Dump"}, respAPIBadRequest, {http.StatusConflict, "The slug is already taken.", "application/json", "Error"}, respAPINotAcceptable, respAPITooLarge, respAPIInternalError, respAPITooMany},
+		},
+		{
+			method:    http.MethodGet,
+			path:      "/api/v1/dumps",
+			handler:   (*app).routeAPIList,
+			summary:   "Lists the dumps that was created with the owner key.",
+			params:    []routeParam{paramOwnerKey},
+			responses: []routeResponse{{http.StatusOK, "The dumps of the owner.", "application/json", "DumpList"}, respAPIBadRequest, respAPINotAcceptable, respAPIInternalError},
+		},
+		{
+			method:    http.MethodGet,
+			path:      "/api/v1/dumps/{id}",
+			handler:   (*app).routeAPIGet,
+			summary:   "Returns the metadata of a dump.",
+			responses: []routeResponse{{http.StatusOK, "The dump.", "application/json", "Dump"}, respAPIUnauthorized, respAPINotFound, respAPINotAcceptable, respAPIInternalError},
+		},
+		{
+			method:    http.MethodPatch,
+			path:      "/api/v1/dumps/{id}",
+			handler:   (*app).routeAPIUpdate,
+			summary:   "Updates the expiry, content type or protection of a dump.",
+			params:    []routeParam{paramDeleteToken, paramDeleteAfter, paramExpiresAt, paramIdleExpiry, paramContentType, paramUsername, paramPassword, paramUnprotect},
+			responses: []routeResponse{{http.StatusOK, "The updated dump.", "application/json", "Dump"}, respAPIBadRequest, respAPIForbidden, respAPINotFound, respAPINotAcceptable, respAPIInternalError},
+		},
+		{
+			method:    http.MethodDelete,
+			path:      "/api/v1/dumps/{id}",
+			handler:   (*app).routeAPIDelete,
+			summary:   "Deletes a dump.",
+			params:    []routeParam{paramDeleteToken},
+			responses: []routeResponse{{http.StatusNoContent, "The dump was deleted.", "", ""}, respAPIForbidden, respAPINotFound, respAPINotAcceptable, respAPIInternalError},
+		},
+		{
+			method:    http.MethodGet,
+			path:      "/api/v1/dumps/{id}/content",
+			handler:   (*app).routeAPIContent,
+			limit:     limitDownload,
+			summary:   "Downloads the contents of a dump, range and conditional requests are supported.",
+			params:    []routeParam{paramSaveAs},
+			responses: []routeResponse{respContentOK, respContentPartial, respContentNotModified, respAPIUnauthorized, respAPINotFound, respAPIInternalError, respAPITooMany},
+		},
+		{
+			method:    http.MethodHead,
+			path:      "/api/v1/dumps/{id}/content",
+			handler:   (*app).routeAPIContent,
+			limit:     limitDownload,
+			summary:   "Returns the headers of the contents of a dump.",
+			responses: []routeResponse{respContentOK, respContentNotModified, respAPIUnauthorized, respAPINotFound, respAPIInternalError, respAPITooMany},
+		},
+		{
+			method:    http.MethodGet,
+			path:      "/s/{slug}",
+			handler:   (*app).routeGet,
+			limit:     limitDownload,
+			summary:   "Downloads the contents of a dump by its slug, the same as GET /{id}.",
+			params:    []routeParam{paramSaveAs, paramInfo},
+			responses: []routeResponse{respContentOK, respContentPartial, respContentNotModified, {http.StatusOK, "Information about the dump when info is given, JSON is returned if the client accepts it.", "application/json", "Info"}, respTextUnauthorized, respTextNotFound, respTextInternalError, respTextTooMany},
+		},
+		{
+			method:    http.MethodHead,
+			path:      "/s/{slug}",
+			handler:   (*app).routeGet,
+			limit:     limitDownload,
+			summary:   "Returns the headers of the contents of a dump by its slug.",
+			responses: []routeResponse{respContentOK, respContentNotModified, respTextUnauthorized, respTextNotFound, respTextInternalError, respTextTooMany},
+		},
+		{
+			method:    http.MethodPatch,
+			path:      "/s/{slug}",
+			handler:   (*app).routePatch,
+			summary:   "Updates a dump by its slug, the same as PATCH /{id}.",
+			params:    []routeParam{paramDeleteToken, paramToken, paramDeleteAfter, paramExpiresAt, paramIdleExpiry, paramContentType, paramUsername, paramPassword, paramUnprotect},
+			responses: []routeResponse{{http.StatusOK, "The dump was updated.", "text/plain", ""}, respTextBadRequest, respTextForbidden, respTextNotFound, respTextInternalError},
+		},
+		{
+			method:    http.MethodDelete,
+			path:      "/s/{slug}",
+			handler:   (*app).routeDelete,
+			summary:   "Deletes a dump by its slug.",
+			params:    []routeParam{paramDeleteToken, paramToken},
+			responses: []routeResponse{{http.StatusOK, "The dump was deleted.", "text/plain", ""}, respTextForbidden, respTextNotFound, respTextInternalError},
+		},
+		{
+			method:    http.MethodGet,
+			path:      "/{id}",
+			handler:   (*app).routeGet,
+			limit:     limitDownload,
+			summary:   "Downloads the contents of a dump, range and conditional requests are supported.",
+			params:    []routeParam{paramSaveAs, paramInfo},
+			responses: []routeResponse{respContentOK, respContentPartial, respContentNotModified, {http.StatusOK, "Information about the dump when info is given, JSON is returned if the client accepts it.", "application/json", "Info"}, respTextUnauthorized, respTextNotFound, respTextInternalError, respTextTooMany},
+		},
+		{
+			method:    http.MethodHead,
+			path:      "/{id}",
+			handler:   (*app).routeGet,
+			limit:     limitDownload,
+			summary:   "Returns the headers of the contents of a dump.",
+			responses: []routeResponse{respContentOK, respContentNotModified, respTextUnauthorized, respTextNotFound, respTextInternalError, respTextTooMany},
+		},
+		{
+			method:    http.MethodPatch,
+			path:      "/{id}",
+			handler:   (*app).routePatch,
+			summary:   "Updates the expiry, content type or protection of a dump, the values can also be given in a form encoded body.",
+			params:    []routeParam{paramDeleteToken, paramToken, paramDeleteAfter, paramExpiresAt, paramIdleExpiry, paramContentType, paramUsername, paramPassword, paramUnprotect},
+			responses: []routeResponse{{http.StatusOK, "The dump was updated.", "text/plain", ""}, respTextBadRequest, respTextForbidden, respTextNotFound, respTextInternalError},
+		},
+		{
+			method:    http.MethodDelete,
+			path:      "/{id}",
+			handler:   (*app).routeDelete,
+			summary:   "Deletes a dump.",
+			params:    []routeParam{paramDeleteToken, paramToken},
+			responses: []routeResponse{{http.StatusOK, "The dump was deleted.", "text/plain", ""}, respTextForbidden, respTextNotFound, respTextInternalError},
+		},
+	}
+}
+
+// routeParamKey is the context key of the path parameter of a route.
+type routeParamKey struct{}
+
+// pathParam returns the value of the {id} or {slug} path parameter of the
+// matched route.
+func pathParam(r *http.Request) string {
+	v, _ := r.Context().Value(routeParamKey{}).(string)
+	return v
+}
+
+// matchPath matches the path against the pattern of a route. A {name}
+// segment matches any non-empty segment and its value is returned.
+func matchPath(pattern, path string) (string, bool) {
+	ps := strings.Split(pattern, "/")
+	ss := strings.Split(path, "/")
+	if len(ps) != len(ss) {
+		return "", false
+	}
+
+	var param string
+	for i := range ps {
+		if strings.HasPrefix(ps[i], "{") && strings.HasSuffix(ps[i], "}") {
+			if ss[i] == "" {
+				return "", false
+			}
+			param = ss[i]
+			continue
+		}
+		if ps[i] != ss[i] {
+			return "", false
+		}
+	}
+
+	return param, true
+}
+
+// findRoute returns the first route that matches the method and path
+// together with the value of the path parameter. The methods of the routes
+// that matched the path are returned if no route matched the method.
+func (a *app) findRoute(method, path string) (*route, string, []string) {
+	var methods []string
+	for i := range routes {
+		rt := &routes[i]
+		if rt.ui && a.uiTpl == nil {
+			continue
+		}
+
+		param, ok := matchPath(rt.path, path)
+		if !ok {
+			continue
+		}
+		if rt.method == method {
+			return rt, param, nil
+		}
+		methods = append(methods, rt.method)
+	}
+
+	return nil, "", methods
+}
+
+// router handles all incoming requests and forwards them to the correct
+// location.
+func (a *app) router(w http.ResponseWriter, r *http.Request) {
+	// Set content type to text/plain for all responses.
+	w.Header().Set("Content-Type", "text/plain")
+
+	r = a.stripPathPrefix(r)
+	rt, param, methods := a.findRoute(r.Method, r.URL.Path)
+	if rt != nil {
+		if param != "" {
+			r = r.WithContext(context.WithValue(r.Context(), routeParamKey{}, param))
+		}
+		if rt.limit != limitNone && a.limits != nil {
+			a.serveRateLimited(rt, w, r)
+			return
+		}
+		rt.handler(a, w, r)
+		return
+	}
+
+	// The API and tus have their own error responses and preflight
+	// requests.
+	if strings.HasPrefix(r.URL.Path, apiPath) {
+		a.routeAPINotMatched(w, r, methods)
+		return
+	}
+	if strings.HasPrefix(r.URL.Path, tusPath) {
+		a.routeTus(w, r)
+		return
+	}
+
+	if len(methods) > 0 {
+		w.Header().Set("Allow", strings.Join(methods, ", "))
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte("method not allowed\r\n"))
+		return
+	}
+	notFound(w)
+}
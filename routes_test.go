@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// newRouteTestApp returns an app with the UI enabled and without a
+// database, none of the tests in this file reaches the database.
+func newRouteTestApp(t *testing.T) *app {
+	tpl, err := template.New("ui").Parse(uiHTML)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &app{uiTpl: tpl, urlScheme: "http"}
+}
+
+// samplePath returns a path that matches the path of the route.
+func samplePath(rt *route) string {
+	return strings.NewReplacer("{id}", "Kx3bL0m9QaT", "{slug}", "oncall-checklist").Replace(rt.path)
+}
+
+func TestRouteTable(t *testing.T) {
+	a := newRouteTestApp(t)
+
+	seen := map[string]bool{}
+	for i := range routes {
+		rt := &routes[i]
+		name := rt.method + " " + rt.path
+
+		if seen[name] {
+			t.Errorf("%s: route is defined more than once", name)
+		}
+		seen[name] = true
+
+		if rt.handler == nil {
+			t.Errorf("%s: route has no handler", name)
+		}
+		if rt.summary == "" {
+			t.Errorf("%s: route has no summary", name)
+		}
+		if len(rt.responses) == 0 {
+			t.Errorf("%s: route has no responses", name)
+		}
+		for _, p := range rt.params {
+			if p.in != "query" && p.in != "header" {
+				t.Errorf("%s: parameter %s is in %q", name, p.name, p.in)
+			}
+		}
+		for _, res := range rt.responses {
+			if res.schema == "" {
+				continue
+			}
+			if _, ok := openAPISchemas[res.schema]; !ok && res.schema != "Error" {
+				t.Errorf("%s: unknown schema %s", name, res.schema)
+			}
+		}
+
+		// Make sure that the route isn't shadowed by a route that
+		// comes before it in the table.
+		found, param, _ := a.findRoute(rt.method, samplePath(rt))
+		if found != rt {
+			t.Errorf("%s: request is routed to %v", name, found)
+			continue
+		}
+		if strings.Contains(rt.path, "{id}") && param != "Kx3bL0m9QaT" ||
+			strings.Contains(rt.path, "{slug}") && param != "oncall-checklist" {
+			t.Errorf("%s: got path parameter %q", name, param)
+		}
+	}
+}
+
+func TestRouteTableWithoutUI(t *testing.T) {
+	a := &app{urlScheme: "http"}
+
+	for i := range routes {
+		rt := &routes[i]
+		found, _, _ := a.findRoute(rt.method, samplePath(rt))
+		if rt.ui && found == rt {
+			t.Errorf("%s %s: UI route is routed without the UI", rt.method, rt.path)
+		}
+		if !rt.ui && found != rt {
+			t.Errorf("%s %s: route is not routed without the UI", rt.method, rt.path)
+		}
+	}
+}
+
+func TestRouterMethodNotAllowed(t *testing.T) {
+	a := newRouteTestApp(t)
+
+	tests := []struct {
+		method string
+		path   string
+		status int
+		allow  string
+	}{
+		{http.MethodPut, "/Kx3bL0m9QaT", http.StatusMethodNotAllowed, "GET, HEAD, PATCH, DELETE"},
+		{http.MethodPut, "/s/oncall-checklist", http.StatusMethodNotAllowed, "GET, HEAD, PATCH, DELETE"},
+		{http.MethodPut, "/api/v1/dumps", http.StatusMethodNotAllowed, "POST, GET"},
+		{http.MethodGet, "/api/v1/foo", http.StatusNotFound, ""},
+		{http.MethodGet, "/foo/bar", http.StatusNotFound, ""},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		a.router(w, httptest.NewRequest(tt.method, tt.path, nil))
+		if w.Code != tt.status {
+			t.Errorf("%s %s: got status %d, want %d", tt.method, tt.path, w.Code, tt.status)
+		}
+		if allow := w.Header().Get("Allow"); allow != tt.allow {
+			t.Errorf("%s %s: got Allow %q, want %q", tt.method, tt.path, allow, tt.allow)
+		}
+	}
+}
+
+func TestOpenAPIDocument(t *testing.T) {
+	a := newRouteTestApp(t)
+
+	w := httptest.NewRecorder()
+	a.router(w, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d", w.Code)
+	}
+
+	var doc struct {
+		OpenAPI string `json:"openapi"`
+		Paths   map[string]map[string]struct {
+			OperationID string                     `json:"operationId"`
+			Responses   map[string]json.RawMessage `json:"responses"`
+		} `json:"paths"`
+		Components struct {
+			Schemas map[string]json.RawMessage `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("invalid json: %v", err)
+	}
+	if !strings.HasPrefix(doc.OpenAPI, "3.") {
+		t.Errorf("got openapi version %q", doc.OpenAPI)
+	}
+
+	// Every route has an operation with all of its responses, and there
+	// are no operations that doesn't have a route.
+	operations := 0
+	operationIDs := map[string]bool{}
+	for _, item := range doc.Paths {
+		for _, op := range item {
+			operations++
+			if operationIDs[op.OperationID] {
+				t.Errorf("duplicate operation id %s", op.OperationID)
+			}
+			operationIDs[op.OperationID] = true
+		}
+	}
+	if operations != len(routes) {
+		t.Errorf("got %d operations, want %d", operations, len(routes))
+	}
+
+	for _, rt := range routes {
+		op, ok := doc.Paths[rt.path][strings.ToLower(rt.method)]
+		if !ok {
+			t.Errorf("%s %s: missing in the document", rt.method, rt.path)
+			continue
+		}
+		for _, res := range rt.responses {
+			if _, ok := op.Responses[strconv.Itoa(res.status)]; !ok {
+				t.Errorf("%s %s: missing response %d", rt.method, rt.path, res.status)
+			}
+		}
+	}
+
+	// All referenced schemas must exist.
+	for _, ref := range strings.Split(w.Body.String(), `"$ref":"#/components/schemas/`)[1:] {
+		name := ref[:strings.Index(ref, `"`)]
+		if _, ok := doc.Components.Schemas[name]; !ok {
+			t.Errorf("missing schema %s", name)
+		}
+	}
+
+	// All API error codes are documented.
+	for _, e := range apiErrors {
+		if !strings.Contains(string(doc.Components.Schemas["Error"]), `"`+e.Code+`"`) {
+			t.Errorf("error code %s is not documented", e.Code)
+		}
+	}
+}
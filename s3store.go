@@ -0,0 +1,407 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// s3Store is a blob store that keeps the blobs in a bucket on a S3 compatible
+// object store. Objects are addressed with path style URLs, which makes it
+// possible to use the store with MinIO and similar services as well.
+type s3Store struct {
+	endpoint  *url.URL
+	bucket    string
+	region    string
+	accessKey string
+	secretKey string
+	prefix    string
+	client    *http.Client
+}
+
+// newS3Store returns a new S3 blob store.
+func newS3Store(endpoint, bucket, region, accessKey, secretKey, prefix string) (*s3Store, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse s3 endpoint: %v", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("s3 endpoint must be a http or https url")
+	}
+	if bucket == "" {
+		return nil, fmt.Errorf("s3 bucket is required")
+	}
+
+	return &s3Store{
+		endpoint:  u,
+		bucket:    bucket,
+		region:    region,
+		accessKey: accessKey,
+		secretKey: secretKey,
+		prefix:    prefix,
+		client:    &http.Client{},
+	}, nil
+}
+
+// s3Error holds the error document that is returned by the object store.
+type s3Error struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+// newS3Error reads the error document from the response.
+func newS3Error(res *http.Response) error {
+	var e s3Error
+	body, _ := ioutil.ReadAll(io.LimitReader(res.Body, 64*1024))
+	if err := xml.Unmarshal(body, &e); err != nil || e.Code == "" {
+		return fmt.Errorf("s3 request failed with status %d", res.StatusCode)
+	}
+
+	return fmt.Errorf("s3 request failed with status %d: %s: %s", res.StatusCode, e.Code, e.Message)
+}
+
+// do creates, signs and sends a request to the object store. The key is
+// expected to be the full object key, an empty key addresses the bucket.
+func (s *s3Store) do(method, key string, query url.Values, body io.Reader, size int64) (*http.Response, error) {
+	path := strings.TrimSuffix(s.endpoint.EscapedPath(), "/") + "/" + awsEscape(s.bucket, true)
+	if key != "" {
+		path += "/" + awsEscape(key, false)
+	}
+
+	u := *s.endpoint
+	u.RawPath = path
+	u.Path, _ = url.PathUnescape(path)
+	u.RawQuery = awsCanonicalQuery(query)
+
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	s.sign(req, time.Now().UTC())
+
+	return s.client.Do(req)
+}
+
+// sign adds an AWS signature version 4 authorization header to the request.
+// The payload is never signed, which means that we don't have to read the
+// body twice.
+func (s *s3Store) sign(req *http.Request, t time.Time) {
+	amzDate := t.Format("20060102T150405Z")
+	date := t.Format("20060102")
+	payloadHash := "UNSIGNED-PAYLOAD"
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalHeaders := "host:" + req.URL.Host + "\n" +
+		"x-amz-content-sha256:" + payloadHash + "\n" +
+		"x-amz-date:" + amzDate + "\n"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.region + "/s3/aws4_request"
+	h := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(h[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), date)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey,
+		scope,
+		signedHeaders,
+		signature,
+	))
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of the data with the given key.
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// awsEscape escapes the string according to the rules for AWS signature
+// version 4, only the unreserved characters from RFC 3986 are left as is.
+func awsEscape(s string, escapeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !escapeSlash) {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+// awsCanonicalQuery returns the query string sorted and escaped the way AWS
+// signature version 4 expects it to be.
+func awsCanonicalQuery(query url.Values) string {
+	var keys []string
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, k := range keys {
+		for _, v := range query[k] {
+			parts = append(parts, awsEscape(k, true)+"="+awsEscape(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// Put uploads the contents of the reader to the object store. The object
+// store needs to know the size of the object in advance, readers that
+// doesn't expose their length are spooled to a temporary file first.
+func (s *s3Store) Put(name string, r io.Reader) error {
+	var size int64
+	switch v := r.(type) {
+	case *bytes.Reader:
+		size = int64(v.Len())
+	case *bytes.Buffer:
+		size = int64(v.Len())
+	case *strings.Reader:
+		size = int64(v.Len())
+	default:
+		w, err := s.Create()
+		if err != nil {
+			return err
+		}
+		defer w.Abort()
+
+		if _, err = io.Copy(w, r); err != nil {
+			return err
+		}
+		return w.Commit(name)
+	}
+
+	res, err := s.do(http.MethodPut, s.prefix+name, nil, r, size)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return newS3Error(res)
+	}
+
+	return nil
+}
+
+// Get returns the body of the object, the body is streamed from the object
+// store while it is read.
+func (s *s3Store) Get(name string) (io.ReadCloser, error) {
+	res, err := s.do(http.MethodGet, s.prefix+name, nil, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode == http.StatusNotFound {
+		res.Body.Close()
+		return nil, errBlobNotFound
+	}
+	if res.StatusCode != http.StatusOK {
+		defer res.Body.Close()
+		return nil, newS3Error(res)
+	}
+
+	return res.Body, nil
+}
+
+// Stat returns information about the object.
+func (s *s3Store) Stat(name string) (*blobInfo, error) {
+	res, err := s.do(http.MethodHead, s.prefix+name, nil, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return nil, errBlobNotFound
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("s3 request failed with status %d", res.StatusCode)
+	}
+
+	size, err := strconv.ParseInt(res.Header.Get("Content-Length"), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid content length from s3: %v", err)
+	}
+	modifiedAt, _ := http.ParseTime(res.Header.Get("Last-Modified"))
+
+	return &blobInfo{
+		name:       name,
+		size:       size,
+		modifiedAt: modifiedAt,
+	}, nil
+}
+
+// Delete removes the object from the object store.
+func (s *s3Store) Delete(name string) error {
+	res, err := s.do(http.MethodDelete, s.prefix+name, nil, nil, 0)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return errBlobNotFound
+	}
+	if res.StatusCode != http.StatusNoContent && res.StatusCode != http.StatusOK {
+		return newS3Error(res)
+	}
+
+	return nil
+}
+
+// s3ListResult holds the parts of the ListObjectsV2 response that we are
+// interested in.
+type s3ListResult struct {
+	Contents []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// List returns the names of the objects that starts with the given prefix,
+// the listing is paginated until all objects have been fetched.
+func (s *s3Store) List(prefix string) ([]string, error) {
+	var names []string
+	var token string
+
+	for {
+		query := url.Values{}
+		query.Set("list-type", "2")
+		query.Set("prefix", s.prefix+prefix)
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		res, err := s.do(http.MethodGet, "", query, nil, 0)
+		if err != nil {
+			return nil, err
+		}
+		if res.StatusCode != http.StatusOK {
+			err = newS3Error(res)
+			res.Body.Close()
+			return nil, err
+		}
+
+		var result s3ListResult
+		err = xml.NewDecoder(res.Body).Decode(&result)
+		res.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode s3 list response: %v", err)
+		}
+
+		for _, c := range result.Contents {
+			names = append(names, strings.TrimPrefix(c.Key, s.prefix))
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			break
+		}
+		token = result.NextContinuationToken
+	}
+
+	return names, nil
+}
+
+// Create creates a new temporary blob. The object store needs to know the
+// size of the object before it is uploaded, so the blob is spooled to a local
+// temporary file and uploaded when it is committed. A PUT is atomic on S3, so
+// the object is never visible in a partially written state.
+func (s *s3Store) Create() (blobWriter, error) {
+	f, err := ioutil.TempFile("", "dumpinen-")
+	if err != nil {
+		return nil, err
+	}
+
+	return &s3BlobWriter{s, f, false}, nil
+}
+
+// s3BlobWriter is a local temporary file that is uploaded to the object store
+// on commit.
+type s3BlobWriter struct {
+	store *s3Store
+	f     *os.File
+	done  bool
+}
+
+// Write writes to the local temporary file.
+func (w *s3BlobWriter) Write(p []byte) (int, error) {
+	return w.f.Write(p)
+}
+
+// Commit uploads the local temporary file to the object store.
+func (w *s3BlobWriter) Commit(name string) error {
+	if w.done {
+		return os.ErrClosed
+	}
+	w.done = true
+	defer os.Remove(w.f.Name())
+	defer w.f.Close()
+
+	size, err := w.f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err = w.f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	res, err := w.store.do(http.MethodPut, w.store.prefix+name, nil, w.f, size)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return newS3Error(res)
+	}
+
+	return nil
+}
+
+// Abort closes and removes the local temporary file.
+func (w *s3BlobWriter) Abort() error {
+	if w.done {
+		return nil
+	}
+	w.done = true
+
+	w.f.Close()
+	return os.Remove(w.f.Name())
+}
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+// fakeS3 is a minimal S3 compatible object store that verifies the
+// signature of every request. The listing is paginated with a small page
+// size to exercise the continuation tokens.
+type fakeS3 struct {
+	t         *testing.T
+	bucket    string
+	region    string
+	accessKey string
+	secretKey string
+	pageSize  int
+
+	// badSignatures allows requests with invalid signatures to be
+	// refused without failing the test.
+	badSignatures bool
+
+	mu        sync.Mutex
+	objects   map[string][]byte
+	listCalls int
+}
+
+func newFakeS3(t *testing.T) (*fakeS3, *httptest.Server) {
+	f := &fakeS3{
+		t:         t,
+		bucket:    "dumps",
+		region:    "eu-north-1",
+		accessKey: "AKIDEXAMPLE",
+		secretKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+		pageSize:  2,
+		objects:   map[string][]byte{},
+	}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	return f, srv
+}
+
+// verify checks the AWS signature version 4 of the request, the canonical
+// request is built from what was received on the wire.
+func (f *fakeS3) verify(r *http.Request) error {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 ") {
+		return fmt.Errorf("unexpected authorization %q", auth)
+	}
+	fields := map[string]string{}
+	for _, kv := range strings.Split(strings.TrimPrefix(auth, "AWS4-HMAC-SHA256 "), ", ") {
+		p := strings.SplitN(kv, "=", 2)
+		if len(p) != 2 {
+			return fmt.Errorf("invalid authorization field %q", kv)
+		}
+		fields[p[0]] = p[1]
+	}
+
+	amzDate := r.Header.Get("X-Amz-Date")
+	t, err := time.Parse("20060102T150405Z", amzDate)
+	if err != nil || time.Since(t) > 15*time.Minute || time.Until(t) > 15*time.Minute {
+		return fmt.Errorf("invalid x-amz-date %q", amzDate)
+	}
+	scope := amzDate[:8] + "/" + f.region + "/s3/aws4_request"
+	if fields["Credential"] != f.accessKey+"/"+scope {
+		return fmt.Errorf("unexpected credential %q", fields["Credential"])
+	}
+	if fields["SignedHeaders"] != "host;x-amz-content-sha256;x-amz-date" {
+		return fmt.Errorf("unexpected signed headers %q", fields["SignedHeaders"])
+	}
+
+	var query []string
+	if r.URL.RawQuery != "" {
+		query = strings.Split(r.URL.RawQuery, "&")
+	}
+	sort.Strings(query)
+
+	canonical := strings.Join([]string{
+		r.Method,
+		r.URL.EscapedPath(),
+		strings.Join(query, "&"),
+		"host:" + r.Host + "\n" +
+			"x-amz-content-sha256:" + r.Header.Get("X-Amz-Content-Sha256") + "\n" +
+			"x-amz-date:" + amzDate + "\n",
+		fields["SignedHeaders"],
+		r.Header.Get("X-Amz-Content-Sha256"),
+	}, "\n")
+	h := sha256.Sum256([]byte(canonical))
+	sts := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(h[:])
+
+	key := []byte("AWS4" + f.secretKey)
+	for _, s := range []string{amzDate[:8], f.region, "s3", "aws4_request", sts} {
+		m := hmac.New(sha256.New, key)
+		m.Write([]byte(s))
+		key = m.Sum(nil)
+	}
+	if !hmac.Equal([]byte(hex.EncodeToString(key)), []byte(fields["Signature"])) {
+		return fmt.Errorf("signature mismatch for %s %s", r.Method, r.URL)
+	}
+
+	return nil
+}
+
+// writeError writes a S3 error document.
+func (f *fakeS3) writeError(w http.ResponseWriter, status int, code string) {
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, code)
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := f.verify(r); err != nil {
+		if !f.badSignatures {
+			f.t.Errorf("fake s3: %v", err)
+		}
+		f.writeError(w, http.StatusForbidden, "SignatureDoesNotMatch")
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	if path == f.bucket && r.Method == http.MethodGet {
+		f.list(w, r)
+		return
+	}
+	if !strings.HasPrefix(path, f.bucket+"/") {
+		f.writeError(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+	key := strings.TrimPrefix(path, f.bucket+"/")
+
+	switch r.Method {
+	case http.MethodPut:
+		if r.ContentLength < 0 {
+			f.writeError(w, http.StatusLengthRequired, "MissingContentLength")
+			return
+		}
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil || int64(len(body)) != r.ContentLength {
+			f.writeError(w, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+		f.objects[key] = body
+	case http.MethodGet, http.MethodHead:
+		body, ok := f.objects[key]
+		if !ok {
+			f.writeError(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		if r.Method == http.MethodGet {
+			w.Write(body)
+		}
+	case http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		f.writeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+// list implements ListObjectsV2, the continuation token is the index of the
+// next key.
+func (f *fakeS3) list(w http.ResponseWriter, r *http.Request) {
+	f.listCalls++
+	q := r.URL.Query()
+	if q.Get("list-type") != "2" {
+		f.writeError(w, http.StatusBadRequest, "InvalidArgument")
+		return
+	}
+
+	var keys []string
+	for k := range f.objects {
+		if strings.HasPrefix(k, q.Get("prefix")) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	start := 0
+	if t := q.Get("continuation-token"); t != "" {
+		start, _ = strconv.Atoi(strings.TrimPrefix(t, "token-"))
+	}
+	end := start + f.pageSize
+	if end > len(keys) {
+		end = len(keys)
+	}
+
+	type content struct {
+		Key string `xml:"Key"`
+	}
+	res := struct {
+		XMLName               xml.Name  `xml:"ListBucketResult"`
+		Contents              []content `xml:"Contents"`
+		IsTruncated           bool      `xml:"IsTruncated"`
+		NextContinuationToken string    `xml:"NextContinuationToken,omitempty"`
+	}{IsTruncated: end < len(keys)}
+	for _, k := range keys[start:end] {
+		res.Contents = append(res.Contents, content{k})
+	}
+	if res.IsTruncated {
+		res.NextContinuationToken = fmt.Sprintf("token-%d", end)
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(res)
+}
+
+func TestS3Store(t *testing.T) {
+	f, srv := newFakeS3(t)
+
+	s, err := newS3Store(srv.URL, f.bucket, f.region, f.accessKey, f.secretKey, "p/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A reader with a known length is uploaded directly and other readers
+	// are spooled to a temporary file first.
+	if err = s.Put("a", strings.NewReader("foo")); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	if err = s.Put("b c+ü", iotest.OneByteReader(strings.NewReader("bar"))); err != nil {
+		t.Fatalf("put spooled: %v", err)
+	}
+	if string(f.objects["p/a"]) != "foo" || string(f.objects["p/b c+ü"]) != "bar" {
+		t.Fatalf("got objects %q", f.objects)
+	}
+
+	rc, err := s.Get("b c+ü")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	body, _ := ioutil.ReadAll(rc)
+	rc.Close()
+	if string(body) != "bar" {
+		t.Errorf("get: got %q", body)
+	}
+	if _, err = s.Get("missing"); err != errBlobNotFound {
+		t.Errorf("get missing: got %v", err)
+	}
+
+	bi, err := s.Stat("a")
+	if err != nil || bi.size != 3 || bi.name != "a" || bi.modifiedAt.IsZero() {
+		t.Errorf("stat: got %+v, %v", bi, err)
+	}
+	if _, err = s.Stat("missing"); err != errBlobNotFound {
+		t.Errorf("stat missing: got %v", err)
+	}
+
+	// An aborted blob is never uploaded.
+	w, err := s.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(w, "aborted")
+	if err = w.Abort(); err != nil {
+		t.Errorf("abort: %v", err)
+	}
+	w, err = s.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(w, "committed")
+	if err = w.Commit("c"); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+	if err = w.Abort(); err != nil {
+		t.Errorf("abort after commit: %v", err)
+	}
+	if len(f.objects) != 3 || string(f.objects["p/c"]) != "committed" {
+		t.Errorf("got objects %q", f.objects)
+	}
+
+	if err = s.Delete("c"); err != nil {
+		t.Errorf("delete: %v", err)
+	}
+	if _, ok := f.objects["p/c"]; ok {
+		t.Errorf("object wasn't deleted")
+	}
+}
+
+func TestS3StoreList(t *testing.T) {
+	f, srv := newFakeS3(t)
+
+	s, err := newS3Store(srv.URL, f.bucket, f.region, f.accessKey, f.secretKey, "p/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.objects["other/x1"] = []byte("x")
+	for _, name := range []string{"x1", "x2", "x3", "x4", "x5", "y1"} {
+		if err = s.Put(name, strings.NewReader(name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	names, err := s.List("x")
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if strings.Join(names, ",") != "x1,x2,x3,x4,x5" {
+		t.Errorf("got %v", names)
+	}
+	if f.listCalls != 3 {
+		t.Errorf("got %d list calls, expected the listing to be paginated", f.listCalls)
+	}
+
+	if names, err = s.List(""); err != nil || len(names) != 6 {
+		t.Errorf("list all: got %v, %v", names, err)
+	}
+}
+
+func TestS3StoreErrors(t *testing.T) {
+	f, srv := newFakeS3(t)
+
+	// A store with the wrong secret is refused by the object store, the
+	// error document is included in the error.
+	s, err := newS3Store(srv.URL, f.bucket, f.region, f.accessKey, "wrong", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.badSignatures = true
+	if err = s.Put("a", strings.NewReader("foo")); err == nil || !strings.Contains(err.Error(), "SignatureDoesNotMatch") {
+		t.Errorf("got %v", err)
+	}
+
+	for _, endpoint := range []string{"ftp://example.com", "://"} {
+		if _, err = newS3Store(endpoint, "b", "r", "a", "s", ""); err == nil {
+			t.Errorf("%q: expected an error", endpoint)
+		}
+	}
+	if _, err = newS3Store("http://example.com", "", "r", "a", "s", ""); err == nil {
+		t.Errorf("expected an error without a bucket")
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	// defaultSlugPattern is the pattern that the slugs must match, lower
+	// case words that are separated by dashes.
+	defaultSlugPattern = `^[a-z0-9]+(-[a-z0-9]+)*$`
+
+	// defaultReservedSlugs are reserved in addition to the paths of the
+	// routes, which are always reserved.
+	defaultReservedSlugs = "robots.txt,sitemap.xml,admin"
+
+	// minSlugLength and maxSlugLength are the bounds of the slug length.
+	minSlugLength = 3
+	maxSlugLength = 64
+
+	// slugPath is the path that the dumps are served at by their slugs,
+	// which keeps the slugs apart from the public ids.
+	slugPath = "/s/"
+)
+
+// slugPolicy holds the rules for the slugs, the human readable ids that can
+// be given instead of a random public id.
+type slugPolicy struct {
+	// pattern is the pattern that the slugs must match, nil means that
+	// slugs are disabled.
+	pattern *regexp.Regexp
+
+	// reserved contains the slugs that can't be used.
+	reserved map[string]bool
+}
+
+// newSlugPolicy returns the policy for the given flag values. The reserved
+// slugs are separated by commas and the first segments of the paths of the
+// routes are always reserved. An empty pattern disables slugs.
+func newSlugPolicy(pattern, reserved string) (*slugPolicy, error) {
+	p := &slugPolicy{reserved: map[string]bool{}}
+
+	if pattern != "" {
+		var err error
+		if p.pattern, err = regexp.Compile(pattern); err != nil {
+			return nil, fmt.Errorf("invalid slug pattern: %v", err)
+		}
+	}
+
+	for _, s := range strings.Split(reserved, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			p.reserved[s] = true
+		}
+	}
+	for _, rt := range routes {
+		s := strings.SplitN(strings.TrimPrefix(rt.path, "/"), "/", 2)[0]
+		if s != "" && !strings.HasPrefix(s, "{") {
+			p.reserved[s] = true
+		}
+	}
+
+	return p, nil
+}
+
+// isValid returns true if the slug matches the pattern and isn't reserved.
+// Only characters that don't need to be escaped in an URL path are allowed,
+// regardless of the pattern.
+func (p *slugPolicy) isValid(slug string) bool {
+	if p.pattern == nil || len(slug) < minSlugLength || len(slug) > maxSlugLength {
+		return false
+	}
+	if strings.HasPrefix(slug, ".") || p.reserved[slug] {
+		return false
+	}
+	for _, c := range slug {
+		if !strings.ContainsRune(urlSafeChars, c) && c != '.' {
+			return false
+		}
+	}
+
+	return p.pattern.MatchString(slug)
+}
+
+// htmlPattern returns the pattern for the pattern attribute of the slug input
+// of the UI, an empty string is returned if slugs are disabled. The attribute
+// must match the whole value, so the anchors of a pattern that is anchored
+// at both ends are removed and any other pattern is allowed to match
+// anywhere in the value, like the regexp does.
+func (p *slugPolicy) htmlPattern() string {
+	if p.pattern == nil {
+		return ""
+	}
+
+	s := p.pattern.String()
+	if strings.HasPrefix(s, "^") && strings.HasSuffix(s, "$") && !strings.HasSuffix(s, `\$`) && !strings.Contains(s, "|") {
+		return s[1 : len(s)-1]
+	}
+
+	return ".*(?:" + s + ").*"
+}
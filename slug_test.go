@@ -0,0 +1,67 @@
+package main
+
+import "testing"
+
+func TestSlugPolicy(t *testing.T) {
+	p, err := newSlugPolicy(defaultSlugPattern, "robots.txt, runbook")
+	if err != nil {
+		t.Fatalf("newSlugPolicy: %v", err)
+	}
+
+	for _, s := range []string{"oncall-checklist", "abc", "2021-postmortem"} {
+		if !p.isValid(s) {
+			t.Errorf("%q: expected it to be valid", s)
+		}
+	}
+	for _, s := range []string{"", "ab", "Oncall", "oncall-", "-oncall", "on--call", "on_call", "runbook", "robots.txt",
+		"text", "file", "about", "dump", "favicon.ico", "decrypt", "api", "tus", "openapi.json"} {
+		if p.isValid(s) {
+			t.Errorf("%q: expected it to be invalid", s)
+		}
+	}
+
+	// The pattern can't allow characters that needs to be escaped.
+	if p, err = newSlugPolicy(`^.+$`, ""); err != nil {
+		t.Fatalf("newSlugPolicy: %v", err)
+	}
+	for _, s := range []string{"runbook.md", "Run_Book"} {
+		if !p.isValid(s) {
+			t.Errorf("%q: expected it to be valid", s)
+		}
+	}
+	for _, s := range []string{"run/book", "run book", "..runbook", "runbook?"} {
+		if p.isValid(s) {
+			t.Errorf("%q: expected it to be invalid", s)
+		}
+	}
+
+	if p, err = newSlugPolicy("", ""); err != nil || p.isValid("oncall-checklist") {
+		t.Errorf("expected slugs to be disabled, got %v", err)
+	}
+	if _, err = newSlugPolicy("(", ""); err == nil {
+		t.Errorf("expected an error for an invalid pattern")
+	}
+}
+
+func TestSlugHTMLPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    string
+	}{
+		{defaultSlugPattern, "[a-z0-9]+(-[a-z0-9]+)*"},
+		{`^[a-z]+\$`, `.*(?:^[a-z]+\$).*`},
+		{`^[a-z]+$|^[0-9]+$`, `.*(?:^[a-z]+$|^[0-9]+$).*`},
+		{`[a-z]+`, `.*(?:[a-z]+).*`},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		p, err := newSlugPolicy(tt.pattern, "")
+		if err != nil {
+			t.Fatalf("newSlugPolicy: %v", err)
+		}
+		if got := p.htmlPattern(); got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.pattern, got, tt.want)
+		}
+	}
+}
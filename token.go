@@ -0,0 +1,34 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+)
+
+// newDeleteToken generates a new random delete token.
+func newDeleteToken() string {
+	b := make([]byte, 24)
+	io.ReadFull(rand.Reader, b)
+
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// hashToken returns the hex encoded SHA-256 hash of the token, the tokens are
+// random enough for a plain hash to be sufficient.
+func hashToken(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+// isValidToken checks, in constant time, if the token matches the given hash.
+func isValidToken(token string, hash *string) bool {
+	if token == "" || hash == nil {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(hashToken(token)), []byte(*hash)) == 1
+}
@@ -0,0 +1,560 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// tusVersion is the version of the tus protocol that we support.
+	tusVersion = "1.0.0"
+
+	// tusExtensions contains the tus extensions that we support.
+	tusExtensions = "creation,termination,expiration"
+
+	// tusPath is the path that the tus endpoint is mounted on.
+	tusPath = "/tus/"
+
+	// tusExpiry is the duration after the last activity that an
+	// unfinished upload is removed.
+	tusExpiry = 24 * time.Hour
+)
+
+// tusOptions contains the metadata keys that are parsed the same way as the
+// query parameters of a regular upload.
+var tusOptions = []string{"deleteAfter", "expiresAt", "expireAfterIdle", "maxDownloads", "burnAfterRead", "contentType", "slug"}
+
+// tusChunkPrefix returns the blob name prefix for the chunks of the upload.
+func tusChunkPrefix(uploadID string) string {
+	return "tus-" + uploadID + "-"
+}
+
+// tusChunkName returns the blob name for the chunk that starts at the given
+// offset, the offset is zero padded so that the chunks sorts in order.
+func tusChunkName(uploadID string, offset int64) string {
+	return fmt.Sprintf("%s%020d", tusChunkPrefix(uploadID), offset)
+}
+
+// tusMetadata parses the Upload-Metadata header, the values are base64
+// encoded.
+func tusMetadata(h string) (map[string]string, error) {
+	md := make(map[string]string)
+	for _, pair := range strings.Split(h, ",") {
+		kv := strings.Fields(pair)
+		if len(kv) == 0 {
+			continue
+		}
+		if len(kv) > 2 {
+			return nil, fmt.Errorf("invalid metadata pair %q", pair)
+		}
+
+		var v []byte
+		if len(kv) == 2 {
+			var err error
+			if v, err = base64.StdEncoding.DecodeString(kv[1]); err != nil {
+				return nil, fmt.Errorf("invalid metadata value for %s: %v", kv[0], err)
+			}
+		}
+		md[kv[0]] = string(v)
+	}
+	return md, nil
+}
+
+// tusError sets the status code and writes the message to the response
+// writer.
+func tusError(w http.ResponseWriter, status int, msg string) {
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "%s\r\n", msg)
+}
+
+// routeTus handles the requests to the tus endpoint.
+func (a *app) routeTus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Expose-Headers", "Location, Upload-Offset, Upload-Length, Upload-Expires, Tus-Resumable, Tus-Version, Tus-Extension, Tus-Max-Size, X-Dump-Url, X-Delete-Token")
+
+	// The OPTIONS request is used for discovery and is the only request
+	// that doesn't need to contain the Tus-Resumable header.
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Tus-Version", tusVersion)
+		w.Header().Set("Tus-Extension", tusExtensions)
+		w.Header().Set("Tus-Max-Size", strconv.FormatInt(a.maxFileSize, 10))
+		w.Header().Set("Access-Control-Allow-Methods", "POST, HEAD, PATCH, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, Upload-Length, Upload-Offset, Upload-Metadata, Tus-Resumable")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		tusError(w, http.StatusPreconditionFailed, "unsupported tus version")
+		return
+	}
+
+	uploadID := strings.TrimPrefix(r.URL.Path, tusPath)
+	switch {
+	case uploadID == "" && r.Method == http.MethodPost:
+		a.routeTusCreate(w, r)
+	case uploadID != "" && r.Method == http.MethodHead:
+		a.routeTusHead(w, r, uploadID)
+	case uploadID != "" && r.Method == http.MethodPatch:
+		a.routeTusPatch(w, r, uploadID)
+	case uploadID != "" && r.Method == http.MethodDelete:
+		a.routeTusDelete(w, r, uploadID)
+	default:
+		tusError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// getTusUpload fetches the upload from the database and writes a not found
+// response if the upload doesn't exist or has expired.
+func (a *app) getTusUpload(w http.ResponseWriter, uploadID string) *tusUpload {
+	if !isValidUUID(uploadID) {
+		notFound(w)
+		return nil
+	}
+
+	tu, err := a.db.getTusUploadByID(uploadID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			notFound(w)
+			return nil
+		}
+
+		log.Printf("getting upload from database error: %v\n", err)
+		internalServerError(w)
+		return nil
+	}
+
+	if time.Now().After(tu.expiresAt) {
+		notFound(w)
+		return nil
+	}
+
+	return tu
+}
+
+// setTusUploadHeaders sets the headers that describes the state of the
+// upload.
+func (a *app) setTusUploadHeaders(w http.ResponseWriter, r *http.Request, tu *tusUpload) {
+	w.Header().Set("Upload-Offset", strconv.FormatInt(tu.offset, 10))
+	w.Header().Set("Upload-Expires", tu.expiresAt.UTC().Format(http.TimeFormat))
+	if tu.publicID != nil {
+		w.Header().Set("X-Dump-Url", a.publicURL(r)+dumpPath(&dump{publicID: *tu.publicID, slug: tu.slug}))
+	}
+}
+
+// tusDeleteAfter returns the expiry of the dump from the stored delete_after
+// metadata of an upload. The expiry is resolved when the upload is created
+// and stored as a timestamp, uploads that were created before that stored
+// the deleteAfter duration, which is counted from when the upload finished.
+func (a *app) tusDeleteAfter(s *string) (*time.Time, error) {
+	if s == nil {
+		return nil, nil
+	}
+
+	if t, err := time.Parse(time.RFC3339Nano, *s); err == nil {
+		return &t, nil
+	}
+
+	return a.expiry.resolve(url.Values{"deleteAfter": {*s}}, time.Now())
+}
+
+// routeTusCreate handles the creation of a new upload. The metadata, the
+// owner key and the basic auth credentials are treated the same way as for
+// a regular POST request, except for encrypt which isn't supported since
+// the password isn't kept until the upload has finished.
+func (a *app) routeTusCreate(w http.ResponseWriter, r *http.Request) {
+	log.Printf("tus create request from %s\n", a.clientIP(r))
+
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		tusError(w, http.StatusBadRequest, "error: invalid Upload-Length")
+		return
+	}
+	if length == 0 {
+		tusError(w, http.StatusBadRequest, "empty request payload")
+		return
+	}
+	if length > a.maxFileSize {
+		tusError(w, http.StatusRequestEntityTooLarge, "dump rejected, request body too large")
+		return
+	}
+
+	md, err := tusMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		log.Printf("error when parsing upload metadata: %v\n", err)
+		tusError(w, http.StatusBadRequest, "error: invalid Upload-Metadata")
+		return
+	}
+
+	tu := &tusUpload{
+		id:        newUUID(),
+		length:    length,
+		ipAddress: a.clientIP(r),
+		expiresAt: time.Now().Add(tusExpiry),
+	}
+
+	if e := md["encrypt"]; e != "" && e != "0" && e != "false" {
+		tusError(w, http.StatusBadRequest, "error: encrypt isn't supported for tus uploads")
+		return
+	}
+
+	// Most tus clients sends the content type as filetype, so we'll
+	// accept that as well.
+	v := url.Values{}
+	for _, k := range tusOptions {
+		if s, ok := md[k]; ok {
+			v.Set(k, s)
+		}
+	}
+	if _, ok := v["contentType"]; !ok && md["filetype"] != "" {
+		v.Set("contentType", md["filetype"])
+	}
+
+	// The deleteAfter or expiresAt value is resolved now and stored as a
+	// timestamp, it is applied when the upload has finished. An expiresAt
+	// timestamp that passes while the chunks are received would otherwise
+	// make it impossible to finish the upload.
+	var o dumpOptions
+	if err = a.parseDumpValues(v, &o); err != nil {
+		log.Printf("error when parsing upload metadata: %v\n", err)
+		tusError(w, http.StatusBadRequest, fmt.Sprintf("error: %v", err))
+		return
+	}
+	if o.ownerKeyHash, err = ownerKeyHash(r); err != nil {
+		tusError(w, http.StatusBadRequest, fmt.Sprintf("error: %v", err))
+		return
+	}
+
+	// The slug is checked again when the dump is stored, but a slug that
+	// is already taken shouldn't be found out after the whole upload.
+	if o.slug != nil {
+		if _, err = a.db.getDumpBySlug(*o.slug); err == nil {
+			tusError(w, http.StatusConflict, fmt.Sprintf("error: %v", errDuplicateSlug))
+			return
+		} else if err != sql.ErrNoRows {
+			log.Printf("getting dump by slug error: %v\n", err)
+			internalServerError(w)
+			return
+		}
+	}
+
+	if o.deleteAfter != nil {
+		da := o.deleteAfter.UTC().Format(time.RFC3339Nano)
+		tu.deleteAfter = &da
+	}
+	if o.contentType != "" {
+		tu.contentType = &o.contentType
+	}
+	tu.idleExpiry = o.idleExpiry
+	tu.maxDownloads = o.maxDownloads
+	tu.slug = o.slug
+	tu.ownerKeyHash = o.ownerKeyHash
+
+	// If the request contains basic auth credentials we'll use them to
+	// protect the uploaded file.
+	if u, p, ok := r.BasicAuth(); ok {
+		hash, err := hashPassword(u, p)
+		if err != nil {
+			log.Printf("error when hashing password, %v\n", err)
+			internalServerError(w)
+			return
+		}
+		tu.passwordHash = &hash
+	}
+
+	if err = a.db.insertTusUpload(tu); err != nil {
+		log.Printf("insert upload error: %v\n", err)
+		internalServerError(w)
+		return
+	}
+
+	log.Printf("upload created with id %s\n", tu.id)
+	a.setTusUploadHeaders(w, r, tu)
+	w.Header().Set("Location", a.publicURL(r)+tusPath+tu.id)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// routeTusHead returns the offset of the upload.
+func (a *app) routeTusHead(w http.ResponseWriter, r *http.Request, uploadID string) {
+	tu := a.getTusUpload(w, uploadID)
+	if tu == nil {
+		return
+	}
+
+	a.setTusUploadHeaders(w, r, tu)
+	w.Header().Set("Upload-Length", strconv.FormatInt(tu.length, 10))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+}
+
+// routeTusPatch stores a chunk of the upload. Each chunk is stored as a
+// separate blob, the chunks are assembled into a dump when the last chunk
+// has been received.
+func (a *app) routeTusPatch(w http.ResponseWriter, r *http.Request, uploadID string) {
+	log.Printf("tus patch request from %s, to %s\n", a.clientIP(r), uploadID)
+
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		tusError(w, http.StatusUnsupportedMediaType, "error: invalid Content-Type")
+		return
+	}
+
+	tu := a.getTusUpload(w, uploadID)
+	if tu == nil {
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		tusError(w, http.StatusBadRequest, "error: invalid Upload-Offset")
+		return
+	}
+	if offset != tu.offset {
+		tusError(w, http.StatusConflict, "error: Upload-Offset doesn't match the current offset")
+		return
+	}
+
+	// Store the chunk, the size of the chunk can't exceed the size that
+	// remains of the upload.
+	if offset < tu.length {
+		r.Body = http.MaxBytesReader(w, r.Body, tu.length-offset)
+		up, err := a.newUpload(r.Body, a.recipient)
+		if err != nil && err != errEmptyUpload {
+			if isRequestTooLarge(err) {
+				tusError(w, http.StatusRequestEntityTooLarge, "error: chunk exceeds Upload-Length")
+				return
+			}
+			log.Printf("error on chunk: %v\n", err)
+			internalServerError(w)
+			return
+		}
+
+		if err == nil {
+			defer up.blob.Abort()
+
+			// The offset is only updated if no one else has
+			// updated it while we were receiving the chunk. It's
+			// updated before the chunk is committed, which makes
+			// sure that only the request that won the offset
+			// writes the chunk at it.
+			expiresAt := time.Now().Add(tusExpiry)
+			ok, err := a.db.updateTusUploadOffset(tu.id, offset, offset+up.size, expiresAt)
+			if err != nil {
+				log.Printf("update upload offset error: %v\n", err)
+				internalServerError(w)
+				return
+			}
+			if !ok {
+				tusError(w, http.StatusConflict, "error: Upload-Offset doesn't match the current offset")
+				return
+			}
+
+			// The offset is given back if the chunk can't be
+			// written, so that the client can send it again.
+			if err = up.blob.Commit(tusChunkName(tu.id, offset)); err != nil {
+				log.Printf("write chunk error: %v\n", err)
+				if _, err = a.db.updateTusUploadOffset(tu.id, offset+up.size, offset, expiresAt); err != nil {
+					log.Printf("reset upload offset error: %v\n", err)
+				}
+				internalServerError(w)
+				return
+			}
+			tu.offset += up.size
+			tu.expiresAt = expiresAt
+		}
+	}
+
+	// Assemble the dump if this was the last chunk, the delete token is
+	// only returned in the response to the last chunk.
+	if tu.offset == tu.length && tu.publicID == nil {
+		publicID, deleteToken, err := a.completeTusUpload(tu)
+		if err == errDuplicateSlug {
+			tusError(w, http.StatusConflict, fmt.Sprintf("error: %v", err))
+			return
+		}
+		if err != nil {
+			log.Printf("complete upload error: %v\n", err)
+			internalServerError(w)
+			return
+		}
+		tu.publicID = &publicID
+		w.Header().Set("X-Delete-Token", deleteToken)
+		log.Printf("dump stored with public id at %s\n", publicID)
+	}
+
+	a.setTusUploadHeaders(w, r, tu)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// routeTusDelete terminates the upload and removes the received chunks.
+func (a *app) routeTusDelete(w http.ResponseWriter, r *http.Request, uploadID string) {
+	log.Printf("tus delete request from %s, to %s\n", a.clientIP(r), uploadID)
+
+	tu := a.getTusUpload(w, uploadID)
+	if tu == nil {
+		return
+	}
+
+	if err := a.deleteTusUpload(tu.id); err != nil {
+		log.Printf("delete upload error: %v\n", err)
+		internalServerError(w)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deleteTusUpload removes the chunks and the database entry of the upload.
+func (a *app) deleteTusUpload(uploadID string) error {
+	names, err := a.store.List(tusChunkPrefix(uploadID))
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		if err = a.store.Delete(name); err != nil && err != errBlobNotFound {
+			return err
+		}
+	}
+
+	return a.db.deleteTusUpload(uploadID)
+}
+
+// completeTusUpload assembles the chunks of the upload into a new dump and
+// returns the public id and the delete token of the dump.
+func (a *app) completeTusUpload(tu *tusUpload) (string, string, error) {
+	names, err := a.store.List(tusChunkPrefix(tu.id))
+	if err != nil {
+		return "", "", err
+	}
+	sort.Strings(names)
+
+	chunks := &tusChunkReader{a: a, names: names}
+	defer chunks.Close()
+
+	up, err := a.newUpload(chunks, a.recipient)
+	if err != nil {
+		return "", "", err
+	}
+	defer up.blob.Abort()
+
+	if up.size != tu.length {
+		return "", "", fmt.Errorf("assembled upload is %d bytes, expected %d", up.size, tu.length)
+	}
+
+	// The expiry was resolved when the upload was created.
+	deleteAfter, err := a.tusDeleteAfter(tu.deleteAfter)
+	if err != nil {
+		return "", "", err
+	}
+
+	var contentType string
+	if tu.contentType != nil {
+		contentType = *tu.contentType
+	} else {
+		contentType = http.DetectContentType(up.head)
+	}
+
+	filesystemID := newUUID()
+	deleteToken := newDeleteToken()
+	deleteTokenHash := hashToken(deleteToken)
+
+	du := &dump{
+		clientEncrypted:    isAgeEncrypted(up.head),
+		contentHash:        &up.hash,
+		contentType:        contentType,
+		deleteAfter:        deleteAfter,
+		deleteTokenHash:    &deleteTokenHash,
+		encryption:         encryptionServer,
+		filesystemID:       filesystemID,
+		idleExpiry:         tu.idleExpiry,
+		insertedAt:         time.Now(),
+		ipAddress:          tu.ipAddress,
+		ownerKeyHash:       tu.ownerKeyHash,
+		password:           tu.password,
+		passwordHash:       tu.passwordHash,
+		remainingDownloads: tu.maxDownloads,
+		size:               &up.size,
+		slug:               tu.slug,
+		username:           tu.username,
+	}
+	if err = a.insertDump(du); err != nil {
+		return "", "", err
+	}
+
+	if err = up.blob.Commit(filesystemID); err != nil {
+		a.db.deleteDumpByFilesystemID(filesystemID)
+		return "", "", err
+	}
+
+	if err = a.db.setTusUploadPublicID(tu.id, du.publicID); err != nil {
+		return "", "", err
+	}
+
+	// The chunks aren't needed anymore, the upload itself is kept until
+	// it expires so that the client can find the url of the dump.
+	for _, name := range names {
+		if err = a.store.Delete(name); err != nil {
+			log.Printf("failed to delete chunk %s: %v\n", name, err)
+		}
+	}
+
+	return du.publicID, deleteToken, nil
+}
+
+// tusChunkReader reads the decrypted contents of the chunks in order, a
+// chunk is opened when the previous one has been read.
+type tusChunkReader struct {
+	a     *app
+	names []string
+	rc    io.ReadCloser
+}
+
+// Read reads from the current chunk.
+func (cr *tusChunkReader) Read(p []byte) (int, error) {
+	for {
+		if cr.rc == nil {
+			if len(cr.names) == 0 {
+				return 0, io.EOF
+			}
+
+			rc, err := cr.a.openContents(&dump{filesystemID: cr.names[0], encryption: encryptionServer})
+			if err != nil {
+				return 0, err
+			}
+			cr.rc, cr.names = rc, cr.names[1:]
+		}
+
+		n, err := cr.rc.Read(p)
+		if err == io.EOF {
+			cr.rc.Close()
+			cr.rc = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+// Close closes the current chunk, if any.
+func (cr *tusChunkReader) Close() error {
+	if cr.rc == nil {
+		return nil
+	}
+
+	err := cr.rc.Close()
+	cr.rc = nil
+	return err
+}
@@ -1,13 +1,27 @@
 package main
 
 type UI struct {
-	IsMain    bool
-	IsText    bool
-	IsFile    bool
-	IsAbout   bool
-	IsError   bool
-	ErrorText string
-	Host      string
+	IsMain      bool
+	IsText      bool
+	IsFile      bool
+	IsAbout     bool
+	IsError     bool
+	IsCreated   bool
+	IsDelete    bool
+	IsDeleted   bool
+	IsUpdate    bool
+	IsUpdated   bool
+	IsDecrypt   bool
+	ErrorText   string
+	Host        string
+	DumpURL     string
+	DeleteURL   string
+	UpdateURL   string
+	PublicID    string
+	DeleteToken string
+	SlugPattern string
+
+	ExpiryOptions []expiryOption
 }
 
 const uiHTML = `<!DOCTYPE html>
@@ -21,6 +35,10 @@ const uiHTML = `<!DOCTYPE html>
 			{{if .IsFile}}dumpinen - file{{end}}
 			{{if .IsAbout}}dumpinen - about{{end}}
 			{{if .IsError}}dumpinen - error{{end}}
+			{{if .IsCreated}}dumpinen - dumped{{end}}
+			{{if or .IsDelete .IsDeleted}}dumpinen - delete{{end}}
+			{{if or .IsUpdate .IsUpdated}}dumpinen - update{{end}}
+			{{if .IsDecrypt}}dumpinen - decrypt{{end}}
 		</title>
 		<style type="text/css">
 			a {
@@ -66,24 +84,24 @@ const uiHTML = `<!DOCTYPE html>
 			<div id="navigation">
 				<nav>
 					{{if .IsMain }}
-					<a class="active" href="/">manual</a> |
+					<a class="active" href="{{.Host}}/">manual</a> |
 					{{else}}
-					<a href="/">manual</a> |
+					<a href="{{.Host}}/">manual</a> |
 					{{end}}
 					{{if .IsText }}
-					<a class="active" href="/text">text</a> |
+					<a class="active" href="{{.Host}}/text">text</a> |
 					{{else}}
-					<a href="/text">text</a> |
+					<a href="{{.Host}}/text">text</a> |
 					{{end}}
 					{{if .IsFile }}
-					<a class="active" hreF="/file">file</a> |
+					<a class="active" hreF="{{.Host}}/file">file</a> |
 					{{else}}
-					<a hreF="/file">file</a> |
+					<a hreF="{{.Host}}/file">file</a> |
 					{{end}}
 					{{if .IsAbout}}
-					<a class="active" hreF="/about">about</a>
+					<a class="active" hreF="{{.Host}}/about">about</a>
 					{{else}}
-					<a hreF="/about">about</a>
+					<a hreF="{{.Host}}/about">about</a>
 					{{end}}
 				</nav>
 			</div>
@@ -96,6 +114,139 @@ const uiHTML = `<!DOCTYPE html>
 					</div>
 				</div>
 				{{end}}
+				{{if .IsCreated}}
+				<div class="row">
+					<div class="rowNarrow">
+						<p>Your dump is available at:</p>
+					</div>
+					<div class="rowNarrow">
+						<a href="{{.DumpURL}}">{{.DumpURL}}</a>
+					</div>
+				</div>
+				<div class="row">
+					<div class="rowNarrow">
+						<p>Keep these links if you want to be able to update or delete the dump, they are only shown once:</p>
+					</div>
+					<div class="rowNarrow">
+						<a href="{{.UpdateURL}}">update</a> |
+						<a href="{{.DeleteURL}}">delete</a>
+					</div>
+				</div>
+				{{end}}
+				{{if .IsDelete}}
+				<form action="{{.Host}}/delete" method="post">
+					<div class="row">
+						<div class="rowNarrow">
+							<p>Do you want to delete the dump {{.PublicID}}?</p>
+						</div>
+						<input type="hidden" name="id" value="{{.PublicID}}">
+						<input type="hidden" name="token" value="{{.DeleteToken}}">
+					</div>
+					<div class="row">
+						<button>Delete</button>
+					</div>
+				</form>
+				{{end}}
+				{{if .IsUpdate}}
+				<form action="{{.Host}}/update" method="post">
+					<input type="hidden" name="id" value="{{.PublicID}}">
+					<input type="hidden" name="token" value="{{.DeleteToken}}">
+					<div class="row">
+						<div class="rowNarrow">
+							<p>Update the dump {{.PublicID}}.</p>
+						</div>
+					</div>
+					<div class="row">
+						<div class="rowNarrow">
+							<p>Dump lifetime from now.</p>
+						</div>
+						<div class="rowNarrow">
+							<select name="deleteAfter">
+								<option value="unchanged" selected>Unchanged</option>
+								{{range .ExpiryOptions}}
+								<option value="{{.Value}}">{{.Label}}</option>
+								{{end}}
+							</select>
+						</div>
+					</div>
+					<div class="row">
+						<div class="rowNarrow">
+							<p>Fill in the content type to change it.</p>
+						</div>
+						<div class="rowNarrow">
+							<label>Content type:</label><input type="text" name="contentType">
+						</div>
+					</div>
+					<div class="row">
+						<div class="rowNarrow">
+							<p>Fill in a username and password to protect the dump, or remove the protection.</p>
+						</div>
+						<div class="rowNarrow">
+							<label>Username:</label><input type="text" name="username">
+						</div>
+						<div class="rowNarrow">
+							<label>Password:</label><input type="password" name="password">
+						</div>
+						<div class="rowNarrow">
+							<label>Remove protection:</label><input type="checkbox" name="unprotect" value="1">
+						</div>
+					</div>
+					<div class="row">
+						<button>Update</button>
+					</div>
+				</form>
+				{{end}}
+				{{if .IsUpdated}}
+				<div class="row">
+					<div class="rowNarrow">
+						<p>The dump has been updated:</p>
+					</div>
+					<div class="rowNarrow">
+						<a href="{{.DumpURL}}">{{.DumpURL}}</a>
+					</div>
+				</div>
+				{{end}}
+				{{if .IsDecrypt}}
+				<div id="decrypt" data-id="{{.PublicID}}">
+					<div class="row">
+						<div class="rowNarrow">
+							<p>The dump {{.PublicID}} is encrypted, it is decrypted in your browser and the key is never sent to the server.</p>
+						</div>
+					</div>
+					<form id="decryptForm">
+						<div class="row">
+							<div class="rowNarrow">
+								<p>Enter the age identity (AGE-SECRET-KEY-1...) or the passphrase.</p>
+							</div>
+							<div class="rowNarrow">
+								<label>Key:</label><input autofocus required type="password" id="decryptKey">
+							</div>
+						</div>
+						<div class="row">
+							<button>Decrypt</button>
+						</div>
+					</form>
+					<div class="row">
+						<div class="rowNarrow">
+							<p id="decryptStatus"></p>
+						</div>
+						<div class="rowNarrow">
+							<a hidden id="decryptDownload">download</a>
+						</div>
+					</div>
+					<div class="row">
+						<textarea hidden readonly id="decryptOutput"></textarea>
+					</div>
+				</div>
+				<script src="{{.Host}}/decrypt.js"></script>
+				{{end}}
+				{{if .IsDeleted}}
+				<div class="row">
+					<div class="rowNarrow">
+						<p>The dump has been deleted.</p>
+					</div>
+				</div>
+				{{end}}
 				{{if .IsMain}}
 				<div class="row">
 					<div class="rowNarrow">
@@ -152,6 +303,28 @@ const uiHTML = `<!DOCTYPE html>
 						foo
 					</div>
 				</div>
+				<div class="row">
+					<div class="rowNarrow">
+						# Dump "foo" encrypted with a passphrase that the server never sees:
+					</div>
+					<div class="rowNarrow">
+						echo "foo" | age -p | curl --data-binary @- {{.Host}}
+					</div>
+					<div class="rowNarrow">
+						{{.Host}}/Xq3dF0cXrKp
+					</div>
+				</div>
+				<div class="row">
+					<div class="rowNarrow">
+						# Get and decrypt the dump, browsers decrypts it on the page they are sent to:
+					</div>
+					<div class="rowNarrow">
+						curl {{.Host}}/Xq3dF0cXrKp | age -d
+					</div>
+					<div class="rowNarrow">
+						foo
+					</div>
+				</div>
 				{{end}}
 				{{if .IsAbout}}
 				<div class="row">
@@ -182,10 +355,10 @@ const uiHTML = `<!DOCTYPE html>
 				{{end}}
 				{{if or .IsText .IsFile}}
 				{{if .IsText }}
-				<form action="/dump" method="post">
+				<form action="{{.Host}}/dump" method="post">
 				{{end}}
 				{{if .IsFile }}
-				<form action="/dump" enctype="multipart/form-data" method="post">
+				<form action="{{.Host}}/dump" enctype="multipart/form-data" method="post">
 				{{end}}
 					{{if .IsText}}
 					<div class="row">
@@ -213,14 +386,33 @@ const uiHTML = `<!DOCTYPE html>
 						</div>
 						<div class="rowNarrow">
 							<select name="deleteAfter">
-								<option value="">Infinite</option>
-								<option value="10m">Ten minutes</option>
-								<option value="1h">One hour</option>
-								<option value="24h">24 hours</option>
-								<option value="168h">One week</option>
+								{{range .ExpiryOptions}}
+								<option value="{{.Value}}"{{if .Selected}} selected{{end}}>{{.Label}}</option>
+								{{end}}
 							</select>
 						</div>
 					</div>
+					<div class="row">
+						<div class="rowNarrow">
+							<p>Delete the dump after a number of downloads.</p>
+						</div>
+						<div class="rowNarrow">
+							<label>Max downloads:</label><input type="number" min="1" name="maxDownloads">
+						</div>
+						<div class="rowNarrow">
+							<label>Burn after reading:</label><input type="checkbox" name="burnAfterRead" value="1">
+						</div>
+					</div>
+					{{if .SlugPattern}}
+					<div class="row">
+						<div class="rowNarrow">
+							<p>Choose a custom link, e.g. oncall-checklist, instead of a random one.</p>
+						</div>
+						<div class="rowNarrow">
+							<label>Slug:</label><input type="text" name="slug" pattern="{{.SlugPattern}}">
+						</div>
+					</div>
+					{{end}}
 					<div class="row">
 						<div class="rowNarrow">
 							<p>Fill in the username and password to protect your dump.</p>
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"filippo.io/age"
+)
+
+// sniffLen is the number of bytes that http.DetectContentType considers.
+const sniffLen = 512
+
+// errEmptyUpload is returned when an upload doesn't contain any data.
+var errEmptyUpload = errors.New("empty upload")
+
+// upload holds the contents of a dump that has been streamed to a temporary
+// blob.
+type upload struct {
+	blob blobWriter
+	size int64
+	hash string
+	head []byte
+}
+
+// newUpload encrypts the contents of the reader to the recipient and streams
+// it to a new temporary blob while the size and SHA-256 hash of the contents
+// are calculated. The first bytes of the contents are kept in memory so that the
+// content type can be detected, the rest of the contents are never buffered.
+// It is up to the caller to commit or abort the blob.
+func (a *app) newUpload(r io.Reader, recipient age.Recipient) (*upload, error) {
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, errEmptyUpload
+	}
+	head = head[:n]
+
+	blob, err := a.store.Create()
+	if err != nil {
+		return nil, err
+	}
+
+	ew, err := age.Encrypt(blob, recipient)
+	if err != nil {
+		blob.Abort()
+		return nil, fmt.Errorf("failed to create encrypted file: %v", err)
+	}
+
+	h := sha256.New()
+	w := io.MultiWriter(ew, h)
+	if _, err = w.Write(head); err != nil {
+		blob.Abort()
+		return nil, fmt.Errorf("failed to write to encrypted file: %v", err)
+	}
+	size, err := io.Copy(w, r)
+	if err != nil {
+		blob.Abort()
+		return nil, err
+	}
+
+	if err = ew.Close(); err != nil {
+		blob.Abort()
+		return nil, fmt.Errorf("failed to close encrypted file: %v", err)
+	}
+
+	return &upload{
+		blob: blob,
+		size: int64(n) + size,
+		hash: hex.EncodeToString(h.Sum(nil)),
+		head: head,
+	}, nil
+}
+
+// isRequestTooLarge returns true if the error was caused by the request body
+// exceeding the limit of the max bytes reader.
+func isRequestTooLarge(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "http: request body too large")
+}
@@ -4,8 +4,16 @@ import (
 	"crypto/rand"
 	"fmt"
 	"io"
+	"regexp"
 )
 
+// isValidUUID is a regular expression that checks that the given data is a
+// valid UUID.
+var isValidUUID = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`).MatchString
+
+// nilUUID is the smallest UUID, the paginated queries start after it.
+const nilUUID = "00000000-0000-0000-0000-000000000000"
+
 // newUUID generates a random UUID according to RFC 4122
 func newUUID() string {
 	// Generate 16 random bytes